@@ -1,196 +1,291 @@
 package main
 
 import (
+	"bytes"
+	"io"
 	"log"
 )
 
+// buffer is a document that is being edited: its text, its file format, its
+// edit history and everything else that belongs to the text. How the text is
+// shown, e.g. the position of the cursor, is part of the views of the buffer,
+// see view.go.
 type buffer struct {
 	fname    string
-	lines    [][]rune
-	x        int
-	y        int
-	offset   int
+	text     *pieceTable
 	modified bool
-
-	// fields to track selected text:
-	selecting bool
-	startX    int
-	startY    int
-	endX      int
-	endY      int
-
-	// stuff to track edit history for undo/redo:
-	editHistory []*editOp
-	historyIdx  int
-
-	findLastLine int
-	findPhrase   []rune
+	changes  int // number of edits, to tell whether the swap file is outdated.
+
+	// views of the splits that show the buffer, see updateViews, and the view
+	// it was last shown with, which a split gets when it shows the buffer
+	// again. Edits move the views along with the text.
+	views    []*view
+	lastView *view
+
+	marks  map[int]lineMark // markers shown in the gutter, see marks.go.
+	syntax *highlighter     // syntax highlighting state, see syntax.go.
+
+	// details of the file format that are restored when saving:
+	crlf  bool // lines end with CR LF instead of LF.
+	bom   bool // the file starts with a UTF-8 byte order mark.
+	noEOL bool // the last line isn't terminated by a line break.
+
+	disk fileState // the file as it was last loaded or saved.
+
+	// edit history for undo/redo, see undotree.go and edit.go:
+	history      *undoTree
+	savedState   *undoNode   // the state of the text when it was last loaded or saved, nil if unknown.
+	change       *editOp     // edits of the current change.
+	changeDepth  int         // nesting level of beginChange calls.
+	changeCursor cursorState // cursor when the current change began.
+
+	findPhrase  []rune
+	findOptions searchOptions
+
+	// occurrences of highlightPhrase are highlighted, e.g. while searching.
+	highlightPhrase []rune
+
+	// read-only buffers refuse all edits, readOnlyEdit records that an edit
+	// was refused.
+	readOnly     bool
+	readOnlyEdit bool
+
+	// results buffers map their lines to the locations of results, see
+	// results.go. stopSearch stops the search that adds results, if it is
+	// still running.
+	results     map[int]resultLoc
+	resultCount int
+	stopSearch  func()
+
+	replacePattern []rune
+	replaceText    []rune
+
+	// swap file state, see swap.go:
+	swapName    string // swap file written for this buffer, if any.
+	swapChanges int    // value of changes when the swap file was written.
+	swapFound   bool   // a swap file was left behind by a previous session.
 }
 
-func (buf *buffer) getSelection() (lowerY, lowerX, higherY, higherX int) {
-	lowerY, lowerX, higherY, higherX = buf.startY, buf.startX, buf.endY, buf.endX
-
-	if lowerY > higherY {
-		lowerY, higherY = higherY, lowerY
-		lowerX, higherX = higherX, lowerX
-	} else if lowerY == higherY && lowerX > higherX {
-		lowerX, higherX = higherX, lowerX
+func newBuffer(data []byte) *buffer {
+	buf := &buffer{
+		text:    newPieceTable(data),
+		history: newUndoTree(),
 	}
-
-	return
+	buf.lastView = &view{buf: buf}
+	buf.savedState = buf.history.root
+	return buf
 }
 
-func (buf *buffer) isWithinSelectedText(y, x int) bool {
-	lowerY, lowerX, higherY, higherX := buf.getSelection()
+var (
+	utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+	lf      = []byte("\n")
+	crlf    = []byte("\r\n")
+)
 
-	if lowerY == higherY && lowerX == higherX {
-		return false
+// newBufferFromFileContent creates a buffer from the content of a file. The
+// byte order mark, the final line break and the CRs of CRLF line breaks are
+// removed from the text and recorded in the buffer instead. CRs are only
+// removed if all lines end with CRLF, so that files with mixed line endings
+// are saved unchanged. The buffer takes over data, whose CRs are removed in
+// place, so data must not be used afterwards.
+func newBufferFromFileContent(data []byte) *buffer {
+	bom := bytes.HasPrefix(data, utf8BOM)
+	data = bytes.TrimPrefix(data, utf8BOM)
+
+	isCRLF := false
+	if n := bytes.Count(data, crlf); n > 0 && n == bytes.Count(data, lf) {
+		isCRLF = true
+		data = stripCR(data)
 	}
 
-	if y > lowerY && y < higherY {
-		return true
-	}
-	if y == lowerY && x >= lowerX && (y < higherY || (y == higherY && x < higherX)) {
-		return true
-	}
-	if y == higherY && x < higherX && (y > lowerY || (y == lowerY && x >= lowerX)) {
-		return true
-	}
-	return false
-}
+	noEOL := !bytes.HasSuffix(data, lf)
+	data = bytes.TrimSuffix(data, lf)
 
-func (buf *buffer) incrY(height int) {
-	if buf.y < height-3 {
-		buf.y++
-	} else {
-		buf.offset++
-	}
-}
+	buf := newBuffer(data)
+	buf.crlf, buf.bom, buf.noEOL = isCRLF, bom, noEOL
 
-func (buf *buffer) decrY() {
-	if buf.offset > 0 {
-		buf.offset--
-	} else {
-		buf.y--
-	}
+	return buf
 }
 
-func (buf *buffer) correctX() {
-	if l := len(buf.lines[buf.curLineIdx()]); l < buf.x {
-		buf.x = l
+// stripCR removes the CRs of the CRLF line breaks in data in place, so that
+// large files aren't copied when they are loaded.
+func stripCR(data []byte) []byte {
+	n := 0
+	for idx, b := range data {
+		if b == '\r' && idx+1 < len(data) && data[idx+1] == '\n' {
+			continue
+		}
+		data[n] = b
+		n++
 	}
+	return data[:n]
 }
 
-func (buf *buffer) correctY() {
-	for buf.curLineIdx() >= len(buf.lines) {
-		buf.decrY()
+// writeFileContent writes the text of the buffer in its file format.
+func (buf *buffer) writeFileContent(w io.Writer) error {
+	if buf.bom {
+		if _, err := w.Write(utf8BOM); err != nil {
+			return err
+		}
 	}
-}
-
-func (buf *buffer) curLineIdx() int {
-	return buf.y + buf.offset
-}
-
-func (buf *buffer) curLine() []rune {
-	return buf.lines[buf.curLineIdx()]
-}
 
-func (buf *buffer) getOrCreateLatestOp(code opcode) *editOp {
-	op := &editOp{
-		op:       code,
-		text:     [][]rune{{}},
-		y:        buf.curLineIdx(),
-		x:        buf.x,
-		finished: false,
+	if buf.crlf {
+		w = &crlfWriter{w: w}
 	}
 
-	if len(buf.editHistory) == 0 {
-		log.Printf("getOrCreateLatestOp: no edit history, creating new op")
-		buf.editHistory = append(buf.editHistory, op)
-		buf.historyIdx++
-		return op
+	if err := buf.text.writeTo(w); err != nil {
+		return err
 	}
 
-	if op := buf.editHistory[buf.historyIdx]; op.op == code && !op.finished {
-		log.Printf("getOrCreateLatestOp: returning op at index %d", buf.historyIdx)
-		return op
+	if !buf.noEOL {
+		if _, err := w.Write(lf); err != nil {
+			return err
+		}
 	}
 
-	buf.editHistory = append(buf.editHistory[:buf.historyIdx+1], op)
-	buf.historyIdx++
-	log.Printf("getOrCreateLatestOp: added op at index %d", buf.historyIdx)
-
-	return op
+	return nil
 }
 
-func (buf *buffer) historyAddRune(r rune) {
-	op := buf.getOrCreateLatestOp(opInsertText)
+// replaceContent replaces the text and file format of buf with those of
+// other, e.g. when reloading a file. The edit history is discarded, the
+// cursors of all views stay where they are if possible.
+func (buf *buffer) replaceContent(other *buffer) {
+	buf.text = other.text
+	buf.crlf, buf.bom, buf.noEOL = other.crlf, other.bom, other.noEOL
+	buf.history = newUndoTree()
+	buf.savedState = buf.history.root
+	buf.changes++
+	buf.syntax = nil
+
+	buf.clearMarks(markModified)
+	for y := range buf.marks {
+		if y >= buf.lineCount() {
+			delete(buf.marks, y)
+		}
+	}
 
-	op.text[len(op.text)-1] = append(op.text[len(op.text)-1], r)
+	for _, v := range buf.allViews() {
+		v.selecting, v.blockMode = false, false
+		v.correctY()
+		v.correctX()
+	}
+}
 
-	log.Printf("historyAddRune: %d lines", len(op.text))
-	for idx, line := range op.text {
-		log.Printf("historyAddRune: line %d: %s", idx, string(line))
+// snapshot returns a copy of the buffer's text and file format that isn't
+// affected by later edits, so it can be written from another goroutine.
+func (buf *buffer) snapshot() *buffer {
+	return &buffer{
+		fname: buf.fname,
+		text:  buf.text.snapshot(),
+		crlf:  buf.crlf,
+		bom:   buf.bom,
+		noEOL: buf.noEOL,
 	}
 }
 
-func (buf *buffer) historyFinishOp() {
-	if len(buf.editHistory) == 0 {
-		return
+// formatName describes the file format of the buffer for the status line.
+func (buf *buffer) formatName() string {
+	name := "LF"
+	if buf.crlf {
+		name = "CRLF"
 	}
-	buf.editHistory[buf.historyIdx].finished = true
+	if buf.bom {
+		name += " BOM"
+	}
+	if buf.noEOL {
+		name += " noEOL"
+	}
+	return name
 }
 
-func (buf *buffer) historyAddLine() {
-	op := buf.getOrCreateLatestOp(opInsertText)
+// crlfWriter converts LF line breaks to CR LF while writing.
+type crlfWriter struct {
+	w io.Writer
+}
 
-	op.text = append(op.text, []rune{})
-	log.Printf("historyAddLine: %d lines", len(op.text))
-	for idx, line := range op.text {
-		log.Printf("historyAddLine: line %d: %s", idx, string(line))
+func (cw *crlfWriter) Write(p []byte) (int, error) {
+	if _, err := cw.w.Write(bytes.ReplaceAll(p, lf, crlf)); err != nil {
+		return 0, err
 	}
+	return len(p), nil
 }
 
-func (buf *buffer) historyRemoveLine() {
-	op := buf.getOrCreateLatestOp(opRemoveText)
+func (buf *buffer) lineCount() int {
+	return buf.text.lineCount()
+}
 
-	op.text = append([][]rune{{}}, op.text...)
-	op.y = buf.curLineIdx()
-	op.x = buf.x
+// line returns a copy of the runes of line y.
+func (buf *buffer) line(y int) []rune {
+	return buf.text.line(y)
 }
 
-func (buf *buffer) historyRemoveChar(r rune) {
-	op := buf.getOrCreateLatestOp(opRemoveText)
+func (buf *buffer) lineLen(y int) int {
+	return buf.text.lineLen(y)
+}
 
-	op.text[0] = append([]rune{r}, op.text[0]...)
-	op.y = buf.curLineIdx()
-	op.x = buf.x
+// lines returns the whole text of the buffer. As it decodes every line, it
+// should only be used for small buffers.
+func (buf *buffer) lines() [][]rune {
+	lines := make([][]rune, 0, buf.lineCount())
+	for y := 0; y < buf.lineCount(); y++ {
+		lines = append(lines, buf.line(y))
+	}
+	return lines
 }
 
-func (buf *buffer) find(phrase []rune) (y, x int, found bool) {
-	if !runeEqual(buf.findPhrase, phrase) {
-		buf.findLastLine = buf.curLineIdx()
-		if buf.findLastLine == 0 {
-			buf.findLastLine = len(buf.lines) - 1
-		} else {
-			buf.findLastLine--
-		}
+// insert inserts text at position x of line y. Every element of text except
+// for the last one is terminated by a line break. The views of buf other than
+// from, which made the edit, move along with the text; from may be nil.
+func (buf *buffer) insert(y, x int, text [][]rune, from *view) {
+	buf.text.insert(buf.text.offset(y, x), joinLines(text))
+	buf.changes++
+	buf.marksInserted(y, x, len(text)-1)
+	buf.adjustViews(&editOp{op: opInsertText, text: text, y: y, x: x}, from)
+	if buf.syntax != nil {
+		buf.syntax.invalidate(y)
 	}
+}
 
-	for y := buf.findLastLine + 1; y < len(buf.lines); y++ {
-		if x := runeIndex(buf.lines[y], phrase); x >= 0 {
-			buf.findLastLine = y
-			return y, x, true
+// remove removes the text from position x of line y up to but excluding
+// position endX of line endY. Views move like with insert.
+func (buf *buffer) remove(y, x, endY, endX int, from *view) {
+	// the other views need to know which text is removed to follow it.
+	var op *editOp
+	for _, v := range buf.allViews() {
+		if v != from {
+			op = &editOp{op: opRemoveText, text: buf.textRange(y, x, endY, endX), y: y, x: x}
+			break
 		}
 	}
-	for idx := 0; idx <= buf.findLastLine; idx++ {
-		if x := runeIndex(buf.lines[y], phrase); x >= 0 {
-			buf.findLastLine = y
-			return y, x, true
-		}
+
+	start, end := buf.text.offset(y, x), buf.text.offset(endY, endX)
+	buf.text.delete(start, end-start)
+	buf.changes++
+	buf.marksRemoved(y, endY)
+	if op != nil {
+		buf.adjustViews(op, from)
+	}
+	if buf.syntax != nil {
+		buf.syntax.invalidate(y)
+	}
+}
+
+// columnAt returns the display column at which the character at index x in line y starts.
+func (buf *buffer) columnAt(y, x int) int {
+	if y >= buf.lineCount() {
+		return 0
 	}
+	line := buf.line(y)
+	if x > len(line) {
+		x = len(line)
+	}
+	return runeWidth(line[:x])
+}
 
-	return 0, 0, false
+func (buf *buffer) historyFinishOp() {
+	if op := buf.history.cur.op; op != nil {
+		op.finished = true
+	}
 }
 
 type editOp struct {
@@ -199,6 +294,9 @@ type editOp struct {
 	y        int
 	x        int
 	finished bool
+
+	// only used by opGroup: ops that are undone and redone together.
+	children []*editOp
 }
 
 type opcode int
@@ -206,69 +304,51 @@ type opcode int
 const (
 	opInsertText opcode = iota
 	opRemoveText
+	opGroup
 )
 
-func (op *editOp) undo(buf *buffer) {
+// undo reverts op in the buffer of v. The other views of the buffer move
+// along with the text, v is left to the caller.
+func (op *editOp) undo(v *view) {
 	log.Printf("editOp.undo: op = %d y = %d x = %d", op.op, op.y, op.x)
 	for idx, line := range op.text {
 		log.Printf("editOp.undo: line %d: %s", idx, string(line))
 	}
-	for idx, line := range buf.lines {
-		log.Printf("editOp.undo: buf line %d: %s", idx, string(line))
-	}
 	switch op.op {
 	case opInsertText:
-		op.removeText(buf)
-		for idx, line := range buf.lines {
-			log.Printf("editOp.undo: after buf line %d: %s", idx, string(line))
-		}
+		op.removeText(v)
 	case opRemoveText:
-		op.insertText(buf)
+		op.insertText(v)
+	case opGroup:
+		for i := len(op.children) - 1; i >= 0; i-- {
+			op.children[i].undo(v)
+		}
 	}
 }
 
-func (op *editOp) redo(buf *buffer) {
+// redo applies op again, see undo.
+func (op *editOp) redo(v *view) {
 	switch op.op {
 	case opInsertText:
-		op.insertText(buf)
+		op.insertText(v)
 	case opRemoveText:
-		op.removeText(buf)
+		op.removeText(v)
+	case opGroup:
+		for _, child := range op.children {
+			child.redo(v)
+		}
 	}
 }
 
-func (op *editOp) removeText(buf *buffer) {
-	for idx, line := range buf.lines {
-		log.Printf("removeText: before: line %d: %q", idx, string(line))
-	}
+func (op *editOp) removeText(v *view) {
 	log.Printf("removeText: op: y = %d x = %d len(op.text) = %d", op.y, op.x, len(op.text))
 	for idx, line := range op.text {
 		log.Printf("removeText: op buf line %d: %q", idx, string(line))
 	}
-	if len(op.text) == 1 {
-		buf.lines[op.y] = append(buf.lines[op.y][:op.x], buf.lines[op.y][op.x+len(op.text[0]):]...)
-	} else {
-		log.Printf("removeText: before part: %d %d %q", op.y, op.x, string(buf.lines[op.y][:op.x]))
-		log.Printf("removeText: after part: %d %d %q", op.y+len(op.text)-1, len(op.text[len(op.text)-1]), string(buf.lines[op.y+len(op.text)-1][len(op.text[len(op.text)-1:])]))
-		buf.lines[op.y] = append(buf.lines[op.y][:op.x], buf.lines[op.y+len(op.text)-1][len(op.text[len(op.text)-1]):]...)
-		log.Printf("removeText: new line %d: %q", op.y, string(buf.lines[op.y]))
-		buf.lines = append(buf.lines[:op.y+1], buf.lines[op.y+len(op.text):]...)
-	}
-	for idx, line := range buf.lines {
-		log.Printf("removeText: after: line %d: %q", idx, string(line))
-	}
+	endY, endX := op.end()
+	v.buf.remove(op.y, op.x, endY, endX, v)
 }
 
-func (op *editOp) insertText(buf *buffer) {
-	if len(op.text) == 1 {
-		buf.lines[op.y] = append(buf.lines[op.y][:op.x], append(op.text[0], buf.lines[op.y][op.x:]...)...)
-	} else {
-		insertion := [][]rune{}
-		insertion = append(insertion, op.text...)
-
-		beforeInsertion, afterInsertion := buf.lines[op.y][:op.x], buf.lines[op.y][op.x:]
-		insertion[0] = append(append([]rune{}, beforeInsertion...), insertion[0]...)
-		insertion[len(insertion)-1] = append(insertion[len(insertion)-1], afterInsertion...)
-
-		buf.lines = append(buf.lines[:op.y], append(insertion, buf.lines[op.y+1:]...)...)
-	}
+func (op *editOp) insertText(v *view) {
+	v.buf.insert(op.y, op.x, op.text, v)
 }
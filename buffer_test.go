@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -8,28 +9,28 @@ import (
 
 func TestGetSelection(t *testing.T) {
 	testData := map[string]struct {
-		buf             *buffer
+		v               *view
 		expectedLowerY  int
 		expectedLowerX  int
 		expectedHigherY int
 		expectedHigherX int
 	}{
 		"simple": {
-			buf:             &buffer{startY: 0, startX: 23, endY: 3, endX: 42},
+			v:               &view{startY: 0, startX: 23, endY: 3, endX: 42},
 			expectedLowerY:  0,
 			expectedLowerX:  23,
 			expectedHigherY: 3,
 			expectedHigherX: 42,
 		},
 		"reversed": {
-			buf:             &buffer{startY: 3, startX: 23, endY: 0, endX: 42},
+			v:               &view{startY: 3, startX: 23, endY: 0, endX: 42},
 			expectedLowerY:  0,
 			expectedLowerX:  42,
 			expectedHigherY: 3,
 			expectedHigherX: 23,
 		},
 		"same-line-reversed": {
-			buf:             &buffer{startY: 0, startX: 15, endY: 0, endX: 3},
+			v:               &view{startY: 0, startX: 15, endY: 0, endX: 3},
 			expectedLowerY:  0,
 			expectedLowerX:  3,
 			expectedHigherY: 0,
@@ -39,7 +40,7 @@ func TestGetSelection(t *testing.T) {
 
 	for testName, tt := range testData {
 		t.Run(testName, func(t *testing.T) {
-			lowerY, lowerX, higherY, higherX := tt.buf.getSelection()
+			lowerY, lowerX, higherY, higherX := tt.v.getSelection()
 			assert.Equal(t, tt.expectedLowerY, lowerY)
 			assert.Equal(t, tt.expectedLowerX, lowerX)
 			assert.Equal(t, tt.expectedHigherY, higherY)
@@ -50,55 +51,55 @@ func TestGetSelection(t *testing.T) {
 
 func TestIsWithinSelectedText(t *testing.T) {
 	testData := map[string]struct {
-		buf            *buffer
+		v              *view
 		y              int
 		x              int
 		expectedResult bool
 	}{
 		"simple": {
-			buf:            &buffer{startY: 1, startX: 5, endY: 3, endX: 10},
+			v:              &view{startY: 1, startX: 5, endY: 3, endX: 10},
 			y:              2,
 			x:              3,
 			expectedResult: true,
 		},
 		"simple-first-line": {
-			buf:            &buffer{startY: 1, startX: 5, endY: 3, endX: 10},
+			v:              &view{startY: 1, startX: 5, endY: 3, endX: 10},
 			y:              1,
 			x:              10,
 			expectedResult: true,
 		},
 		"simple-last-line": {
-			buf:            &buffer{startY: 1, startX: 5, endY: 3, endX: 10},
+			v:              &view{startY: 1, startX: 5, endY: 3, endX: 10},
 			y:              3,
 			x:              7,
 			expectedResult: true,
 		},
 		"outside-first-line": {
-			buf:            &buffer{startY: 1, startX: 5, endY: 3, endX: 10},
+			v:              &view{startY: 1, startX: 5, endY: 3, endX: 10},
 			y:              1,
 			x:              0,
 			expectedResult: false,
 		},
 		"outside-last-line": {
-			buf:            &buffer{startY: 1, startX: 5, endY: 3, endX: 10},
+			v:              &view{startY: 1, startX: 5, endY: 3, endX: 10},
 			y:              3,
 			x:              20,
 			expectedResult: false,
 		},
 		"outside-before-first-line": {
-			buf:            &buffer{startY: 1, startX: 5, endY: 3, endX: 10},
+			v:              &view{startY: 1, startX: 5, endY: 3, endX: 10},
 			y:              0,
 			x:              20,
 			expectedResult: false,
 		},
 		"outside-after-last-line": {
-			buf:            &buffer{startY: 1, startX: 5, endY: 3, endX: 10},
+			v:              &view{startY: 1, startX: 5, endY: 3, endX: 10},
 			y:              5,
 			x:              3,
 			expectedResult: false,
 		},
 		"empty-selection": {
-			buf:            &buffer{startY: 1, startX: 5, endY: 1, endX: 5},
+			v:              &view{startY: 1, startX: 5, endY: 1, endX: 5},
 			y:              1,
 			x:              5,
 			expectedResult: false,
@@ -107,8 +108,125 @@ func TestIsWithinSelectedText(t *testing.T) {
 
 	for testName, tt := range testData {
 		t.Run(testName, func(t *testing.T) {
-			result := tt.buf.isWithinSelectedText(tt.y, tt.x)
+			result := tt.v.isWithinSelectedText(tt.y, tt.x)
 			assert.Equal(t, tt.expectedResult, result)
 		})
 	}
 }
+
+func TestIsWithinSelectedBlock(t *testing.T) {
+	text := newPieceTable([]byte("abcdef\n\tx\n例子例子"))
+
+	testData := map[string]struct {
+		v              *view
+		y              int
+		col            int
+		expectedResult bool
+	}{
+		"inside": {
+			v:              &view{buf: &buffer{text: text}, startY: 0, startX: 1, endY: 2, endX: 2},
+			y:              1,
+			col:            3,
+			expectedResult: true,
+		},
+		"left-of-block": {
+			v:              &view{buf: &buffer{text: text}, startY: 0, startX: 1, endY: 2, endX: 2},
+			y:              0,
+			col:            0,
+			expectedResult: false,
+		},
+		"right-of-block": {
+			v:              &view{buf: &buffer{text: text}, startY: 0, startX: 1, endY: 2, endX: 2},
+			y:              2,
+			col:            4,
+			expectedResult: false,
+		},
+		"reversed-columns": {
+			v:              &view{buf: &buffer{text: text}, startY: 2, startX: 2, endY: 0, endX: 1},
+			y:              0,
+			col:            2,
+			expectedResult: true,
+		},
+		"tab-column": {
+			v:              &view{buf: &buffer{text: text}, startY: 0, startX: 0, endY: 1, endX: 1},
+			y:              0,
+			col:            5,
+			expectedResult: true,
+		},
+		"outside-lines": {
+			v:              &view{buf: &buffer{text: text}, startY: 0, startX: 1, endY: 1, endX: 1},
+			y:              2,
+			col:            3,
+			expectedResult: false,
+		},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			result := tt.v.isWithinSelectedBlock(tt.y, tt.col)
+			assert.Equal(t, tt.expectedResult, result)
+		})
+	}
+}
+
+func TestFileContentRoundTrip(t *testing.T) {
+	testData := map[string]struct {
+		data          string
+		expectedLines [][]rune
+		expectedCRLF  bool
+		expectedBOM   bool
+		expectedNoEOL bool
+	}{
+		"lf": {
+			data:          "a\nb\n",
+			expectedLines: [][]rune{[]rune("a"), []rune("b")},
+		},
+		"crlf": {
+			data:          "a\r\nb\r\n",
+			expectedLines: [][]rune{[]rune("a"), []rune("b")},
+			expectedCRLF:  true,
+		},
+		"crlf-no-eol": {
+			data:          "a\r\nb",
+			expectedLines: [][]rune{[]rune("a"), []rune("b")},
+			expectedCRLF:  true,
+			expectedNoEOL: true,
+		},
+		"bom": {
+			data:          "\xEF\xBB\xBFa\n",
+			expectedLines: [][]rune{[]rune("a")},
+			expectedBOM:   true,
+		},
+		"mixed": {
+			data:          "a\r\nb\n",
+			expectedLines: [][]rune{[]rune("a\r"), []rune("b")},
+		},
+		"empty": {
+			data:          "",
+			expectedLines: [][]rune{{}},
+			expectedNoEOL: true,
+		},
+		"empty-line": {
+			data:          "\n",
+			expectedLines: [][]rune{{}},
+		},
+		"blank-lines-at-end": {
+			data:          "a\n\n\n",
+			expectedLines: [][]rune{[]rune("a"), {}, {}},
+		},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			buf := newBufferFromFileContent([]byte(tt.data))
+			assert.Equal(t, tt.expectedLines, buf.lines())
+			assert.Equal(t, tt.expectedCRLF, buf.crlf)
+			assert.Equal(t, tt.expectedBOM, buf.bom)
+			assert.Equal(t, tt.expectedNoEOL, buf.noEOL)
+
+			var out bytes.Buffer
+			assert.NoError(t, buf.writeFileContent(&out))
+			assert.Equal(t, tt.data, out.String())
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+)
+
+// fuzzyMatch returns whether the runes of pattern appear in s in the same
+// order, the positions in s where they do, and a score of how well they
+// match: runes that follow each other in s, and runes at the start of a word
+// or of a path element, count more. Case is ignored unless pattern contains
+// upper case letters.
+func fuzzyMatch(pattern, s []rune) (positions []int, score int, ok bool) {
+	if len(pattern) == 0 {
+		return nil, 0, true
+	}
+
+	ignoreCase := true
+	for _, r := range pattern {
+		if unicode.IsUpper(r) {
+			ignoreCase = false
+		}
+	}
+
+	equal := func(r, p rune) bool {
+		if ignoreCase {
+			return unicode.ToLower(r) == unicode.ToLower(p)
+		}
+		return r == p
+	}
+
+	// try every place where the first rune matches, and keep the best.
+	for start := range s {
+		if !equal(s[start], pattern[0]) {
+			continue
+		}
+
+		var pos []int
+		sc, i := 0, 0
+		for x := start; x < len(s) && i < len(pattern); x++ {
+			if !equal(s[x], pattern[i]) {
+				continue
+			}
+
+			sc++
+			if len(pos) > 0 && pos[len(pos)-1] == x-1 {
+				sc += 4
+			}
+			if x == 0 || !unicode.IsLetter(s[x-1]) && !unicode.IsDigit(s[x-1]) {
+				sc += 3
+			}
+
+			pos = append(pos, x)
+			i++
+		}
+
+		if i < len(pattern) {
+			break // a later start can't match either.
+		}
+
+		if !ok || sc > score {
+			positions, score, ok = pos, sc, true
+		}
+	}
+
+	return positions, score, ok
+}
+
+// bufferItem is a buffer in the buffer list, with the positions of the runes
+// of its name that match the filter.
+type bufferItem struct {
+	buf     *buffer
+	name    []rune
+	matches []int
+	score   int
+}
+
+// filterBuffers returns the buffers whose names match filter, the best
+// matches first. Without a filter, all buffers are returned in their order.
+func (e *editor) filterBuffers(filter []rune) []bufferItem {
+	var items []bufferItem
+	for _, buf := range e.bufs {
+		name := []rune(e.bufferName(buf))
+		matches, score, ok := fuzzyMatch(filter, name)
+		if !ok {
+			continue
+		}
+		items = append(items, bufferItem{buf: buf, name: name, matches: matches, score: score})
+	}
+
+	if len(filter) == 0 {
+		return items
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].score != items[j].score {
+			return items[i].score > items[j].score
+		}
+		return len(items[i].name) < len(items[j].name)
+	})
+
+	return items
+}
+
+// pickBuffer shows a list of all buffers above the prompt, which filters the
+// list as the filter is typed. Enter switches to the selected buffer, Ctrl-D
+// closes it.
+func (e *editor) pickBuffer() {
+	curBuf := e.bufs[e.bufIdx]
+
+	var items []bufferItem
+	var filter string
+	sel, top := 0, 0
+
+	update := func(ev *tcell.EventKey, input []rune) string {
+		prompt := "Buffer"
+
+		width, height := e.scr.Size()
+		listHeight := height - 2
+
+		key := tcell.KeyRune
+		if ev != nil {
+			key = ev.Key()
+		}
+
+		switch key {
+		case tcell.KeyUp:
+			sel--
+		case tcell.KeyDown:
+			sel++
+		case tcell.KeyPgUp:
+			sel -= listHeight
+		case tcell.KeyPgDn:
+			sel += listHeight
+		case tcell.KeyCtrlD:
+			if len(items) == 0 {
+				break
+			}
+			if len(e.bufs) == 1 {
+				prompt = "Can't close last remaining buffer"
+				break
+			}
+
+			buf := items[sel].buf
+
+			log.Printf("pickBuffer: closing buffer %d", e.bufferIndex(buf))
+
+			e.bufIdx = e.bufferIndex(buf)
+			e.closeBuffer()
+			if idx := e.bufferIndex(curBuf); idx >= 0 {
+				e.bufIdx = idx
+			} else {
+				curBuf = e.bufs[e.bufIdx]
+			}
+
+			items = e.filterBuffers(input)
+		default:
+			if ev != nil && string(input) == filter {
+				break
+			}
+			filter = string(input)
+			items = e.filterBuffers(input)
+			sel = 0
+			if ev == nil {
+				for idx, item := range items {
+					if item.buf == curBuf {
+						sel = idx
+					}
+				}
+			}
+		}
+
+		if sel >= len(items) {
+			sel = len(items) - 1
+		}
+		if sel < 0 {
+			sel = 0
+		}
+		if sel < top {
+			top = sel
+		}
+		if sel >= top+listHeight {
+			top = sel - listHeight + 1
+		}
+
+		e.drawBufferList(items, sel, top, width, listHeight)
+
+		return fmt.Sprintf("%s (%d of %d)", prompt, len(items), len(e.bufs))
+	}
+
+	_, ok := e.readStringFunc("Buffer", nil, update)
+	if !ok {
+		log.Printf("pickBuffer: cancelled")
+		e.bufIdx = e.bufferIndex(curBuf)
+		return
+	}
+
+	if len(items) == 0 {
+		log.Printf("pickBuffer: no matching buffer")
+		e.showError("No matching buffer")
+		return
+	}
+
+	e.bufIdx = e.bufferIndex(items[sel].buf)
+
+	log.Printf("pickBuffer: switched to buffer %d", e.bufIdx)
+}
+
+// drawBufferList draws the buffer list of pickBuffer in the rows above the
+// prompt: whether each buffer has unsaved changes, its name with the runes
+// that match the filter highlighted, and its number of lines.
+func (e *editor) drawBufferList(items []bufferItem, sel, top, width, listHeight int) {
+	titleStyle := e.theme.style(slotTitle)
+	e.clearLine(0, width, titleStyle)
+	x := 0
+	for _, r := range "Buffers - Up/Down: select, Enter: switch, Ctrl-D: close, Esc: cancel" {
+		e.scr.SetContent(x, 0, r, nil, titleStyle)
+		x += runewidth.RuneWidth(r)
+	}
+
+	for row := 0; row < listHeight; row++ {
+		style := e.theme.style(slotText)
+		if top+row == sel {
+			style = e.theme.apply(slotSelection, style)
+		}
+		e.clearLine(row+1, width, style)
+		if top+row >= len(items) {
+			continue
+		}
+
+		item := items[top+row]
+
+		flag := "  "
+		if item.buf.modified {
+			flag = "* "
+		}
+		lines := fmt.Sprintf(" %d lines", item.buf.lineCount())
+
+		// cut off the start of long names, which is shown by <.
+		name, first := item.name, 0
+		nameWidth := width - len(flag) - len(lines)
+		for first < len(name) && runeWidth(name[first:]) > nameWidth {
+			first++
+		}
+		if first > 0 && first < len(name) {
+			first++
+		}
+
+		x := 0
+		for _, r := range flag {
+			e.scr.SetContent(x, row+1, r, nil, style)
+			x++
+		}
+		if first > 0 {
+			e.scr.SetContent(x, row+1, '<', nil, style)
+			x++
+		}
+
+		m := 0
+		for pos, r := range name {
+			for m < len(item.matches) && item.matches[m] < pos {
+				m++
+			}
+			if pos < first {
+				continue
+			}
+			charStyle := style
+			if m < len(item.matches) && item.matches[m] == pos {
+				charStyle = e.theme.apply(slotSearchMatch, style)
+			}
+			e.scr.SetContent(x, row+1, r, nil, charStyle)
+			x += runewidth.RuneWidth(r)
+		}
+
+		x = width - len(lines)
+		for _, r := range lines {
+			e.scr.SetContent(x, row+1, r, nil, style)
+			x++
+		}
+	}
+}
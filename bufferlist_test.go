@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	testData := map[string]struct {
+		pattern   string
+		s         string
+		ok        bool
+		positions []int
+	}{
+		"empty":              {"", "main.go", true, nil},
+		"prefix":             {"ma", "main.go", true, []int{0, 1}},
+		"scattered":          {"mgo", "main.go", true, []int{0, 5, 6}},
+		"best start":         {"main", "cmd/main.go", true, []int{4, 5, 6, 7}},
+		"ignore case":        {"readme", "README.md", true, []int{0, 1, 2, 3, 4, 5}},
+		"smart case":         {"Main", "main.go", false, nil},
+		"upper case matches": {"RM", "README.md", true, []int{0, 4}},
+		"wrong order":        {"og", "go", false, nil},
+		"no match":           {"x", "main.go", false, nil},
+	}
+
+	for name, tt := range testData {
+		t.Run(name, func(t *testing.T) {
+			positions, _, ok := fuzzyMatch([]rune(tt.pattern), []rune(tt.s))
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.positions, positions)
+		})
+	}
+}
+
+func TestFilterBuffers(t *testing.T) {
+	ed := newTestEditor(t, "cmd/mxaxixn.go", "main_test.go", "cmd/main.go", "README.md")
+	nameBuffers(ed)
+
+	names := func(items []bufferItem) (names []string) {
+		for _, item := range items {
+			names = append(names, string(item.name))
+		}
+		return names
+	}
+
+	require.Equal(t, []string{"cmd/mxaxixn.go", "main_test.go", "cmd/main.go", "README.md"}, names(ed.filterBuffers(nil)), "without filter, the buffers keep their order")
+	require.Equal(t, []string{"cmd/main.go", "main_test.go", "cmd/mxaxixn.go"}, names(ed.filterBuffers([]rune("main"))), "the best matches come first")
+	require.Empty(t, ed.filterBuffers([]rune("xyz")))
+}
+
+// pickBufferKeys returns the keys that open the buffer list, type filter and
+// then press keys.
+func pickBufferKeys(filter string, keys ...tcell.Key) []*tcell.EventKey {
+	evs := []*tcell.EventKey{tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyCtrlN, 0, 0)}
+	evs = append(evs, typeString(filter)...)
+	for _, key := range keys {
+		evs = append(evs, tcell.NewEventKey(key, 0, 0))
+	}
+	return evs
+}
+
+func TestPickBuffer(t *testing.T) {
+	ed := newTestEditor(t, "cmd/main.go", "main_test.go", "README.md", "docs/notes.txt")
+	nameBuffers(ed)
+	scr := ed.scr.(tcell.SimulationScreen)
+
+	postKeys(ed, pickBufferKeys("note", tcell.KeyEnter)...)
+	ed.handleEvent()
+	ed.handleEvent()
+	require.Equal(t, 3, ed.bufIdx)
+
+	// without a filter, the current buffer is selected first.
+	postKeys(ed, pickBufferKeys("", tcell.KeyUp, tcell.KeyUp, tcell.KeyEnter)...)
+	ed.handleEvent()
+	ed.handleEvent()
+	require.Equal(t, 1, ed.bufIdx)
+
+	postKeys(ed, pickBufferKeys("zzz", tcell.KeyEnter)...)
+	ed.handleEvent()
+	ed.handleEvent()
+	require.Equal(t, 1, ed.bufIdx)
+	scr.Show()
+	require.Equal(t, "No matching buffer", screenRow(scr, 9))
+
+	// closing a buffer from the list keeps the current buffer.
+	postKeys(ed, pickBufferKeys("readme", tcell.KeyCtrlD, tcell.KeyEsc)...)
+	ed.handleEvent()
+	ed.handleEvent()
+	require.Len(t, ed.bufs, 3)
+	require.Equal(t, "main_test.go", ed.bufs[ed.bufIdx].fname)
+
+	// closing the current buffer switches to another one.
+	postKeys(ed, pickBufferKeys("", tcell.KeyCtrlD, tcell.KeyCtrlD, tcell.KeyCtrlD, tcell.KeyEnter)...)
+	ed.handleEvent()
+	ed.handleEvent()
+	require.Len(t, ed.bufs, 1, "the last buffer can't be closed")
+	require.Equal(t, 0, ed.bufIdx)
+}
+
+func TestDrawBufferList(t *testing.T) {
+	ed := newTestEditor(t, "cmd/main.go", "a/very/long/path/to/some/file.txt")
+	nameBuffers(ed)
+	ed.bufs[0].modified = true
+	scr := ed.scr.(tcell.SimulationScreen)
+
+	items := ed.filterBuffers([]rune("main"))
+	ed.drawBufferList(items, 0, 0, 40, 8)
+	scr.Show()
+
+	require.Equal(t, "Buffers - Up/Down: select, Enter: switch", screenRow(scr, 0))
+	require.Equal(t, "* cmd/main.go                    1 lines", screenRow(scr, 1))
+	require.Equal(t, "", screenRow(scr, 2))
+
+	cells, _, _ := scr.GetContents()
+	text := ed.theme.apply(slotSelection, ed.theme.style(slotText))
+	require.Equal(t, text, cells[40+2].Style)
+	require.Equal(t, ed.theme.apply(slotSearchMatch, text), cells[40+6].Style, "matching runes are highlighted")
+
+	ed.drawBufferList(ed.filterBuffers(nil), 0, 0, 40, 8)
+	scr.Show()
+	require.Equal(t, "  <ry/long/path/to/some/file.txt 1 lines", screenRow(scr, 2))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const diffContextLines = 3
+
+// maxDiffEdits limits the number of added and removed lines that diffLines
+// looks for, as the time it takes grows with it, and the memory quadratically.
+const maxDiffEdits = 1000
+
+type diffLine struct {
+	kind byte // ' ' for unchanged lines, '-' for removed lines, '+' for added lines.
+	text string
+}
+
+// diffLines computes the shortest edit script that turns a into b using
+// Myers' algorithm. ok is false if it takes more than maxDiffEdits added and
+// removed lines.
+func diffLines(a, b []string) (lines []diffLine, ok bool) {
+	var prefix, suffix []diffLine
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, diffLine{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append(suffix, diffLine{' ', a[len(a)-1]})
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	reverseDiffLines(suffix)
+
+	n, m := len(a), len(b)
+	maxD := n + m
+	if maxD > maxDiffEdits {
+		maxD = maxDiffEdits
+	}
+	off := maxD + 1
+
+	// v[off+k] is the furthest x reached on diagonal k; trace[d] keeps
+	// v[off-d:off+d+1] as it was after step d, which is all that step d+1
+	// reads, to walk back the path.
+	v := make([]int, 2*maxD+3)
+	var trace [][]int
+
+search:
+	for d := 0; ; d++ {
+		if d > maxD {
+			return nil, false
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+		trace = append(trace, append([]int(nil), v[off-d:off+d+1]...))
+	}
+
+	var result []diffLine
+
+	x, y := n, m
+	for d := len(trace); d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+
+		prevK := k - 1
+		if k == -d || (k != d && prev[d-1+k-1] < prev[d-1+k+1]) {
+			prevK = k + 1
+		}
+		prevX := prev[d-1+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			result = append(result, diffLine{' ', a[x-1]})
+			x--
+			y--
+		}
+
+		if x == prevX {
+			result = append(result, diffLine{'+', b[y-1]})
+		} else {
+			result = append(result, diffLine{'-', a[x-1]})
+		}
+
+		x, y = prevX, prevY
+	}
+	for ; x > 0; x-- {
+		result = append(result, diffLine{' ', a[x-1]})
+	}
+
+	reverseDiffLines(result)
+
+	return append(prefix, append(result, suffix...)...), true
+}
+
+func reverseDiffLines(lines []diffLine) {
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+}
+
+// unifiedDiff returns the differences between a and b in unified diff format,
+// or an empty string if they are equal. If they differ in too many lines, it
+// only says that they differ.
+func unifiedDiff(nameA, nameB string, a, b []string) string {
+	lines, ok := diffLines(a, b)
+	if !ok {
+		return fmt.Sprintf("Files %s and %s differ\n", nameA, nameB)
+	}
+
+	// line numbers in a and b at which each diff line starts.
+	posA, posB := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for idx, line := range lines {
+		posA[idx+1], posB[idx+1] = posA[idx], posB[idx]
+		if line.kind != '+' {
+			posA[idx+1]++
+		}
+		if line.kind != '-' {
+			posB[idx+1]++
+		}
+	}
+
+	var sb strings.Builder
+
+	for start := 0; start < len(lines); {
+		if lines[start].kind == ' ' {
+			start++
+			continue
+		}
+
+		// extend the hunk until there are more than twice the context lines without changes.
+		end := start
+		for unchanged := 0; end < len(lines) && unchanged <= 2*diffContextLines; end++ {
+			if lines[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for end > start && lines[end-1].kind == ' ' {
+			end--
+		}
+
+		from, to := start-diffContextLines, end+diffContextLines
+		if from < 0 {
+			from = 0
+		}
+		if to > len(lines) {
+			to = len(lines)
+		}
+
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(posA[from], posA[to]), hunkRange(posB[from], posB[to]))
+		for _, line := range lines[from:to] {
+			fmt.Fprintf(&sb, "%c%s\n", line.kind, line.text)
+		}
+
+		start = to
+	}
+
+	return sb.String()
+}
+
+func hunkRange(start, end int) string {
+	if end-start == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	if end == start {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+func runeLines(lines [][]rune) []string {
+	result := make([]string, 0, len(lines))
+	for _, line := range lines {
+		result = append(result, string(line))
+	}
+	return result
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	testData := map[string]struct {
+		a, b     string
+		expected string
+	}{
+		"equal": {
+			a: "a\nb\nc",
+			b: "a\nb\nc",
+		},
+		"changed line": {
+			a:        "a\nb\nc",
+			b:        "a\nx\nc",
+			expected: "--- a\n+++ b\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n",
+		},
+		"added to empty": {
+			a:        "",
+			b:        "x",
+			expected: "--- a\n+++ b\n@@ -1 +1 @@\n-\n+x\n",
+		},
+		"inserted line": {
+			a:        "1\n2\n3\n4\n5\n6\n7\n8",
+			b:        "1\n2\n3\n4\nnew\n5\n6\n7\n8",
+			expected: "--- a\n+++ b\n@@ -2,6 +2,7 @@\n 2\n 3\n 4\n+new\n 5\n 6\n 7\n",
+		},
+		"removed at start": {
+			a:        "1\n2\n3\n4\n5",
+			b:        "2\n3\n4\n5",
+			expected: "--- a\n+++ b\n@@ -1,4 +1,3 @@\n-1\n 2\n 3\n 4\n",
+		},
+		"separate hunks": {
+			a:        "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12",
+			b:        "x\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ny",
+			expected: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+y\n",
+		},
+		"close changes in one hunk": {
+			a:        "1\n2\n3\n4\n5\n6\n7\n8",
+			b:        "x\n2\n3\n4\n5\n6\n7\ny",
+			expected: "--- a\n+++ b\n@@ -1,8 +1,8 @@\n-1\n+x\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+y\n",
+		},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			require.Equal(t, tt.expected, unifiedDiff("a", "b", strings.Split(tt.a, "\n"), strings.Split(tt.b, "\n")))
+		})
+	}
+}
+
+func TestUnifiedDiffTooManyChanges(t *testing.T) {
+	var a, b []string
+	for i := 0; i <= maxDiffEdits/2; i++ {
+		a = append(a, fmt.Sprintf("a%d", i))
+		b = append(b, fmt.Sprintf("b%d", i))
+	}
+
+	require.Equal(t, "Files a and b differ\n", unifiedDiff("a", "b", a, b))
+
+	b = append([]string(nil), a...)
+	b[100], b[400] = "x", "y"
+	require.Contains(t, unifiedDiff("a", "b", a, b), " a99\n-a100\n+x\n a101\n", "long files that differ in few lines are still diffed")
+}
+
+func TestDiffLinesRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	randomLines := func() []string {
+		lines := make([]string, rnd.Intn(20))
+		for idx := range lines {
+			lines[idx] = string(rune('a' + rnd.Intn(4)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := randomLines(), randomLines()
+
+		lines, ok := diffLines(a, b)
+		require.True(t, ok)
+
+		var gotA, gotB []string
+		for _, line := range lines {
+			if line.kind != '+' {
+				gotA = append(gotA, line.text)
+			}
+			if line.kind != '-' {
+				gotB = append(gotB, line.text)
+			}
+		}
+
+		require.Equal(t, len(a), len(gotA))
+		require.Equal(t, len(b), len(gotB))
+		for idx := range a {
+			require.Equal(t, a[idx], gotA[idx])
+		}
+		for idx := range b {
+			require.Equal(t, b[idx], gotB[idx])
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"log"
+)
+
+// cursorState is the position of the cursor and the selection of a view,
+// which are restored when a change is undone or redone.
+type cursorState struct {
+	x, y                       int // y is the index of the line, not the screen row.
+	selecting, blockMode       bool
+	startX, startY, endX, endY int
+}
+
+func (v *view) cursorState() cursorState {
+	return cursorState{
+		x:         v.x,
+		y:         v.curLineIdx(),
+		selecting: v.selecting,
+		blockMode: v.blockMode,
+		startX:    v.startX,
+		startY:    v.startY,
+		endX:      v.endX,
+		endY:      v.endY,
+	}
+}
+
+// setCursorState moves the cursor to the position in cs and restores the
+// selection. Positions that no longer exist are corrected.
+func (v *view) setCursorState(cs cursorState) {
+	y := cs.y
+	if y >= v.buf.lineCount() {
+		y = v.buf.lineCount() - 1
+	}
+	v.gotoLine(y)
+	v.x = cs.x
+	v.correctX()
+
+	v.selecting, v.blockMode = cs.selecting, cs.blockMode
+	v.startX, v.startY, v.endX, v.endY = cs.startX, cs.startY, cs.endX, cs.endY
+}
+
+// insertText inserts text at position x of line y of the buffer of v and
+// records the insertion in the edit history. Every element of text except for
+// the last one is terminated by a line break.
+func (v *view) insertText(y, x int, text [][]rune) {
+	if (len(text) == 1 && len(text[0]) == 0) || v.buf.refuseEdit() {
+		return
+	}
+
+	log.Printf("insertText: inserting %d lines at %d/%d", len(text), y, x)
+
+	op := &editOp{op: opInsertText, text: copyLines(text), y: y, x: x}
+	v.buf.insert(y, x, text, v)
+	v.adjustSelection(op)
+	v.record(op)
+}
+
+// deleteText removes the text from position x of line y up to but excluding
+// position endX of line endY, records the removal in the edit history and
+// returns the removed text.
+func (v *view) deleteText(y, x, endY, endX int) [][]rune {
+	if (y == endY && x == endX) || v.buf.refuseEdit() {
+		return [][]rune{{}}
+	}
+
+	log.Printf("deleteText: removing text from %d/%d to %d/%d", y, x, endY, endX)
+
+	op := &editOp{op: opRemoveText, text: copyLines(v.buf.textRange(y, x, endY, endX)), y: y, x: x}
+	v.buf.remove(y, x, endY, endX, v)
+	v.adjustSelection(op)
+	v.record(op)
+
+	return copyLines(op.text)
+}
+
+// refuseEdit returns true if buf is read-only, and records that an edit was
+// refused.
+func (buf *buffer) refuseEdit() bool {
+	if buf.readOnly {
+		log.Printf("refuseEdit: buffer is read-only")
+		buf.readOnlyEdit = true
+	}
+	return buf.readOnly
+}
+
+// adjustPos returns where the text at position x of line y is after op was
+// applied. Positions within removed text move to the start of the removal.
+func (op *editOp) adjustPos(y, x int) (int, int) {
+	if y < op.y || (y == op.y && x < op.x) {
+		return y, x
+	}
+
+	endY, endX := op.end()
+
+	switch op.op {
+	case opInsertText:
+		if y == op.y {
+			return endY, endX + x - op.x
+		}
+		return y + endY - op.y, x
+	case opRemoveText:
+		if y < endY || (y == endY && x < endX) {
+			return op.y, op.x
+		}
+		if y == endY {
+			return op.y, op.x + x - endX
+		}
+		return y - (endY - op.y), x
+	}
+
+	return y, x
+}
+
+// textRange returns the text from position x of line y up to but excluding
+// position endX of line endY.
+func (buf *buffer) textRange(y, x, endY, endX int) [][]rune {
+	if y == endY {
+		return [][]rune{buf.line(y)[x:endX]}
+	}
+
+	text := [][]rune{buf.line(y)[x:]}
+	for i := y + 1; i < endY; i++ {
+		text = append(text, buf.line(i))
+	}
+	return append(text, buf.line(endY)[:endX])
+}
+
+func copyLines(text [][]rune) [][]rune {
+	lines := make([][]rune, len(text))
+	for idx, line := range text {
+		lines[idx] = append([]rune{}, line...)
+	}
+	return lines
+}
+
+// beginChange starts collecting all edits until the matching endChange into
+// a single change, so that they are undone and redone together. Calls can be
+// nested, only the outermost pair has an effect. The edit history records the
+// cursor of v from before and after the change.
+func (v *view) beginChange() {
+	buf := v.buf
+
+	buf.changeDepth++
+	if buf.changeDepth > 1 {
+		return
+	}
+
+	buf.change = &editOp{op: opGroup, finished: true}
+	buf.changeCursor = v.cursorState()
+}
+
+// endChange adds the edits collected since beginChange to the edit history.
+// A change that consists of a single insertion or removal that continues the
+// previous, unfinished change is merged into it, e.g. when typing.
+func (v *view) endChange() {
+	buf := v.buf
+
+	buf.changeDepth--
+	if buf.changeDepth > 0 {
+		return
+	}
+
+	change := buf.change
+	buf.change = nil
+
+	if len(change.children) == 0 {
+		return
+	}
+
+	op := change
+	if len(change.children) == 1 {
+		op = change.children[0]
+	}
+
+	if node := buf.history.cur; node.op != nil && !node.op.finished && node.op.merge(op) {
+		log.Printf("endChange: merged change into state %d", node.seq)
+		node.time = timeNow()
+		node.after = v.cursorState()
+		return
+	}
+
+	buf.historyFinishOp()
+	buf.history.add(op)
+	buf.history.cur.before = buf.changeCursor
+	buf.history.cur.after = v.cursorState()
+}
+
+// record adds op to the current change of the buffer of v, starting and
+// ending a change of its own if there is none.
+func (v *view) record(op *editOp) {
+	buf := v.buf
+	buf.modified = true
+
+	if buf.change == nil {
+		v.beginChange()
+		defer v.endChange()
+	}
+
+	children := buf.change.children
+	if n := len(children); n > 0 && children[n-1].merge(op) {
+		return
+	}
+	buf.change.children = append(children, op)
+}
+
+// merge extends op by next if next continues it: text that is inserted right
+// after the text of op, or text that is removed right before or at the
+// position of op. It returns false if the operations can't be merged.
+func (op *editOp) merge(next *editOp) bool {
+	if op.op != next.op {
+		return false
+	}
+
+	switch op.op {
+	case opInsertText:
+		if endY, endX := op.end(); next.y == endY && next.x == endX {
+			op.text = concatLines(op.text, next.text)
+			return true
+		}
+	case opRemoveText:
+		if endY, endX := next.end(); endY == op.y && endX == op.x {
+			op.text = concatLines(next.text, op.text)
+			op.y, op.x = next.y, next.x
+			return true
+		}
+		if next.y == op.y && next.x == op.x {
+			op.text = concatLines(op.text, next.text)
+			return true
+		}
+	}
+
+	return false
+}
+
+// end returns the position after the text of op.
+func (op *editOp) end() (y, x int) {
+	if len(op.text) == 1 {
+		return op.y, op.x + len(op.text[0])
+	}
+	return op.y + len(op.text) - 1, len(op.text[len(op.text)-1])
+}
+
+// concatLines joins the last line of a with the first line of b.
+func concatLines(a, b [][]rune) [][]rune {
+	text := copyLines(a)
+	text[len(text)-1] = append(text[len(text)-1], b[0]...)
+	return append(text, copyLines(b[1:])...)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// editKeys are the keys that random edit scripts are made of: typing, moving
+// the cursor, selecting, and all commands that change the text.
+var editKeys = []*tcell.EventKey{
+	tcell.NewEventKey(tcell.KeyRune, 'a', 0),
+	tcell.NewEventKey(tcell.KeyRune, 'b', 0),
+	tcell.NewEventKey(tcell.KeyRune, '例', 0),
+	tcell.NewEventKey(tcell.KeyRune, ' ', 0),
+	tcell.NewEventKey(tcell.KeyEnter, 0, 0),
+	tcell.NewEventKey(tcell.KeyBackspace2, 0, 0),
+	tcell.NewEventKey(tcell.KeyDelete, 0, 0),
+	tcell.NewEventKey(tcell.KeyUp, 0, 0),
+	tcell.NewEventKey(tcell.KeyDown, 0, 0),
+	tcell.NewEventKey(tcell.KeyLeft, 0, 0),
+	tcell.NewEventKey(tcell.KeyRight, 0, 0),
+	tcell.NewEventKey(tcell.KeyCtrlA, 0, 0),
+	tcell.NewEventKey(tcell.KeyCtrlE, 0, 0),
+	tcell.NewEventKey(tcell.KeyCtrlK, 0, 0),
+	tcell.NewEventKey(tcell.KeyCtrlU, 0, 0),
+	tcell.NewEventKey(tcell.KeyCtrlSpace, 0, 0),
+	tcell.NewEventKey(tcell.KeyCtrlY, 0, 0),
+	tcell.NewEventKey(tcell.KeyCtrlC, 0, 0),
+	tcell.NewEventKey(tcell.KeyCtrlX, 0, 0),
+	tcell.NewEventKey(tcell.KeyCtrlV, 0, 0),
+}
+
+var undoKeys = []*tcell.EventKey{
+	tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0),
+	tcell.NewEventKey(tcell.KeyCtrlR, 0, 0),
+}
+
+// editScript is a random initial text and a random sequence of keys.
+type editScript struct {
+	text string
+	keys []*tcell.EventKey
+}
+
+func (editScript) Generate(rnd *rand.Rand, size int) reflect.Value {
+	alphabet := []string{"x", "y", "\n", "ä", " "}
+
+	var s editScript
+	for n := rnd.Intn(size + 1); n > 0; n-- {
+		s.text += alphabet[rnd.Intn(len(alphabet))]
+	}
+	for n := rnd.Intn(4 * size); n > 0; n-- {
+		keys := editKeys
+		if rnd.Intn(10) == 0 {
+			keys = undoKeys
+		}
+		s.keys = append(s.keys, keys[rnd.Intn(len(keys))])
+	}
+
+	return reflect.ValueOf(s)
+}
+
+func TestUndoAllRestoresOriginal(t *testing.T) {
+	check := func(s editScript) bool {
+		ed := newTestEditor(t, s.text)
+		buf := ed.bufs[0]
+		v := buf.lastView
+
+		original := buf.lines()
+
+		// the cursor before each change of the original text.
+		start := map[*undoNode]cursorState{}
+		for _, key := range s.keys {
+			cs, children := v.cursorState(), len(buf.history.root.children)
+			playKeys(t, ed, key)
+			if len(buf.history.root.children) > children {
+				start[buf.history.root.children[children]] = cs
+			}
+		}
+
+		final, finalState := buf.lines(), buf.history.cur
+
+		if buf.history.cur == buf.history.root {
+			return reflect.DeepEqual(original, buf.lines()) && !buf.modified
+		}
+
+		var first *undoNode
+		for buf.history.cur != buf.history.root {
+			first = buf.history.cur
+			playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0))
+		}
+		if !reflect.DeepEqual(original, buf.lines()) || buf.modified {
+			t.Logf("undo all: got %q, expected %q, modified = %t", runeLines(buf.lines()), runeLines(original), buf.modified)
+			return false
+		}
+		if v.cursorState() != start[first] {
+			t.Logf("undo all: cursor at %+v, expected %+v", v.cursorState(), start[first])
+			return false
+		}
+
+		// undoing remembered the way back to the final state.
+		for buf.history.cur != finalState && len(buf.history.cur.children) > 0 {
+			playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlR, 0, 0))
+		}
+		if buf.history.cur != finalState || !reflect.DeepEqual(final, buf.lines()) {
+			t.Logf("redo all: got %q, expected %q", runeLines(buf.lines()), runeLines(final))
+			return false
+		}
+
+		return true
+	}
+
+	require.NoError(t, quick.Check(check, nil))
+}
+
+func TestUndoRestoresCursor(t *testing.T) {
+	check := func(s editScript) bool {
+		ed := newTestEditor(t, s.text)
+		buf := ed.bufs[0]
+		v := buf.lastView
+
+		for _, key := range s.keys {
+			if key.Key() == tcell.KeyCtrlZ || key.Key() == tcell.KeyCtrlR {
+				continue
+			}
+
+			// every command becomes a change of its own.
+			buf.historyFinishOp()
+
+			before, beforeCursor := buf.lines(), v.cursorState()
+			cur := buf.history.cur
+
+			playKeys(t, ed, key)
+
+			if buf.history.cur == cur {
+				if !reflect.DeepEqual(before, buf.lines()) {
+					t.Logf("%s changed the text without recording it", key.Name())
+					return false
+				}
+				continue
+			}
+
+			after, afterCursor := buf.lines(), v.cursorState()
+
+			playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0))
+			if !reflect.DeepEqual(before, buf.lines()) || v.cursorState() != beforeCursor {
+				t.Logf("undo of %s: got %q at %+v, expected %q at %+v", key.Name(), runeLines(buf.lines()), v.cursorState(), runeLines(before), beforeCursor)
+				return false
+			}
+
+			playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlR, 0, 0))
+			if !reflect.DeepEqual(after, buf.lines()) || v.cursorState() != afterCursor {
+				t.Logf("redo of %s: got %q at %+v, expected %q at %+v", key.Name(), runeLines(buf.lines()), v.cursorState(), runeLines(after), afterCursor)
+				return false
+			}
+		}
+
+		return true
+	}
+
+	require.NoError(t, quick.Check(check, nil))
+}
+
+func TestEditOpMerge(t *testing.T) {
+	testData := map[string]struct {
+		op, next *editOp
+		merged   bool
+		expected *editOp
+	}{
+		"typing": {
+			op:       &editOp{op: opInsertText, text: [][]rune{[]rune("ab")}, y: 1, x: 2},
+			next:     &editOp{op: opInsertText, text: [][]rune{[]rune("c")}, y: 1, x: 4},
+			merged:   true,
+			expected: &editOp{op: opInsertText, text: [][]rune{[]rune("abc")}, y: 1, x: 2},
+		},
+		"typing after newline": {
+			op:       &editOp{op: opInsertText, text: [][]rune{[]rune("a"), {}}, y: 1, x: 2},
+			next:     &editOp{op: opInsertText, text: [][]rune{[]rune("b")}, y: 2, x: 0},
+			merged:   true,
+			expected: &editOp{op: opInsertText, text: [][]rune{[]rune("a"), []rune("b")}, y: 1, x: 2},
+		},
+		"insert elsewhere": {
+			op:     &editOp{op: opInsertText, text: [][]rune{[]rune("ab")}, y: 1, x: 2},
+			next:   &editOp{op: opInsertText, text: [][]rune{[]rune("c")}, y: 1, x: 2},
+			merged: false,
+		},
+		"backspace": {
+			op:       &editOp{op: opRemoveText, text: [][]rune{[]rune("c")}, y: 1, x: 2},
+			next:     &editOp{op: opRemoveText, text: [][]rune{[]rune("b")}, y: 1, x: 1},
+			merged:   true,
+			expected: &editOp{op: opRemoveText, text: [][]rune{[]rune("bc")}, y: 1, x: 1},
+		},
+		"backspace joining lines": {
+			op:       &editOp{op: opRemoveText, text: [][]rune{[]rune("c")}, y: 1, x: 0},
+			next:     &editOp{op: opRemoveText, text: [][]rune{{}, {}}, y: 0, x: 3},
+			merged:   true,
+			expected: &editOp{op: opRemoveText, text: [][]rune{{}, []rune("c")}, y: 0, x: 3},
+		},
+		"delete": {
+			op:       &editOp{op: opRemoveText, text: [][]rune{[]rune("b")}, y: 1, x: 1},
+			next:     &editOp{op: opRemoveText, text: [][]rune{[]rune("c")}, y: 1, x: 1},
+			merged:   true,
+			expected: &editOp{op: opRemoveText, text: [][]rune{[]rune("bc")}, y: 1, x: 1},
+		},
+		"insert after remove": {
+			op:     &editOp{op: opRemoveText, text: [][]rune{[]rune("b")}, y: 1, x: 1},
+			next:   &editOp{op: opInsertText, text: [][]rune{[]rune("c")}, y: 1, x: 1},
+			merged: false,
+		},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			require.Equal(t, tt.merged, tt.op.merge(tt.next))
+			if tt.merged {
+				require.Equal(t, tt.expected, tt.op)
+			}
+		})
+	}
+}
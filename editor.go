@@ -1,12 +1,13 @@
 package main
 
 import (
-	"bufio"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
@@ -15,62 +16,229 @@ import (
 
 func newEditor(scr tcell.Screen) *editor {
 	ed := &editor{
-		scr: scr,
-	}
-
-	ed.ops = []keyMapping{
-		{tcell.KeyCtrlSpace, ed.selectText, "start/stop selecting text"},
-		{tcell.KeyCtrlA, ed.gotoBOL, "go to beginning of line"},
-		{tcell.KeyCtrlB, ed.newBuffer, "create new buffer"},
-		{tcell.KeyCtrlC, ed.copyText, "copy selected text to clipboard"},
-		{tcell.KeyCtrlD, ed.closeBuffer, "close current buffer"},
-		{tcell.KeyCtrlE, ed.gotoEOL, "go to end of line"},
-		{tcell.KeyCtrlF, ed.find, "find text"},
-		{tcell.KeyCtrlH, ed.showHelp, "show help"},
-		{tcell.KeyCtrlK, ed.deleteToEOL, "delete text to end of line"},
-		{tcell.KeyCtrlL, ed.redraw, "redraw screen"},
-		{tcell.KeyCtrlN, ed.nextBuffer, "go to next file"},
-		{tcell.KeyCtrlO, ed.openFile, "open file in new buffer"},
-		{tcell.KeyCtrlP, ed.prevBuffer, "go to previous file"},
-		{tcell.KeyCtrlQ, ed.quit, "quit"},
-		{tcell.KeyCtrlR, ed.redo, "redo previously undone change"},
-		{tcell.KeyCtrlS, ed.save, "save file"},
-		{tcell.KeyCtrlU, ed.deleteFromBOL, "delete text from beginning of line"},
-		{tcell.KeyCtrlV, ed.pasteText, "paste text from clipboard"},
-		{tcell.KeyCtrlW, ed.saveAs, "save file as"},
-		{tcell.KeyCtrlX, ed.cutText, "cut selected text to clipboard"},
-		{tcell.KeyCtrlZ, ed.undo, "undo last change"},
-		{tcell.KeyCR, ed.newLine, "insert new line"},
-		{tcell.KeyUp, ed.keyUp, "go to previous line"},
-		{tcell.KeyDown, ed.keyDown, "go to next line"},
-		{tcell.KeyLeft, ed.keyLeft, "go to previous character"},
-		{tcell.KeyRight, ed.keyRight, "go to next character"},
-		{tcell.KeyPgDn, ed.pageDown, "go to next page"},
-		{tcell.KeyPgUp, ed.pageUp, "go to previous page"},
-		{tcell.KeyDEL, ed.keyBackspace, "delete character left from cursor"},
-		{tcell.KeyDelete, ed.keyDel, "delete character right from cursor"},
+		scr:       scr,
+		languages: builtinLanguages(),
+		themes:    builtinThemes(),
 	}
 
+	if err := ed.setTheme("default"); err != nil {
+		panic(err)
+	}
+
+	ed.cmds = map[string]command{}
+	for _, cmd := range []command{
+		{"selectText", ed.selectText, "start/stop selecting text"},
+		{"gotoBOL", ed.gotoBOL, "go to beginning of line"},
+		{"newBuffer", ed.newBuffer, "create new buffer"},
+		{"copyText", ed.copyText, "copy selected text to clipboard"},
+		{"closeBuffer", ed.closeBuffer, "close current buffer"},
+		{"gotoEOL", ed.gotoEOL, "go to end of line"},
+		{"find", ed.find, "find text"},
+		{"incrementalFind", ed.incrementalFind, "find text while typing"},
+		{"findNext", ed.findNext, "find next occurrence of last search"},
+		{"findPrevious", ed.findPrevious, "find previous occurrence of last search"},
+		{"findInBuffers", ed.findInBuffers, "find text in all buffers"},
+		{"replaceInBuffers", ed.replaceInBuffers, "replace text in all buffers (regexp)"},
+		{"grep", ed.grep, "search files in a directory tree"},
+		{"showHelp", ed.showHelp, "show help"},
+		{"deleteToEOL", ed.deleteToEOL, "delete text to end of line"},
+		{"redraw", ed.redraw, "redraw screen"},
+		{"nextBuffer", ed.nextBuffer, "go to next file"},
+		{"openFile", ed.openFile, "open file in new buffer"},
+		{"prevBuffer", ed.prevBuffer, "go to previous file"},
+		{"quit", ed.quit, "quit"},
+		{"redo", ed.redo, "redo previously undone change"},
+		{"save", ed.save, "save file"},
+		{"replace", ed.replace, "find and replace text (regexp)"},
+		{"deleteFromBOL", ed.deleteFromBOL, "delete text from beginning of line"},
+		{"pasteText", ed.pasteText, "paste text from clipboard"},
+		{"saveAs", ed.saveAs, "save file as"},
+		{"cutText", ed.cutText, "cut selected text to clipboard"},
+		{"selectBlock", ed.selectBlock, "start/stop selecting rectangular block"},
+		{"undo", ed.undo, "undo last change"},
+		{"newLine", ed.newLine, "insert new line"},
+		{"keyUp", ed.keyUp, "go to previous line"},
+		{"keyDown", ed.keyDown, "go to next line"},
+		{"keyLeft", ed.keyLeft, "go to previous character"},
+		{"keyRight", ed.keyRight, "go to next character"},
+		{"pageDown", ed.pageDown, "go to next page"},
+		{"pageUp", ed.pageUp, "go to previous page"},
+		{"keyBackspace", ed.keyBackspace, "delete character left from cursor"},
+		{"keyDel", ed.keyDel, "delete character right from cursor"},
+		{"convertLineEndings", ed.convertLineEndings, "convert line endings between LF and CRLF"},
+		{"undoOlder", ed.undoOlder, "go to previous state in time, across branches"},
+		{"undoNewer", ed.undoNewer, "go to next state in time, across branches"},
+		{"switchBranch", ed.switchBranch, "switch to next undo branch"},
+		{"timeTravel", ed.timeTravel, "go back or forward in time"},
+		{"browseUndoTree", ed.browseUndoTree, "browse undo tree"},
+		{"toggleSoftWrap", ed.toggleSoftWrap, "wrap long lines off, at characters, at words"},
+		{"toggleLineNumbers", ed.toggleLineNumbers, "show line numbers off, absolute, relative"},
+		{"selectTheme", ed.selectTheme, "switch to another color theme"},
+		{"toggleBookmark", ed.toggleBookmark, "set or remove bookmark in current line"},
+		{"nextBookmark", ed.nextBookmark, "go to next bookmark"},
+		{"splitHorizontal", ed.splitHorizontal, "split current split into two above each other"},
+		{"splitVertical", ed.splitVertical, "split current split into two beside each other"},
+		{"closeSplit", ed.closeSplit, "close current split"},
+		{"closeOtherSplits", ed.closeOtherSplits, "close all splits but the current one"},
+		{"nextSplit", ed.nextSplit, "go to next split"},
+		{"growSplit", ed.growSplit, "make current split larger"},
+		{"shrinkSplit", ed.shrinkSplit, "make current split smaller"},
+		{"toggleTabBar", ed.toggleTabBar, "show or hide tab bar"},
+		{"pickBuffer", ed.pickBuffer, "pick buffer from list"},
+	} {
+		ed.cmds[cmd.Name] = cmd
+	}
+
+	ops, keys, err := ed.keyMappings(defaultKeyBindings)
+	if err != nil {
+		panic(fmt.Sprintf("invalid default key bindings: %v", err))
+	}
+	ed.ops, ed.keys = ops, keys
+
 	return ed
 }
 
+var defaultKeyBindings = []keyBinding{
+	{"Ctrl-Space", "selectText"},
+	{"Ctrl-A", "gotoBOL"},
+	{"Ctrl-B", "newBuffer"},
+	{"Ctrl-C", "copyText"},
+	{"Ctrl-D", "closeBuffer"},
+	{"Ctrl-E", "gotoEOL"},
+	{"Ctrl-F", "incrementalFind"},
+	{"Ctrl-H", "showHelp"},
+	{"Ctrl-K", "deleteToEOL"},
+	{"Ctrl-L", "redraw"},
+	{"Ctrl-N", "nextBuffer"},
+	{"Ctrl-O", "openFile"},
+	{"Ctrl-P", "prevBuffer"},
+	{"Ctrl-Q", "quit"},
+	{"Ctrl-R", "redo"},
+	{"Ctrl-S", "save"},
+	{"Ctrl-T", "replace"},
+	{"Ctrl-U", "deleteFromBOL"},
+	{"Ctrl-V", "pasteText"},
+	{"Ctrl-W", "saveAs"},
+	{"Ctrl-X", "cutText"},
+	{"Ctrl-Y", "selectBlock"},
+	{"Ctrl-Z", "undo"},
+	{"Enter", "newLine"},
+	{"Up", "keyUp"},
+	{"Down", "keyDown"},
+	{"Left", "keyLeft"},
+	{"Right", "keyRight"},
+	{"PgDn", "pageDown"},
+	{"PgUp", "pageUp"},
+	{"Backspace2", "keyBackspace"},
+	{"Delete", "keyDel"},
+	{"Alt-X Ctrl-F", "openFile"},
+	{"Alt-X f", "find"},
+	{"Alt-X n", "findNext"},
+	{"Alt-X p", "findPrevious"},
+	{"F3", "findNext"},
+	{"Shift-F3", "findPrevious"},
+	{"Alt-X s", "findInBuffers"},
+	{"Alt-X Ctrl-T", "replaceInBuffers"},
+	{"Alt-X g", "grep"},
+	{"Alt-X Ctrl-S", "save"},
+	{"Alt-X Ctrl-W", "saveAs"},
+	{"Alt-X k", "closeBuffer"},
+	{"Alt-X l", "convertLineEndings"},
+	{"Alt-X Ctrl-C", "quit"},
+	{"Alt-X -", "undoOlder"},
+	{"Alt-X +", "undoNewer"},
+	{"Alt-X b", "switchBranch"},
+	{"Alt-X t", "timeTravel"},
+	{"Alt-X u", "browseUndoTree"},
+	{"Alt-X w", "toggleSoftWrap"},
+	{"Alt-X #", "toggleLineNumbers"},
+	{"Alt-X c", "selectTheme"},
+	{"Alt-X m", "toggleBookmark"},
+	{"Alt-X j", "nextBookmark"},
+	{"Alt-X 2", "splitHorizontal"},
+	{"Alt-X 3", "splitVertical"},
+	{"Alt-X 0", "closeSplit"},
+	{"Alt-X 1", "closeOtherSplits"},
+	{"Alt-X o", "nextSplit"},
+	{"Alt-X >", "growSplit"},
+	{"Alt-X <", "shrinkSplit"},
+	{"Alt-X Ctrl-B", "toggleTabBar"},
+	{"Alt-X Ctrl-N", "pickBuffer"},
+}
+
 type keyMapping struct {
-	Key  tcell.Key
+	Keys keySequence
+	Cmd  string
 	Func func()
 	Desc string
 }
 
 type editor struct {
-	bufs          []*buffer
-	scr           tcell.Screen
-	bufIdx        int
-	quitInputLoop bool
-	clipboard     [][]rune
-	ops           []keyMapping
+	bufs           []*buffer
+	scr            tcell.Screen
+	bufIdx         int
+	quitInputLoop  bool
+	clipboard      [][]rune
+	clipboardBlock bool
+	cmds           map[string]command
+	ops            []keyMapping
+	keys           *keyNode
+	pendingKeys    keySequence // prefix of a key sequence typed so far.
+	undoDir        string      // directory of undo files, empty if undo history isn't kept.
+	lastGrep       grepQuery
+	grepDir        string
+	lineNumbers    lineNumbers // line numbers in the gutter, see gutter.go.
+	languages      []*language // language definitions for syntax highlighting, see syntax.go.
+	themes         []*theme    // see theme.go.
+	theme          *theme
+	layout         *layout // splits of the screen, see split.go.
+	win            *window // current split, which shows the current buffer.
+	tabBar         bool    // the top row lists the buffers, see tabbar.go.
+	tabOffset      int     // index of the first buffer in the tab bar.
+	draggedTab     *buffer // buffer whose tab is being dragged with the mouse.
+
+	// swap writer, see swap.go:
+	swapJobs chan swapJob
+	swapDone chan struct{}
+}
+
+// tickInterval is how often the swap files of modified buffers are updated
+// and the files of all buffers are checked for changes by other programs.
+const tickInterval = 4 * time.Second
+
+// tick is the payload of the interrupt events posted by startTicker.
+type tick struct{}
+
+// startTicker posts a tick event to the input loop at every interval until
+// the returned function is called. Buffers may only be accessed from the
+// input loop, so periodic tasks are run from there.
+func (e *editor) startTicker(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := e.scr.PostEvent(tcell.NewEventInterrupt(tick{})); err != nil {
+					log.Printf("startTicker: posting event failed: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
 }
 
 func (e *editor) inputLoop() {
 	for {
+		e.checkSwapFiles()
+
 		e.redrawScreen()
 
 		if e.quitInputLoop {
@@ -89,49 +257,100 @@ func (e *editor) handleEvent() {
 		width, height := ev.Size()
 		log.Printf("handleEvent: resize event: %dx%d", width, height)
 		return
+	case *tcell.EventInterrupt:
+		e.handleInterrupt(ev)
+		return
+	case *tcell.EventMouse:
+		e.handleMouse(ev)
+		return
 	case *tcell.EventKey:
 		log.Printf("handleEvent: key: %v rune = %d mod = %b", ev.Key(), ev.Rune(), ev.Modifiers())
-		for _, op := range e.ops {
-			if ev.Key() == op.Key {
-				op.Func()
-				return
-			}
+		key := keyStrokeFromEvent(ev)
+
+		if len(e.pendingKeys) > 0 && key.key == tcell.KeyCtrlG {
+			log.Printf("handleEvent: cancelled key sequence %s", e.pendingKeys)
+			e.pendingKeys = nil
+			e.showError("Cancelled")
+			return
+		}
+
+		seq := append(append(keySequence{}, e.pendingKeys...), key)
+
+		switch node := e.keys.lookup(seq); {
+		case node == nil && len(e.pendingKeys) > 0:
+			log.Printf("handleEvent: key sequence %s is undefined", seq)
+			e.pendingKeys = nil
+			e.showError("%s is undefined", seq)
+			return
+		case node == nil:
+			// not bound, handled as input below.
+		case node.op != nil:
+			log.Printf("handleEvent: %s -> %s", seq, node.op.Cmd)
+			e.pendingKeys = nil
+			e.runChange(node.op.Func)
+			return
+		default:
+			log.Printf("handleEvent: waiting for key sequence %s to be completed", seq)
+			e.pendingKeys = seq
+			return
 		}
 
-		if ev.Key() == tcell.KeyRune || ev.Key() == tcell.KeyTAB {
-			e.handleInput(ev.Rune())
+		if (ev.Key() == tcell.KeyRune && key.mod&tcell.ModAlt == 0) || ev.Key() == tcell.KeyTAB {
+			e.runChange(func() {
+				e.handleInput(ev.Rune())
+			})
 		}
 	}
 }
 
-func (e *editor) loadBufferFromFile(fn string) error {
-	if _, err := os.Stat(fn); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			e.addNewBuffer()
-			e.bufs[len(e.bufs)-1].fname = fn
-			return nil
-		}
-	}
+// runChange runs a command or input on the current buffer. All edits that it
+// makes are undone and redone together. If the buffer is read-only, the cursor
+// stays where it was.
+func (e *editor) runChange(f func()) {
+	e.arrange()
 
-	f, err := os.Open(fn)
-	if err != nil {
-		return err
+	v := e.curView()
+	curBuf := v.buf
+	cs := v.cursorState()
+
+	v.beginChange()
+	f()
+	v.endChange()
+
+	if curBuf.readOnlyEdit {
+		curBuf.readOnlyEdit = false
+		v.setCursorState(cs)
+		e.showError("Buffer is read-only")
 	}
-	defer f.Close()
+}
+
+func (e *editor) loadBufferFromFile(fn string) error {
+	var buf *buffer
 
-	scanner := bufio.NewScanner(f)
+	if _, err := os.Stat(fn); err != nil && errors.Is(err, os.ErrNotExist) {
+		buf = newBuffer(nil)
+	} else {
+		data, err := os.ReadFile(fn)
+		if err != nil {
+			return err
+		}
 
-	buf := &buffer{
-		fname:      fn,
-		historyIdx: -1,
+		disk := newFileState(fn, data)
+		buf = newBufferFromFileContent(data)
+		buf.disk = disk
+
+		log.Printf("loadBufferFromFile: loaded %s: %s", fn, buf.formatName())
 	}
 
-	for scanner.Scan() {
-		buf.lines = append(buf.lines, []rune(scanner.Text()))
+	buf.fname = fn
+
+	if err := e.loadUndoHistory(buf); err != nil {
+		log.Printf("loadBufferFromFile: loading undo history of %s failed: %v", fn, err)
 	}
 
-	if len(buf.lines) == 0 {
-		buf.lines = [][]rune{{}}
+	if _, err := os.Stat(swapFileName(fn)); err == nil {
+		log.Printf("loadBufferFromFile: found swap file for %s", fn)
+		buf.swapFound = true
 	}
 
 	e.bufs = append(e.bufs, buf)
@@ -140,73 +359,63 @@ func (e *editor) loadBufferFromFile(fn string) error {
 }
 
 func (e *editor) addNewBuffer() {
-	e.bufs = append(e.bufs, &buffer{
-		lines:      [][]rune{{}},
-		historyIdx: -1,
-	})
+	e.bufs = append(e.bufs, newBuffer(nil))
+}
+
+// addTextBuffer adds a buffer without file name that contains text, e.g. a
+// diff, and switches to it.
+func (e *editor) addTextBuffer(text string) {
+	e.bufs = append(e.bufs, newBuffer([]byte(strings.TrimSuffix(text, "\n"))))
+	e.bufIdx = len(e.bufs) - 1
 }
 
 func (e *editor) handleInput(r rune) {
 	log.Printf("handleInput: rune = %c", r)
 
-	curBuf := e.bufs[e.bufIdx]
-	lineIdx := curBuf.y + curBuf.offset
-	curLine := curBuf.lines[lineIdx]
+	v := e.curView()
 
-	if curBuf.x >= len(curLine) {
-		curLine = append(curLine, r)
-	} else {
-		curLine = append(curLine[:curBuf.x], append([]rune{r}, curLine[curBuf.x:]...)...)
-	}
-	curBuf.lines[lineIdx] = curLine
-
-	curBuf.historyAddRune(r)
+	v.insertText(v.curLineIdx(), v.x, [][]rune{{r}})
 
-	curBuf.x++
-
-	curBuf.modified = true
+	v.x++
 }
 
 func (e *editor) saveFile(curBuf *buffer) {
-	tmpName := filepath.Join(filepath.Dir(curBuf.fname), fmt.Sprintf(".tmp%x", time.Now().UnixNano()))
-
-	log.Printf("saveFile: saving buffer of %d lines to %s (temporary file: %s)", len(curBuf.lines), curBuf.fname, tmpName)
+	log.Printf("saveFile: saving buffer of %d lines to %s", curBuf.lineCount(), curBuf.fname)
 
-	f, err := os.OpenFile(tmpName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	if err != nil {
-		log.Printf("saveFile: opening temporary file %s failed: %v", tmpName, err)
-		e.showError("Failed to open temporary file: %v", err)
-		return
+	h := sha256.New()
+	write := func(w io.Writer) error {
+		return curBuf.writeFileContent(io.MultiWriter(w, h))
 	}
-	defer f.Close()
 
-	w := bufio.NewWriter(f)
-	for _, line := range curBuf.lines {
-		if _, err := w.WriteString(string(line) + "\n"); err != nil {
-			log.Printf("saveFile: writing to temporary file failed: %v", err)
-			e.showError("Failed to write to temporary file: %v", err)
-			return
-		}
-	}
-	if err := w.Flush(); err != nil {
-		log.Printf("saveFile: Flush failed: %v", err)
-		e.showError("Failed to write file content: %v", err)
+	if err := writeFileAtomically(curBuf.fname, 0644, write); err != nil {
+		log.Printf("saveFile: saving %s failed: %v", curBuf.fname, err)
+		e.showError("Failed to save %s: %v", curBuf.fname, err)
 		return
 	}
 
-	if err := os.Rename(tmpName, curBuf.fname); err != nil {
-		log.Printf("saveFile: Rename failed: %v", err)
-		e.showError("Failed to replace %s with temporary file %s: %v", curBuf.fname, tmpName, err)
-		return
-	}
+	var hash [sha256.Size]byte
+	copy(hash[:], h.Sum(nil))
+
+	// undoing the next change should restore the saved text.
+	curBuf.historyFinishOp()
 
 	curBuf.modified = false
+	curBuf.savedState = curBuf.history.cur
+	curBuf.clearMarks(markModified)
+	curBuf.disk = newFileStateFromHash(curBuf.fname, hash)
+
+	if err := e.saveUndoHistory(curBuf); err != nil {
+		log.Printf("saveFile: saving undo history of %s failed: %v", curBuf.fname, err)
+		e.showError("Failed to save undo history of %s: %v", curBuf.fname, err)
+	}
+
+	e.removeSwapFile(curBuf)
 }
 
-func (e *editor) updateSelectedTextPos(buf *buffer) {
-	if buf.selecting {
-		buf.endX, buf.endY = buf.x, buf.offset+buf.y
-		log.Printf("updateSelectedTextPos: new selection end point at %d/%d", buf.endY, buf.endX)
+func (e *editor) updateSelectedTextPos(v *view) {
+	if v.selecting {
+		v.endX, v.endY = v.x, v.offset+v.y
+		log.Printf("updateSelectedTextPos: new selection end point at %d/%d", v.endY, v.endX)
 	}
 }
 
@@ -215,36 +424,81 @@ func (e *editor) showError(s string, args ...interface{}) {
 
 	width, height := e.scr.Size()
 
-	e.clearLine(height-1, width, tcell.StyleDefault)
+	e.clearLine(height-1, width, e.theme.style(slotText))
 
 	x := 0
 	for _, r := range str {
-		e.scr.SetContent(x, height-1, r, nil, tcell.StyleDefault)
+		e.scr.SetContent(x, height-1, r, nil, e.theme.style(slotText))
 		x += runewidth.RuneWidth(r)
 	}
 }
 
+// handleInterrupt runs what other goroutines posted to the input loop.
+func (e *editor) handleInterrupt(ev *tcell.EventInterrupt) {
+	switch data := ev.Data().(type) {
+	case tick:
+		e.updateSwapFiles()
+		e.checkFilesChanged()
+	case grepResults:
+		e.addGrepResults(data)
+	case grepDone:
+		e.grepFinished(data)
+	}
+}
+
+// pollEvent waits for the next event for prompts and other commands that read
+// input themselves. Results of a running grep are added right away so that
+// none are lost while a prompt is open. Ticks are skipped, as checking for
+// changed files would ask questions in the middle of the prompt; the next
+// tick comes after the prompt is done.
+func (e *editor) pollEvent() tcell.Event {
+	for {
+		evt := e.scr.PollEvent()
+		ev, ok := evt.(*tcell.EventInterrupt)
+		if !ok {
+			return evt
+		}
+		if _, ok := ev.Data().(tick); ok {
+			continue
+		}
+		e.handleInterrupt(ev)
+	}
+}
+
 func (e *editor) readString(prompt string, inputRunes []rune) (input string, ok bool) {
+	return e.readStringFunc(prompt, inputRunes, nil)
+}
+
+// readStringFunc works like readString, but calls update once at the start
+// with a nil event and then after every key, once the key was applied to the
+// input. update can act on the input as it is typed and on keys that aren't
+// used for editing, and returns the prompt to show.
+func (e *editor) readStringFunc(prompt string, inputRunes []rune, update func(ev *tcell.EventKey, input []rune) string) (input string, ok bool) {
 	log.Printf("readString: prompt %q, initial text %q", prompt, string(inputRunes))
 
+	// the input is edited in place, so the initial text mustn't share its
+	// array with the caller's.
+	inputRunes = append([]rune(nil), inputRunes...)
 	cursorPos := len(inputRunes)
 
 	defer func() {
 		width, height := e.scr.Size()
-		e.clearLine(height-1, width, tcell.StyleDefault)
+		e.clearLine(height-1, width, e.theme.style(slotText))
 	}()
 
-	prompt += ": "
+	if update != nil {
+		prompt = update(nil, inputRunes)
+	}
 
 	for {
 		width, height := e.scr.Size()
 
-		e.clearLine(height-1, width, tcell.StyleDefault)
+		e.clearLine(height-1, width, e.theme.style(slotText))
 
-		promptStyle := tcell.StyleDefault.Bold(true)
+		promptStyle := e.theme.style(slotPrompt)
 
 		x := 0
-		for _, r := range prompt {
+		for _, r := range prompt + ": " {
 			e.scr.SetContent(x, height-1, r, nil, promptStyle)
 			x += runewidth.RuneWidth(r)
 		}
@@ -262,7 +516,7 @@ func (e *editor) readString(prompt string, inputRunes []rune) (input string, ok
 				cursorPosFound = true
 			}
 
-			e.scr.SetContent(inputx, height-1, r, nil, tcell.StyleDefault)
+			e.scr.SetContent(inputx, height-1, r, nil, e.theme.style(slotText))
 
 			i++
 			inputx += runewidth.RuneWidth(r)
@@ -274,7 +528,7 @@ func (e *editor) readString(prompt string, inputRunes []rune) (input string, ok
 
 		e.scr.Show()
 
-		evt := e.scr.PollEvent()
+		evt := e.pollEvent()
 		switch ev := evt.(type) {
 		case *tcell.EventResize:
 			e.redrawScreen()
@@ -301,8 +555,8 @@ func (e *editor) readString(prompt string, inputRunes []rune) (input string, ok
 			case tcell.KeyDEL: // backspace
 				if cursorPos > 0 {
 					inputRunes = append(inputRunes[:cursorPos-1], inputRunes[cursorPos:]...)
+					cursorPos--
 				}
-				cursorPos--
 			case tcell.KeyDelete: // DEL
 				if cursorPos < len(inputRunes) {
 					inputRunes = append(inputRunes[:cursorPos], inputRunes[cursorPos+1:]...)
@@ -317,10 +571,18 @@ func (e *editor) readString(prompt string, inputRunes []rune) (input string, ok
 			case tcell.KeyCtrlE:
 				cursorPos = len(inputRunes)
 			case tcell.KeyRune:
+				if ev.Modifiers()&tcell.ModAlt != 0 {
+					// left to update.
+					break
+				}
 				log.Printf("readString: rune input: %[1]c (%[1]d)", ev.Rune())
 				inputRunes = append(inputRunes[:cursorPos], append([]rune{ev.Rune()}, inputRunes[cursorPos:]...)...)
 				cursorPos++
 			}
+
+			if update != nil {
+				prompt = update(ev, inputRunes)
+			}
 		}
 	}
 }
@@ -329,7 +591,7 @@ func (e *editor) query(prompt string, validAnswers string) rune {
 	log.Printf("query: prompt %q valid answers: %q", prompt, validAnswers)
 	defer func() {
 		width, height := e.scr.Size()
-		e.clearLine(height-1, width, tcell.StyleDefault)
+		e.clearLine(height-1, width, e.theme.style(slotText))
 	}()
 
 	prompt += " [" + validAnswers + "]"
@@ -337,9 +599,9 @@ func (e *editor) query(prompt string, validAnswers string) rune {
 	for {
 		width, height := e.scr.Size()
 
-		e.clearLine(height-1, width, tcell.StyleDefault)
+		e.clearLine(height-1, width, e.theme.style(slotText))
 
-		promptStyle := tcell.StyleDefault.Bold(true)
+		promptStyle := e.theme.style(slotPrompt)
 
 		x := 0
 		for _, r := range prompt {
@@ -351,7 +613,7 @@ func (e *editor) query(prompt string, validAnswers string) rune {
 
 		e.scr.Show()
 
-		evt := e.scr.PollEvent()
+		evt := e.pollEvent()
 		switch ev := evt.(type) {
 		case *tcell.EventResize:
 			e.redrawScreen()
@@ -374,56 +636,193 @@ func (e *editor) redrawScreen() {
 
 	log.Printf("redrawScreen: %dx%d", width, height)
 
-	curBuf := e.bufs[e.bufIdx]
+	e.arrange()
+	e.drawLayout(e.layout)
 
-	for i := curBuf.offset; i < curBuf.offset+height-2; i++ {
-		e.drawLine(curBuf, i-curBuf.offset, i, width, i == curBuf.curLineIdx())
+	if e.tabBar {
+		e.drawTabBar(width)
 	}
 
-	x := runeWidth(curBuf.curLine()[:curBuf.x])
+	e.scr.Show()
 
-	e.scr.ShowCursor(x, curBuf.y)
+	e.clearLine(height-1, width, e.theme.style(slotText))
+}
 
-	e.drawStatus(height-2, width)
+// drawWindow draws the text of the buffer of a split and its status line. The
+// cursor is placed in the current split.
+func (e *editor) drawWindow(win *window) {
+	v := win.view
 
-	e.scr.Show()
+	// the text at the cursor may have been removed while reloading the file.
+	v.correctY()
+	v.correctX()
 
-	e.clearLine(height-1, width, tcell.StyleDefault)
+	gutter := e.gutterWidth(v.buf)
+
+	if v.wrap != wrapNone {
+		e.drawWrapped(win, gutter)
+	} else {
+		// the split may have become smaller since the cursor moved.
+		if v.height > 0 && v.y >= v.height {
+			v.offset += v.y - v.height + 1
+			v.y = v.height - 1
+		}
+
+		v.scrollHorizontally(win.width - gutter)
+
+		for i := v.offset; i < v.offset+v.height; i++ {
+			y := win.top + i - v.offset
+			e.drawGutter(v, win.left, y, i, true)
+			e.drawLine(v, y, win.left+gutter, i, win.width-gutter, i == v.curLineIdx())
+		}
 
-	for i := 0; i < len(curBuf.lines); i++ {
-		log.Printf("redrawScreen: line %d = %q\n", i, string(curBuf.lines[i]))
+		if win == e.win {
+			x := runeWidth(v.curLine()[:v.x]) - v.xOffset
+			e.scr.ShowCursor(win.left+gutter+x, win.top+v.y)
+		}
 	}
+
+	e.drawStatus(win)
 }
 
-func (e *editor) drawLine(buf *buffer, y int, lineIdx int, width int, curLine bool) {
-	if len(buf.lines) <= lineIdx {
-		e.scr.SetContent(0, y, '~', nil, tcell.StyleDefault.Bold(true))
+// drawLine draws line lineIdx of the buffer of v in screen row y, starting at
+// screen column left.
+func (e *editor) drawLine(v *view, y int, left int, lineIdx int, width int, curLine bool) {
+	if v.buf.lineCount() <= lineIdx {
+		e.drawRow(v, y, left, lineIdx, 0, 0, 0, width, curLine)
+		return
+	}
+	e.drawRow(v, y, left, lineIdx, 0, v.buf.lineLen(lineIdx), v.xOffset, width, curLine)
+}
+
+// drawWrapped draws the text of the buffer of a split with long lines wrapped
+// into several screen rows right of the gutter, and places the cursor in the
+// current split.
+func (e *editor) drawWrapped(win *window, gutter int) {
+	v := win.view
+	width := win.width - gutter
+	left := win.left + gutter
+
+	v.wrapWidth = width
+	v.scrollWrapped()
+
+	curRow, curCol := v.cursorRow()
+
+	lineIdx, row := v.offset, v.rowOffset
+	for y := win.top; y < win.top+v.height; y++ {
+		if lineIdx >= v.buf.lineCount() {
+			e.drawGutter(v, win.left, y, lineIdx, true)
+			e.drawLine(v, y, left, lineIdx, width, false)
+			continue
+		}
+
+		starts := v.wrapRows(lineIdx)
+		end := v.buf.lineLen(lineIdx)
+		if row+1 < len(starts) {
+			end = starts[row+1]
+		}
+
+		e.drawGutter(v, win.left, y, lineIdx, row == 0)
+		e.drawRow(v, y, left, lineIdx, starts[row], end, 0, width, lineIdx == v.curLineIdx())
+
+		if win == e.win && lineIdx == v.curLineIdx() && row == curRow {
+			e.scr.ShowCursor(left+curCol, y)
+		}
+
+		row++
+		if row == len(starts) {
+			lineIdx, row = lineIdx+1, 0
+		}
+	}
+}
+
+// drawRow draws the runes from index from up to index to of line lineIdx of
+// the buffer of v in the width columns of screen row y from column left on,
+// starting at display column xOffset of the row. If text is cut off at an
+// edge, the edge shows < or $.
+func (e *editor) drawRow(v *view, y int, left int, lineIdx int, from int, to int, xOffset int, width int, curLine bool) {
+	if v.buf.lineCount() <= lineIdx {
+		e.scr.SetContent(left, y, '~', nil, e.theme.style(slotNonText))
 		for i := 1; i < width; i++ {
-			e.scr.SetContent(i, y, ' ', nil, tcell.StyleDefault)
+			e.scr.SetContent(left+i, y, ' ', nil, e.theme.style(slotText))
 		}
 		return
 	}
 
-	line := buf.lines[lineIdx]
+	line := v.buf.line(lineIdx)
+	classes := e.highlightLine(v.buf, lineIdx)
 
-	style := tcell.StyleDefault.Underline(curLine)
+	style := e.theme.style(slotText)
+	if curLine {
+		style = e.theme.apply(slotCurrentLine, style)
+	}
 
-	e.clearLine(y, width, style)
+	for i := 0; i < width; i++ {
+		e.scr.SetContent(left+i, y, ' ', nil, style)
+	}
 
-	x := 0
-	for idx, r := range line {
-		if x >= width {
-			r = '$'
-		} else if r == '\t' {
-			r = ' '
-			x += tabWidth - 1
+	// highlighted matches end before matchEnd, the match at the cursor is
+	// highlighted like a selection.
+	matches := v.buf.findOptions.indexAll(line, v.buf.highlightPhrase)
+	matchEnd, curMatch := 0, -1
+	if lineIdx == v.curLineIdx() {
+		for _, idx := range matches {
+			if idx == v.x {
+				curMatch = idx
+			}
+		}
+	}
+
+	// block selections are made of display columns of the whole line.
+	lineCol := runeWidth(line[:from])
+
+	col := lineCol
+	for idx := from; idx < to; idx++ {
+		r := line[idx]
+		w := runeCellWidth(r)
+		x := col - lineCol - xOffset
+
+		for len(matches) > 0 && matches[0] <= idx {
+			matchEnd = matches[0] + len(v.buf.highlightPhrase)
+			matches = matches[1:]
 		}
+
 		charStyle := style
-		if buf.isWithinSelectedText(lineIdx, idx) {
-			charStyle = charStyle.Background(tcell.ColorYellow).Foreground(tcell.ColorBlack)
+		if classes != nil {
+			charStyle = e.theme.syntaxStyle(classes[idx], style)
+		}
+		selected := v.isWithinSelectedText(lineIdx, idx)
+		if v.blockMode {
+			selected = v.isWithinSelectedBlock(lineIdx, col)
+		}
+		switch {
+		case selected || (curMatch >= 0 && idx >= curMatch && idx < curMatch+len(v.buf.highlightPhrase)):
+			charStyle = e.theme.apply(slotSelection, charStyle)
+		case idx < matchEnd:
+			charStyle = e.theme.apply(slotSearchMatch, charStyle)
+		}
+
+		col += w
+
+		if x >= 0 && (x+w > width || (x+w == width && idx+1 < to)) {
+			e.scr.SetContent(left+width-1, y, '$', nil, style)
+			break
+		}
+
+		switch {
+		case x < 0:
+			// scrolled out of view to the left.
+		case r == '\t':
+			for i := 0; i < w; i++ {
+				e.scr.SetContent(left+x+i, y, ' ', nil, charStyle)
+			}
+		default:
+			e.scr.SetContent(left+x, y, r, nil, charStyle)
 		}
-		e.scr.SetContent(x, y, r, nil, charStyle)
-		x += runewidth.RuneWidth(r)
+	}
+
+	if xOffset > 0 && to > from {
+		e.scr.SetContent(left, y, '<', nil, style)
 	}
 }
 
@@ -433,31 +832,53 @@ func (e *editor) clearLine(y int, width int, style tcell.Style) {
 	}
 }
 
-func (e *editor) drawStatus(y int, width int) {
-	curBuf := e.bufs[e.bufIdx]
+// drawStatus draws the status line of a split in its last row.
+func (e *editor) drawStatus(win *window) {
+	buf := win.buf
 
 	status := ""
-	if curBuf.modified {
+	if buf.modified {
 		status += "* "
 	} else {
 		status += "- "
 	}
 
-	if curBuf.fname == "" {
+	if buf.fname == "" {
 		status += "<no file> "
 	} else {
-		status += curBuf.fname + " "
+		status += buf.fname + " "
+	}
+
+	if buf.readOnly {
+		status += "[read-only] "
+	}
+
+	status += fmt.Sprintf("(%d of %d) [%d|%d-%d] %s - ", e.bufferIndex(buf)+1, len(e.bufs), win.view.curLineIdx(), win.view.x, runeWidth(win.view.curLine()[:win.view.x]), buf.formatName())
+
+	if win == e.win && len(e.pendingKeys) > 0 {
+		status += fmt.Sprintf("%s - (Ctrl-G to cancel)", e.pendingKeys)
+	} else {
+		status += "Press Ctrl-H for Help"
 	}
 
-	status += fmt.Sprintf("(%d of %d) [%d|%d-%d] - Press Ctrl-H for Help", e.bufIdx+1, len(e.bufs), curBuf.curLineIdx(), curBuf.x, runeWidth(curBuf.curLine()[:curBuf.x]))
+	statusStyle := e.theme.style(slotStatusBar)
+	if win != e.win {
+		statusStyle = e.theme.apply(slotStatusBarInactive, statusStyle)
+	}
 
-	statusStyle := tcell.StyleDefault.Reverse(true)
+	y := win.top + win.height - 1
 
-	e.clearLine(y, width, statusStyle)
+	for x := 0; x < win.width; x++ {
+		e.scr.SetContent(win.left+x, y, ' ', nil, statusStyle)
+	}
 
 	x := 0
 	for _, r := range status {
-		e.scr.SetContent(x, y, r, nil, statusStyle)
-		x += runewidth.RuneWidth(r)
+		w := runewidth.RuneWidth(r)
+		if x+w > win.width {
+			break
+		}
+		e.scr.SetContent(win.left+x, y, r, nil, statusStyle)
+		x += w
 	}
 }
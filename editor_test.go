@@ -3,6 +3,8 @@ package main
 import (
 	"io"
 	"log"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gdamore/tcell/v2"
@@ -16,6 +18,35 @@ func playKeys(t *testing.T, ed *editor, keys ...*tcell.EventKey) {
 	}
 }
 
+// postKeys queues keys from a separate goroutine so that commands reading
+// further input (prompts, queries) can consume more events than the
+// simulation screen's event queue can hold.
+func postKeys(ed *editor, keys ...*tcell.EventKey) {
+	go func() {
+		for _, key := range keys {
+			ed.scr.PostEventWait(key)
+		}
+	}()
+}
+
+// newTestEditor returns an editor on a simulation screen of 40x10 cells with
+// a buffer for each of texts.
+func newTestEditor(t *testing.T, texts ...string) *editor {
+	t.Helper()
+	log.SetOutput(io.Discard)
+
+	scr := tcell.NewSimulationScreen("utf-8")
+	require.NoError(t, scr.Init())
+	scr.SetSize(40, 10)
+
+	ed := newEditor(scr)
+	for _, text := range texts {
+		ed.bufs = append(ed.bufs, newBufferFromFileContent([]byte(text)))
+	}
+
+	return ed
+}
+
 func TestEditor1(t *testing.T) {
 	log.SetOutput(io.Discard)
 
@@ -40,33 +71,30 @@ func TestEditor1(t *testing.T) {
 	)
 
 	require.True(t, ed.bufs[ed.bufIdx].modified)
-	require.Equal(t, [][]rune{{'a', 'b'}, {'c', 'd', 'e'}}, ed.bufs[ed.bufIdx].lines)
+	require.Equal(t, [][]rune{{'a', 'b'}, {'c', 'd', 'e'}}, ed.bufs[ed.bufIdx].lines())
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0))
 
-	require.Equal(t, ed.bufs[ed.bufIdx].lines, [][]rune{{}})
+	require.Equal(t, ed.bufs[ed.bufIdx].lines(), [][]rune{{}})
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlR, 0, 0))
 
-	require.Equal(t, [][]rune{{'a', 'b'}, {'c', 'd', 'e'}}, ed.bufs[ed.bufIdx].lines)
-
-	ed.bufs[ed.bufIdx].y = 1 // TODO: fix cursor placement after Ctrl-R and remove these two fixes.
-	ed.bufs[ed.bufIdx].x = 3
+	require.Equal(t, [][]rune{{'a', 'b'}, {'c', 'd', 'e'}}, ed.bufs[ed.bufIdx].lines())
 
-	require.Equal(t, 1, ed.bufs[ed.bufIdx].curLineIdx())
-	require.Equal(t, 3, ed.bufs[ed.bufIdx].x)
+	require.Equal(t, 1, ed.curView().curLineIdx())
+	require.Equal(t, 3, ed.curView().x)
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyBackspace2, 0, 0), tcell.NewEventKey(tcell.KeyBackspace2, 0, 0))
 
-	require.Equal(t, [][]rune{{'a', 'b'}, {'c'}}, ed.bufs[ed.bufIdx].lines)
+	require.Equal(t, [][]rune{{'a', 'b'}, {'c'}}, ed.bufs[ed.bufIdx].lines())
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0))
 
-	require.Equal(t, [][]rune{{'a', 'b'}, {'c', 'd', 'e'}}, ed.bufs[ed.bufIdx].lines)
+	require.Equal(t, [][]rune{{'a', 'b'}, {'c', 'd', 'e'}}, ed.bufs[ed.bufIdx].lines())
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlR, 0, 0))
 
-	require.Equal(t, [][]rune{{'a', 'b'}, {'c'}}, ed.bufs[ed.bufIdx].lines)
+	require.Equal(t, [][]rune{{'a', 'b'}, {'c'}}, ed.bufs[ed.bufIdx].lines())
 
 	require.NoError(t, scr.PostEvent(tcell.NewEventKey(tcell.KeyCtrlQ, 0, 0)))
 	require.NoError(t, scr.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'n', 0)))
@@ -107,77 +135,77 @@ func TestEditor2(t *testing.T) {
 	)
 
 	require.True(t, ed.bufs[ed.bufIdx].modified)
-	require.Equal(t, [][]rune{{'q', 'w'}, {'e', 'r', 't'}, {'z', 'u', 'i', 'o'}}, ed.bufs[ed.bufIdx].lines)
+	require.Equal(t, [][]rune{{'q', 'w'}, {'e', 'r', 't'}, {'z', 'u', 'i', 'o'}}, ed.bufs[ed.bufIdx].lines())
 
-	require.Equal(t, 2, ed.bufs[ed.bufIdx].curLineIdx())
-	require.Equal(t, 4, ed.bufs[ed.bufIdx].x)
+	require.Equal(t, 2, ed.curView().curLineIdx())
+	require.Equal(t, 4, ed.curView().x)
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlA, 0, 0))
-	require.Equal(t, 0, ed.bufs[ed.bufIdx].x)
+	require.Equal(t, 0, ed.curView().x)
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlE, 0, 0))
-	require.Equal(t, 4, ed.bufs[ed.bufIdx].x)
+	require.Equal(t, 4, ed.curView().x)
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyUp, 0, 0))
-	require.Equal(t, 1, ed.bufs[ed.bufIdx].curLineIdx())
-	require.Equal(t, 3, ed.bufs[ed.bufIdx].x)
+	require.Equal(t, 1, ed.curView().curLineIdx())
+	require.Equal(t, 3, ed.curView().x)
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlA, 0, 0))
-	require.Equal(t, 0, ed.bufs[ed.bufIdx].x)
+	require.Equal(t, 0, ed.curView().x)
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyDEL, 0, 0))
-	require.Equal(t, 0, ed.bufs[ed.bufIdx].curLineIdx())
-	require.Equal(t, 2, ed.bufs[ed.bufIdx].x)
+	require.Equal(t, 0, ed.curView().curLineIdx())
+	require.Equal(t, 2, ed.curView().x)
 
-	require.Equal(t, [][]rune{{'q', 'w', 'e', 'r', 't'}, {'z', 'u', 'i', 'o'}}, ed.bufs[ed.bufIdx].lines)
+	require.Equal(t, [][]rune{{'q', 'w', 'e', 'r', 't'}, {'z', 'u', 'i', 'o'}}, ed.bufs[ed.bufIdx].lines())
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyEnter, 0, 0))
 
-	require.Equal(t, [][]rune{{'q', 'w'}, {'e', 'r', 't'}, {'z', 'u', 'i', 'o'}}, ed.bufs[ed.bufIdx].lines)
+	require.Equal(t, [][]rune{{'q', 'w'}, {'e', 'r', 't'}, {'z', 'u', 'i', 'o'}}, ed.bufs[ed.bufIdx].lines())
 
-	require.Equal(t, 1, ed.bufs[ed.bufIdx].curLineIdx())
-	require.Equal(t, 0, ed.bufs[ed.bufIdx].x)
+	require.Equal(t, 1, ed.curView().curLineIdx())
+	require.Equal(t, 0, ed.curView().x)
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyDelete, 0, 0))
 
-	require.Equal(t, [][]rune{{'q', 'w'}, {'r', 't'}, {'z', 'u', 'i', 'o'}}, ed.bufs[ed.bufIdx].lines)
+	require.Equal(t, [][]rune{{'q', 'w'}, {'r', 't'}, {'z', 'u', 'i', 'o'}}, ed.bufs[ed.bufIdx].lines())
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyDown, 0, 0))
-	require.Equal(t, 2, ed.bufs[ed.bufIdx].curLineIdx())
-	require.Equal(t, 0, ed.bufs[ed.bufIdx].x)
+	require.Equal(t, 2, ed.curView().curLineIdx())
+	require.Equal(t, 0, ed.curView().x)
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyDown, 0, 0))
-	require.Equal(t, 2, ed.bufs[ed.bufIdx].curLineIdx())
-	require.Equal(t, 0, ed.bufs[ed.bufIdx].x)
+	require.Equal(t, 2, ed.curView().curLineIdx())
+	require.Equal(t, 0, ed.curView().x)
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyUp, 0, 0), tcell.NewEventKey(tcell.KeyUp, 0, 0), tcell.NewEventKey(tcell.KeyCtrlE, 0, 0))
-	require.Equal(t, 0, ed.bufs[ed.bufIdx].curLineIdx())
-	require.Equal(t, 2, ed.bufs[ed.bufIdx].x)
+	require.Equal(t, 0, ed.curView().curLineIdx())
+	require.Equal(t, 2, ed.curView().x)
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyDelete, 0, 0))
 
-	require.Equal(t, [][]rune{{'q', 'w', 'r', 't'}, {'z', 'u', 'i', 'o'}}, ed.bufs[ed.bufIdx].lines)
+	require.Equal(t, [][]rune{{'q', 'w', 'r', 't'}, {'z', 'u', 'i', 'o'}}, ed.bufs[ed.bufIdx].lines())
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyLeft, 0, 0))
-	require.Equal(t, 1, ed.bufs[ed.bufIdx].x)
+	require.Equal(t, 1, ed.curView().x)
 
 	playKeys(t, ed,
 		tcell.NewEventKey(tcell.KeyRight, 0, 0),
 		tcell.NewEventKey(tcell.KeyRight, 0, 0),
 		tcell.NewEventKey(tcell.KeyRight, 0, 0),
 	)
-	require.Equal(t, 4, ed.bufs[ed.bufIdx].x)
+	require.Equal(t, 4, ed.curView().x)
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyLeft, 0, 0), tcell.NewEventKey(tcell.KeyLeft, 0, 0))
-	require.Equal(t, 2, ed.bufs[ed.bufIdx].x)
+	require.Equal(t, 2, ed.curView().x)
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlU, 0, 0))
 
-	require.Equal(t, [][]rune{{'r', 't'}, {'z', 'u', 'i', 'o'}}, ed.bufs[ed.bufIdx].lines)
+	require.Equal(t, [][]rune{{'r', 't'}, {'z', 'u', 'i', 'o'}}, ed.bufs[ed.bufIdx].lines())
 
 	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlK, 0, 0))
 
-	require.Equal(t, [][]rune{{}, {'z', 'u', 'i', 'o'}}, ed.bufs[ed.bufIdx].lines)
+	require.Equal(t, [][]rune{{}, {'z', 'u', 'i', 'o'}}, ed.bufs[ed.bufIdx].lines())
 
 	require.NoError(t, scr.PostEvent(tcell.NewEventKey(tcell.KeyCtrlQ, 0, 0)))
 	require.NoError(t, scr.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'n', 0)))
@@ -188,3 +216,113 @@ func TestEditor2(t *testing.T) {
 
 	require.True(t, ed.quitInputLoop)
 }
+
+func TestReplace(t *testing.T) {
+	ed := newTestEditor(t, "foo boo\nzoo")
+
+	postKeys(
+		ed,
+		tcell.NewEventKey(tcell.KeyCtrlT, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, '(', 0),
+		tcell.NewEventKey(tcell.KeyRune, 'o', 0),
+		tcell.NewEventKey(tcell.KeyRune, ')', 0),
+		tcell.NewEventKey(tcell.KeyRune, 'o', 0),
+		tcell.NewEventKey(tcell.KeyEnter, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, '$', 0),
+		tcell.NewEventKey(tcell.KeyRune, '1', 0),
+		tcell.NewEventKey(tcell.KeyEnter, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, 'y', 0),
+		tcell.NewEventKey(tcell.KeyRune, 'q', 0),
+	)
+
+	ed.handleEvent()
+
+	require.Equal(t, [][]rune{[]rune("fo boo"), []rune("zoo")}, ed.bufs[ed.bufIdx].lines())
+	require.True(t, ed.bufs[ed.bufIdx].modified)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0))
+
+	require.Equal(t, [][]rune{[]rune("foo boo"), []rune("zoo")}, ed.bufs[ed.bufIdx].lines())
+
+	ed.curView().x = 0
+	ed.curView().y = 0
+
+	postKeys(
+		ed,
+		tcell.NewEventKey(tcell.KeyCtrlT, 0, 0),
+		tcell.NewEventKey(tcell.KeyEnter, 0, 0),
+		tcell.NewEventKey(tcell.KeyCtrlU, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, '0', 0),
+		tcell.NewEventKey(tcell.KeyEnter, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, 'n', 0),
+		tcell.NewEventKey(tcell.KeyRune, 'a', 0),
+	)
+
+	ed.handleEvent()
+
+	require.Equal(t, [][]rune{[]rune("foo b0"), []rune("z0")}, ed.bufs[ed.bufIdx].lines())
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0))
+
+	require.Equal(t, [][]rune{[]rune("foo boo"), []rune("zoo")}, ed.bufs[ed.bufIdx].lines())
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlR, 0, 0))
+
+	require.Equal(t, [][]rune{[]rune("foo b0"), []rune("z0")}, ed.bufs[ed.bufIdx].lines())
+}
+
+func TestBlockSelection(t *testing.T) {
+	ed := newTestEditor(t, "a1|b1\n例|子\na3|b3")
+	ed.curView().x = 2
+
+	playKeys(
+		t, ed,
+		tcell.NewEventKey(tcell.KeyCtrlY, 0, 0),
+		tcell.NewEventKey(tcell.KeyDown, 0, 0),
+		tcell.NewEventKey(tcell.KeyDown, 0, 0),
+		tcell.NewEventKey(tcell.KeyRight, 0, 0),
+		tcell.NewEventKey(tcell.KeyCtrlY, 0, 0),
+		tcell.NewEventKey(tcell.KeyCtrlC, 0, 0),
+	)
+
+	require.True(t, ed.clipboardBlock)
+	require.Equal(t, [][]rune{[]rune("|"), []rune("|"), []rune("|")}, ed.clipboard)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlX, 0, 0))
+
+	require.Equal(t, [][]rune{[]rune("a1b1"), []rune("例子"), []rune("a3b3")}, ed.bufs[ed.bufIdx].lines())
+	require.Equal(t, 0, ed.curView().curLineIdx())
+	require.Equal(t, 2, ed.curView().x)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyDown, 0, 0), tcell.NewEventKey(tcell.KeyDown, 0, 0), tcell.NewEventKey(tcell.KeyCtrlE, 0, 0))
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlV, 0, 0))
+
+	require.Equal(t, [][]rune{[]rune("a1b1"), []rune("例子"), []rune("a3b3|"), []rune("    |"), []rune("    |")}, ed.bufs[ed.bufIdx].lines())
+	require.True(t, ed.bufs[ed.bufIdx].modified)
+}
+
+func TestConvertLineEndings(t *testing.T) {
+	ed := newTestEditor(t)
+
+	fname := filepath.Join(t.TempDir(), "crlf.txt")
+	require.NoError(t, os.WriteFile(fname, []byte("a\r\nb"), 0644))
+
+	require.NoError(t, ed.loadBufferFromFile(fname))
+	require.Equal(t, [][]rune{[]rune("a"), []rune("b")}, ed.bufs[ed.bufIdx].lines())
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'c', 0), tcell.NewEventKey(tcell.KeyCtrlS, 0, 0))
+
+	data, err := os.ReadFile(fname)
+	require.NoError(t, err)
+	require.Equal(t, "ca\r\nb", string(data))
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, 'l', 0))
+	require.True(t, ed.bufs[ed.bufIdx].modified)
+	require.False(t, ed.bufs[ed.bufIdx].crlf)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlS, 0, 0))
+
+	data, err = os.ReadFile(fname)
+	require.NoError(t, err)
+	require.Equal(t, "ca\nb", string(data))
+}
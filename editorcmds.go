@@ -1,58 +1,63 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"regexp"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/mattn/go-runewidth"
 )
 
 func (e *editor) gotoBOL() {
-	curBuf := e.bufs[e.bufIdx]
-	log.Printf("gotoBOL: line %d set x = 0", curBuf.curLineIdx())
-	curBuf.x = 0
-	e.updateSelectedTextPos(curBuf)
+	v := e.curView()
+	curBuf := v.buf
+	log.Printf("gotoBOL: line %d set x = 0", v.curLineIdx())
+	v.x = 0
+	e.updateSelectedTextPos(v)
 	curBuf.historyFinishOp()
 }
 
 func (e *editor) gotoEOL() {
-	curBuf := e.bufs[e.bufIdx]
-	lineIdx := curBuf.y + curBuf.offset
-	curLine := curBuf.lines[lineIdx]
+	v := e.curView()
+	curBuf := v.buf
 
-	curBuf.x = len(curLine)
+	v.x = curBuf.lineLen(v.curLineIdx())
 
-	log.Printf("gotoEOL: line %d set x = %d", curBuf.curLineIdx(), curBuf.x)
+	log.Printf("gotoEOL: line %d set x = %d", v.curLineIdx(), v.x)
 
-	e.updateSelectedTextPos(curBuf)
+	e.updateSelectedTextPos(v)
 
 	curBuf.historyFinishOp()
 }
 
 func (e *editor) newLine() {
-	curBuf := e.bufs[e.bufIdx]
-	lineIdx := curBuf.curLineIdx()
+	v := e.curView()
+	curBuf := v.buf
+	lineIdx := v.curLineIdx()
 
-	curLine, nextLine := curBuf.lines[lineIdx][:curBuf.x], curBuf.lines[lineIdx][curBuf.x:]
-	log.Printf("newLine: line %d: %q -> %q, %q", lineIdx, string(curBuf.lines[lineIdx]), string(curLine), string(nextLine))
-	curBuf.lines[lineIdx] = curLine
-	curBuf.lines = append(curBuf.lines[:lineIdx+1], append([][]rune{nextLine}, curBuf.lines[lineIdx+1:]...)...)
-
-	_, height := e.scr.Size()
+	if curBuf.results != nil {
+		e.gotoResult(v)
+		return
+	}
 
-	curBuf.historyAddLine()
+	log.Printf("newLine: splitting line %d at %d", lineIdx, v.x)
+	v.insertText(lineIdx, v.x, [][]rune{{}, {}})
 
-	curBuf.incrY(height)
-	curBuf.x = 0
+	v.incrY()
+	v.x = 0
 }
 
 func (e *editor) keyBackspace() {
-	curBuf := e.bufs[e.bufIdx]
-	lineIdx := curBuf.curLineIdx()
+	v := e.curView()
+	curBuf := v.buf
+	lineIdx := v.curLineIdx()
 
-	if curBuf.x == 0 {
+	if v.x == 0 {
 		if lineIdx == 0 {
 			log.Printf("keyBackspace: nothing to do as we're on the leftmost character on the first line")
 			return
@@ -60,115 +65,119 @@ func (e *editor) keyBackspace() {
 
 		log.Printf("keyBackspace: joining line %d with previous line", lineIdx)
 
-		curBuf.x = len(curBuf.lines[lineIdx-1])
-		curBuf.lines[lineIdx-1] = append(curBuf.lines[lineIdx-1], curBuf.lines[lineIdx]...)
-		curBuf.lines = append(curBuf.lines[:lineIdx], curBuf.lines[lineIdx+1:]...)
+		v.x = curBuf.lineLen(lineIdx - 1)
+		v.deleteText(lineIdx-1, v.x, lineIdx, 0)
 
-		curBuf.decrY()
-
-		curBuf.historyRemoveLine()
+		v.decrY()
 		return
 	}
 
-	r := curBuf.lines[lineIdx][curBuf.x-1]
+	r := v.curLine()[v.x-1]
 
-	log.Printf("keyBackspace: deleting character %c in line %d col %d", r, lineIdx, curBuf.x-1)
+	log.Printf("keyBackspace: deleting character %c in line %d col %d", r, lineIdx, v.x-1)
 
-	curBuf.lines[lineIdx] = append(curBuf.lines[lineIdx][:curBuf.x-1], curBuf.lines[lineIdx][curBuf.x:]...)
-	curBuf.x--
-	curBuf.historyRemoveChar(r)
+	v.deleteText(lineIdx, v.x-1, lineIdx, v.x)
+	v.x--
 }
 
 func (e *editor) keyDel() {
-	curBuf := e.bufs[e.bufIdx]
-	lineIdx := curBuf.curLineIdx()
+	v := e.curView()
+	curBuf := v.buf
+	lineIdx := v.curLineIdx()
 
-	if curBuf.x == len(curBuf.lines[lineIdx]) {
-		if lineIdx == len(curBuf.lines)-1 {
+	if v.x == curBuf.lineLen(lineIdx) {
+		if lineIdx == curBuf.lineCount()-1 {
 			log.Printf("keyDel: nothing to do as we're on the last character on the last line")
 			return
 		}
 
 		log.Printf("keyDel: joining line %d with next line", lineIdx)
 
-		curBuf.lines[lineIdx] = append(curBuf.lines[lineIdx], curBuf.lines[lineIdx+1]...)
-		curBuf.lines = append(curBuf.lines[:lineIdx+1], curBuf.lines[lineIdx+2:]...)
-		curBuf.historyRemoveLine()
+		v.deleteText(lineIdx, v.x, lineIdx+1, 0)
 		return
 	}
 
-	r := curBuf.lines[lineIdx][curBuf.x]
+	r := v.curLine()[v.x]
 
-	log.Printf("keyDel: deleting character %c in line %d col %d", r, lineIdx, curBuf.x)
+	log.Printf("keyDel: deleting character %c in line %d col %d", r, lineIdx, v.x)
 
-	curBuf.lines[lineIdx] = append(curBuf.lines[lineIdx][:curBuf.x], curBuf.lines[lineIdx][curBuf.x+1:]...)
-	curBuf.historyRemoveChar(r)
+	v.deleteText(lineIdx, v.x, lineIdx, v.x+1)
 }
 
 func (e *editor) keyUp() {
-	curBuf := e.bufs[e.bufIdx]
+	v := e.curView()
+	curBuf := v.buf
 
-	if (curBuf.curLineIdx()) == 0 {
+	if v.wrap != wrapNone {
+		if !v.rowUp() {
+			log.Printf("keyUp: in first row already")
+			return
+		}
+	} else if (v.curLineIdx()) == 0 {
 		log.Printf("keyUp: in first line already")
 		return
+	} else {
+		v.decrY()
+		v.correctX()
 	}
 
-	curBuf.decrY()
-
-	curBuf.correctX()
+	log.Printf("keyUp: y = %d offset = %d x = %d", v.y, v.offset, v.x)
 
-	log.Printf("keyUp: y = %d offset = %d x = %d", curBuf.y, curBuf.offset, curBuf.x)
-
-	e.updateSelectedTextPos(curBuf)
+	e.updateSelectedTextPos(v)
 
 	curBuf.historyFinishOp()
 }
 
 func (e *editor) keyDown() {
-	curBuf := e.bufs[e.bufIdx]
+	v := e.curView()
+	curBuf := v.buf
 
-	if (curBuf.y + curBuf.offset) >= len(curBuf.lines)-1 {
+	if v.wrap != wrapNone {
+		if !v.rowDown() {
+			log.Printf("keyDown: in last row already")
+			return
+		}
+	} else if (v.y + v.offset) >= curBuf.lineCount()-1 {
 		log.Printf("keyDown: in last line already")
 		return
+	} else {
+		v.incrY()
+		v.correctX()
 	}
 
-	_, height := e.scr.Size()
-
-	curBuf.incrY(height)
-
-	curBuf.correctX()
+	log.Printf("keyDown: y = %d offset = %d x = %d", v.y, v.offset, v.x)
 
-	log.Printf("keyDown: y = %d offset = %d x = %d", curBuf.y, curBuf.offset, curBuf.x)
-
-	e.updateSelectedTextPos(curBuf)
+	e.updateSelectedTextPos(v)
 
 	curBuf.historyFinishOp()
 }
 
 func (e *editor) keyLeft() {
-	curBuf := e.bufs[e.bufIdx]
+	v := e.curView()
+	curBuf := v.buf
 
-	if curBuf.x > 0 {
-		curBuf.x--
+	if v.x > 0 {
+		v.x--
 	}
 
-	log.Printf("keyLeft: line %d x = %d", curBuf.curLineIdx(), curBuf.x)
+	log.Printf("keyLeft: line %d x = %d", v.curLineIdx(), v.x)
 
-	e.updateSelectedTextPos(curBuf)
+	e.updateSelectedTextPos(v)
 
 	curBuf.historyFinishOp()
 }
 
 func (e *editor) keyRight() {
-	curBuf := e.bufs[e.bufIdx]
+	v := e.curView()
+	curBuf := v.buf
 
-	if len(curBuf.curLine()) > curBuf.x {
-		curBuf.x++
+	if len(v.curLine()) > v.x {
+		v.x++
 	}
 
-	log.Printf("keyLeft: line %d x = %d", curBuf.curLineIdx(), curBuf.x)
+	log.Printf("keyLeft: line %d x = %d", v.curLineIdx(), v.x)
 
-	e.updateSelectedTextPos(curBuf)
+	e.updateSelectedTextPos(v)
 
 	curBuf.historyFinishOp()
 }
@@ -194,11 +203,15 @@ func (e *editor) quit() {
 	}
 
 	// all files checked whether user wants to save them -> quit
+	for _, buf := range e.bufs {
+		e.removeSwapFile(buf)
+	}
+
 	e.quitInputLoop = true
 }
 
 func (e *editor) save() {
-	curBuf := e.bufs[e.bufIdx]
+	curBuf := e.curView().buf
 
 	log.Printf("save: saving buffer %d (%q)", e.bufIdx, curBuf.fname)
 
@@ -210,13 +223,20 @@ func (e *editor) save() {
 		}
 
 		curBuf.fname = fname
+	} else {
+		data, changed, err := curBuf.changedOnDisk()
+		if err != nil {
+			log.Printf("save: checking %s for changes failed: %v", curBuf.fname, err)
+		} else if changed && !e.resolveFileChange(curBuf, data, true) {
+			return
+		}
 	}
 
 	e.saveFile(curBuf)
 }
 
 func (e *editor) saveAs() {
-	curBuf := e.bufs[e.bufIdx]
+	curBuf := e.curView().buf
 
 	log.Printf("saveAs: saving buffer %d under new name", e.bufIdx)
 
@@ -259,49 +279,73 @@ func (e *editor) prevBuffer() {
 }
 
 func (e *editor) deleteToEOL() {
-	curBuf := e.bufs[e.bufIdx]
+	v := e.curView()
+	curBuf := v.buf
 
-	log.Printf("deleteToEOL: line %d x = %d", curBuf.curLineIdx(), curBuf.x)
+	log.Printf("deleteToEOL: line %d x = %d", v.curLineIdx(), v.x)
 
-	curBuf.lines[curBuf.curLineIdx()] = curBuf.curLine()[:curBuf.x]
+	v.deleteText(v.curLineIdx(), v.x, v.curLineIdx(), curBuf.lineLen(v.curLineIdx()))
 }
 
 func (e *editor) deleteFromBOL() {
-	curBuf := e.bufs[e.bufIdx]
+	v := e.curView()
 
-	log.Printf("deleteFromBOL: line %d x = %d", curBuf.curLineIdx(), curBuf.x)
+	log.Printf("deleteFromBOL: line %d x = %d", v.curLineIdx(), v.x)
 
-	curBuf.lines[curBuf.curLineIdx()] = curBuf.curLine()[curBuf.x:]
-	curBuf.x = 0
+	v.deleteText(v.curLineIdx(), 0, v.curLineIdx(), v.x)
+	v.x = 0
 }
 
 func (e *editor) selectText() {
-	curBuf := e.bufs[e.bufIdx]
+	v := e.curView()
 
-	if !curBuf.selecting {
-		curBuf.startX, curBuf.startY = curBuf.x, curBuf.curLineIdx()
-		curBuf.endX, curBuf.endY = curBuf.startX, curBuf.startY
-		log.Printf("selectText: starting from %d/%d", curBuf.startY, curBuf.startX)
+	if !v.selecting {
+		v.startX, v.startY = v.x, v.curLineIdx()
+		v.endX, v.endY = v.startX, v.startY
+		v.blockMode = false
+		log.Printf("selectText: starting from %d/%d", v.startY, v.startX)
 	} else {
-		log.Printf("selectText: stopped at %d/%d", curBuf.endY, curBuf.endX)
+		log.Printf("selectText: stopped at %d/%d", v.endY, v.endX)
 	}
 
-	curBuf.selecting = !curBuf.selecting
+	v.selecting = !v.selecting
+}
+
+func (e *editor) selectBlock() {
+	v := e.curView()
+
+	if !v.selecting {
+		v.startX, v.startY = v.x, v.curLineIdx()
+		v.endX, v.endY = v.startX, v.startY
+		v.blockMode = true
+		log.Printf("selectBlock: starting from %d/%d", v.startY, v.startX)
+	} else {
+		log.Printf("selectBlock: stopped at %d/%d", v.endY, v.endX)
+	}
+
+	v.selecting = !v.selecting
 }
 
 func (e *editor) copyText() {
-	curBuf := e.bufs[e.bufIdx]
-	curBuf.selecting = false
+	v := e.curView()
+	curBuf := v.buf
+	v.selecting = false
+
+	if v.blockMode {
+		e.copyBlock()
+		return
+	}
 
-	lowerY, lowerX, higherY, higherX := curBuf.getSelection()
+	lowerY, lowerX, higherY, higherX := v.getSelection()
 
 	copiedData := [][]rune{}
 
 	for y := lowerY; y <= higherY; y++ {
+		line := curBuf.line(y)
 		var firstX, lastX int
 		if y == lowerY {
 			firstX = lowerX
-			lastX = len(curBuf.lines[y])
+			lastX = len(line)
 			if lowerY == higherY {
 				lastX = higherX
 			}
@@ -310,12 +354,13 @@ func (e *editor) copyText() {
 			lastX = higherX
 		} else {
 			firstX = 0
-			lastX = len(curBuf.lines[y])
+			lastX = len(line)
 		}
-		copiedData = append(copiedData, curBuf.lines[y][firstX:lastX])
+		copiedData = append(copiedData, line[firstX:lastX])
 	}
 
 	e.clipboard = copiedData
+	e.clipboardBlock = false
 
 	log.Printf("copyText: copied data to clipboard")
 	for idx, line := range e.clipboard {
@@ -323,146 +368,410 @@ func (e *editor) copyText() {
 	}
 }
 
+func (e *editor) copyBlock() {
+	v := e.curView()
+	curBuf := v.buf
+
+	lowerY, higherY, leftCol, rightCol := v.getBlockSelection()
+
+	copiedData := [][]rune{}
+
+	for y := lowerY; y <= higherY; y++ {
+		line := curBuf.line(y)
+		copiedData = append(copiedData, line[columnIndex(line, leftCol):columnIndex(line, rightCol)])
+	}
+
+	e.clipboard = copiedData
+	e.clipboardBlock = true
+
+	log.Printf("copyBlock: copied columns %d-%d of lines %d-%d to clipboard", leftCol, rightCol, lowerY, higherY)
+}
+
 func (e *editor) cutText() {
 	log.Printf("cutText: calling copyText first")
 	e.copyText()
 
-	curBuf := e.bufs[e.bufIdx]
-	lowerY, lowerX, higherY, higherX := curBuf.getSelection()
+	v := e.curView()
 
-	newX := len(curBuf.lines[lowerY][:lowerX])
+	if v.blockMode {
+		e.cutBlock()
+		return
+	}
+
+	lowerY, lowerX, higherY, higherX := v.getSelection()
 
-	replacementLine := append(curBuf.lines[lowerY][:lowerX], curBuf.lines[higherY][higherX:]...)
+	v.deleteText(lowerY, lowerX, higherY, higherX)
 
-	curBuf.lines = append(curBuf.lines[:lowerY], append([][]rune{replacementLine}, curBuf.lines[higherY+1:]...)...)
+	v.startY, v.startX, v.endY, v.endX = 0, 0, 0, 0
 
-	curBuf.startY, curBuf.startX, curBuf.endY, curBuf.endX = 0, 0, 0, 0
+	// the cursor can be at either end of the selection.
+	v.gotoLine(lowerY)
+	v.x = lowerX
 
-	for i := 0; i < higherY-lowerY; i++ {
-		curBuf.decrY()
+	log.Printf("cutText: removed selected text")
+}
+
+func (e *editor) cutBlock() {
+	v := e.curView()
+	curBuf := v.buf
+
+	lowerY, higherY, leftCol, rightCol := v.getBlockSelection()
+
+	for y := lowerY; y <= higherY; y++ {
+		line := curBuf.line(y)
+		v.deleteText(y, columnIndex(line, leftCol), y, columnIndex(line, rightCol))
 	}
 
-	curBuf.x = newX
+	v.startY, v.startX, v.endY, v.endX = 0, 0, 0, 0
 
-	curBuf.modified = true
+	v.gotoLine(lowerY)
+	v.x = columnIndex(v.curLine(), leftCol)
 
-	log.Printf("cutText: removed selected text")
+	log.Printf("cutBlock: removed columns %d-%d of lines %d-%d", leftCol, rightCol, lowerY, higherY)
 }
 
 func (e *editor) pasteText() {
-	insertion := [][]rune{}
-	insertion = append(insertion, e.clipboard...)
+	if e.clipboardBlock {
+		e.pasteBlock()
+		return
+	}
+
+	if len(e.clipboard) == 0 {
+		log.Printf("pasteText: clipboard is empty")
+		return
+	}
 
 	log.Printf("pasteText: inserting data from clipboard")
-	for idx, line := range insertion {
+	for idx, line := range e.clipboard {
 		log.Printf("pasteText: clipboard %d = %s", idx, string(line))
 	}
 
-	curBuf := e.bufs[e.bufIdx]
-	curY := curBuf.y + curBuf.offset
+	v := e.curView()
 
-	lastLineX := len(insertion[len(insertion)-1])
+	v.insertText(v.curLineIdx(), v.x, e.clipboard)
 
-	beforeInsertion, afterInsertion := curBuf.lines[curY][:curBuf.x], curBuf.lines[curY][curBuf.x:]
-
-	insertion[0] = append(append([]rune{}, beforeInsertion...), insertion[0]...)
-	insertion[len(insertion)-1] = append(insertion[len(insertion)-1], afterInsertion...)
-
-	for idx, line := range insertion {
-		log.Printf("pasteText: insertion %d = %s", idx, string(line))
+	for i := 0; i < len(e.clipboard)-1; i++ {
+		v.incrY()
+	}
+	if len(e.clipboard) == 1 {
+		v.x += len(e.clipboard[0])
+	} else {
+		v.x = len(e.clipboard[len(e.clipboard)-1])
 	}
+}
 
-	curBuf.lines = append(curBuf.lines[:curY], append(insertion, curBuf.lines[curY+1:]...)...)
+// pasteBlock inserts the lines of a block from the clipboard at the cursor's
+// display column on the current and following lines, padding short lines
+// with spaces and appending lines at the end of the buffer as needed.
+func (e *editor) pasteBlock() {
+	v := e.curView()
+	curBuf := v.buf
+	curY := v.curLineIdx()
+	col := runeWidth(v.curLine()[:v.x])
+
+	log.Printf("pasteBlock: inserting %d lines at line %d column %d", len(e.clipboard), curY, col)
+
+	for idx, text := range e.clipboard {
+		y := curY + idx
+		if y >= curBuf.lineCount() {
+			v.insertText(y-1, curBuf.lineLen(y-1), [][]rune{{}, {}})
+		}
 
-	_, height := e.scr.Size()
+		line := curBuf.line(y)
+		if w := runeWidth(line); w < col {
+			v.insertText(y, len(line), [][]rune{[]rune(strings.Repeat(" ", col-w))})
+			line = curBuf.line(y)
+		}
 
-	for i := 0; i < len(insertion)-1; i++ {
-		curBuf.incrY(height)
+		v.insertText(y, columnIndex(line, col), [][]rune{text})
 	}
-	curBuf.x = lastLineX
-
-	curBuf.modified = true
 }
 
 func (e *editor) pageDown() {
-	_, height := e.scr.Size()
-
-	curBuf := e.bufs[e.bufIdx]
+	v := e.curView()
+	curBuf := v.buf
 
-	for i := 0; i < height-2; i++ {
-		if curBuf.curLineIdx() == len(curBuf.lines)-1 {
+	for i := 0; i < v.height; i++ {
+		if v.curLineIdx() == curBuf.lineCount()-1 {
 			break
 		}
-		curBuf.incrY(height)
+		v.incrY()
 	}
 
-	curBuf.correctX()
+	v.correctX()
 
-	log.Printf("pageDown: new line %d x = %d", curBuf.curLineIdx(), curBuf.x)
+	log.Printf("pageDown: new line %d x = %d", v.curLineIdx(), v.x)
 }
 
 func (e *editor) pageUp() {
-	_, height := e.scr.Size()
-
-	curBuf := e.bufs[e.bufIdx]
+	v := e.curView()
 
-	for i := 0; i < height-2; i++ {
-		if curBuf.curLineIdx() == 0 {
+	for i := 0; i < v.height; i++ {
+		if v.curLineIdx() == 0 {
 			break
 		}
-		curBuf.decrY()
+		v.decrY()
 	}
 
-	curBuf.correctX()
+	v.correctX()
 
-	log.Printf("pageUp: new line %d x = %d", curBuf.curLineIdx(), curBuf.x)
+	log.Printf("pageUp: new line %d x = %d", v.curLineIdx(), v.x)
 
 }
 
 func (e *editor) undo() {
-	curBuf := e.bufs[e.bufIdx]
+	v := e.curView()
+	curBuf := v.buf
 
-	if curBuf.historyIdx < 0 {
+	cs, ok := curBuf.history.undo(v)
+	if !ok {
 		log.Printf("undo: nothing to undo")
 		e.showError("Already at oldest change")
 		return
 	}
 
-	op := curBuf.editHistory[curBuf.historyIdx]
-	op.finished = true
-
-	log.Printf("undo: op = %d y = %d x = %d", op.op, op.y, op.x)
-	for idx, line := range op.text {
-		log.Printf("undo: line %d: %s", idx, string(line))
-	}
+	log.Printf("undo: went back to state %d", curBuf.history.cur.seq)
 
-	op.undo(curBuf)
-	curBuf.historyIdx--
-
-	curBuf.correctY()
-	curBuf.correctX()
+	e.restoreUndoCursor(v, cs)
 }
 
 func (e *editor) redo() {
-	curBuf := e.bufs[e.bufIdx]
+	v := e.curView()
+	curBuf := v.buf
 
-	if curBuf.historyIdx == len(curBuf.editHistory)-1 {
+	cs, ok := curBuf.history.redo(v)
+	if !ok {
 		log.Printf("redo: nothing to redo")
 		e.showError("Already at newest change")
 		return
 	}
 
-	curBuf.historyIdx++
+	log.Printf("redo: went forward to state %d", curBuf.history.cur.seq)
+
+	e.restoreUndoCursor(v, cs)
+}
+
+// gotoUndoState changes the text of buf to the state of node in its undo tree.
+func (e *editor) gotoUndoState(v *view, node *undoNode) {
+	if node == v.buf.history.cur {
+		return
+	}
+
+	e.restoreUndoCursor(v, v.buf.history.gotoNode(v, node))
+}
+
+// restoreUndoCursor moves the cursor of buf to where it was at the state that
+// undo or redo went to, and updates whether buf has unsaved changes.
+func (e *editor) restoreUndoCursor(v *view, cs cursorState) {
+	v.setCursorState(cs)
+
+	v.buf.modified = v.buf.history.cur != v.buf.savedState
+	if !v.buf.modified {
+		v.buf.clearMarks(markModified)
+	}
+}
+
+func (e *editor) undoOlder() {
+	v := e.curView()
+	curBuf := v.buf
+
+	var target *undoNode
+	for _, node := range curBuf.history.nodes() {
+		if node.seq < curBuf.history.cur.seq {
+			target = node
+		}
+	}
+
+	if target == nil {
+		e.showError("Already at oldest state")
+		return
+	}
+
+	e.gotoUndoState(v, target)
+	e.showError("State %d of %d", target.seq, curBuf.history.seq)
+}
+
+func (e *editor) undoNewer() {
+	v := e.curView()
+	curBuf := v.buf
+
+	var target *undoNode
+	for _, node := range curBuf.history.nodes() {
+		if node.seq > curBuf.history.cur.seq {
+			target = node
+			break
+		}
+	}
+
+	if target == nil {
+		e.showError("Already at newest state")
+		return
+	}
+
+	e.gotoUndoState(v, target)
+	e.showError("State %d of %d", target.seq, curBuf.history.seq)
+}
+
+func (e *editor) switchBranch() {
+	v := e.curView()
+	curBuf := v.buf
+	cur := curBuf.history.cur
 
-	op := curBuf.editHistory[curBuf.historyIdx]
-	log.Printf("redo: op = %d y = %d x = %d finished = %t", op.op, op.y, op.x, op.finished)
-	for idx, line := range op.text {
-		log.Printf("redo: line %d: %s", idx, string(line))
+	if len(cur.children) > 1 {
+		cur.redoIdx = (cur.redoIdx + 1) % len(cur.children)
+		log.Printf("switchBranch: redo follows branch %d of state %d", cur.redoIdx, cur.seq)
+		e.showError("Redo follows branch %d of %d", cur.redoIdx+1, len(cur.children))
+		return
+	}
+
+	if cur.parent == nil || len(cur.parent.children) < 2 {
+		e.showError("No other branches")
+		return
 	}
 
-	op.redo(curBuf)
+	siblings := cur.parent.children
+	idx := (cur.parent.childIndex(cur) + 1) % len(siblings)
 
-	curBuf.correctX()
+	e.gotoUndoState(v, siblings[idx])
+	e.showError("Branch %d of %d", idx+1, len(siblings))
+}
+
+func (e *editor) timeTravel() {
+	v := e.curView()
+	curBuf := v.buf
+
+	input, ok := e.readString("Go back in time by (e.g. 10m, 1h30m, -5m goes forward)", nil)
+	if !ok {
+		log.Printf("timeTravel: cancelled")
+		return
+	}
+
+	d, err := time.ParseDuration(input)
+	if err != nil {
+		e.showError("Invalid duration: %v", err)
+		return
+	}
+
+	target := curBuf.history.nodeAt(curBuf.history.cur.time.Add(-d))
+
+	log.Printf("timeTravel: going back by %v from state %d to state %d", d, curBuf.history.cur.seq, target.seq)
+
+	e.gotoUndoState(v, target)
+	e.showError("State %d from %s", target.seq, target.time.Format("2006-01-02 15:04:05"))
+}
+
+func (e *editor) browseUndoTree() {
+	v := e.curView()
+	curBuf := v.buf
+	tree := curBuf.history
+	start := tree.cur
+
+	lines := tree.lines()
+
+	sel, top := 0, 0
+	for idx, line := range lines {
+		if line.node == tree.cur {
+			sel = idx
+		}
+	}
+
+	titleText := "Undo Tree - Up/Down: preview state, Enter: go to state, Esc: cancel"
+
+	for {
+		width, height := e.scr.Size()
+		listHeight := (height - 2) / 2
+
+		if sel < top {
+			top = sel
+		}
+		if sel >= top+listHeight {
+			top = sel - listHeight + 1
+		}
+
+		titleStyle := e.theme.style(slotTitle)
+		e.clearLine(0, width, titleStyle)
+		x := 0
+		for _, r := range titleText {
+			e.scr.SetContent(x, 0, r, nil, titleStyle)
+			x += runewidth.RuneWidth(r)
+		}
+
+		lines = tree.lines()
+		for row := 0; row < listHeight; row++ {
+			style := e.theme.style(slotText)
+			e.clearLine(row+1, width, style)
+			if top+row >= len(lines) {
+				continue
+			}
+			if top+row == sel {
+				style = e.theme.apply(slotSelection, style)
+			}
+			x := 0
+			for _, r := range lines[top+row].text {
+				e.scr.SetContent(x, row+1, r, nil, style)
+				x += runewidth.RuneWidth(r)
+			}
+		}
+
+		// preview the text around the change of the selected state.
+		previewY := 0
+		if op := lines[sel].node.op; op != nil {
+			for op.op == opGroup && len(op.children) > 0 {
+				op = op.children[0]
+			}
+			previewY = op.y
+		}
+
+		e.clearLine(listHeight+1, width, titleStyle)
+
+		previewHeight := height - listHeight - 2
+		previewTop := previewY - previewHeight/2
+		if previewTop < 0 {
+			previewTop = 0
+		}
+		for row := 0; row < previewHeight; row++ {
+			e.drawLine(v, listHeight+2+row, 0, previewTop+row, width, previewTop+row == previewY)
+		}
+
+		e.scr.HideCursor()
+		e.scr.Show()
+
+		ev, ok := e.pollEvent().(*tcell.EventKey)
+		if !ok {
+			continue
+		}
+
+		switch ev.Key() {
+		case tcell.KeyUp:
+			if sel > 0 {
+				sel--
+			}
+		case tcell.KeyDown:
+			if sel < len(lines)-1 {
+				sel++
+			}
+		case tcell.KeyPgUp:
+			if sel -= listHeight; sel < 0 {
+				sel = 0
+			}
+		case tcell.KeyPgDn:
+			if sel += listHeight; sel >= len(lines) {
+				sel = len(lines) - 1
+			}
+		case tcell.KeyEnter:
+			log.Printf("browseUndoTree: went to state %d", tree.cur.seq)
+			e.showError("State %d of %d", tree.cur.seq, tree.seq)
+			return
+		case tcell.KeyESC, tcell.KeyCtrlG:
+			log.Printf("browseUndoTree: cancelled, returning to state %d", start.seq)
+			e.gotoUndoState(v, start)
+			return
+		default:
+			continue
+		}
+
+		e.gotoUndoState(v, lines[sel].node)
+	}
 }
 
 func (e *editor) showHelp() {
@@ -471,13 +780,15 @@ func (e *editor) showHelp() {
 
 	width, _ := e.scr.Size()
 
-	e.clearLine(0, width, tcell.StyleDefault.Reverse(true))
+	titleStyle := e.theme.style(slotTitle)
+
+	e.clearLine(0, width, titleStyle)
 
 	titleText := "Help - Press Any Key To Continue"
 
 	x := 0
 	for _, r := range titleText {
-		e.scr.SetContent(x, 0, r, nil, tcell.StyleDefault.Reverse(true))
+		e.scr.SetContent(x, 0, r, nil, titleStyle)
 		x += runewidth.RuneWidth(r)
 	}
 
@@ -485,8 +796,12 @@ func (e *editor) showHelp() {
 
 	keyWidth := 16
 	for _, op := range e.ops {
-		helpElem := tcell.KeyNames[op.Key] + " "
-		helpElem += strings.Repeat(".", keyWidth-len(helpElem)) + " " + op.Desc
+		helpElem := op.Keys.String() + " "
+		dots := keyWidth - len(helpElem)
+		if dots < 1 {
+			dots = 1
+		}
+		helpElem += strings.Repeat(".", dots) + " " + op.Desc
 		helpElems = append(helpElems, helpElem)
 	}
 
@@ -496,7 +811,7 @@ func (e *editor) showHelp() {
 		x := widths[i%len(widths)]
 		y := 1 + i/2
 		for _, r := range helpElems[i] {
-			e.scr.SetContent(x, y, r, nil, tcell.StyleDefault)
+			e.scr.SetContent(x, y, r, nil, e.theme.style(slotText))
 			x += runewidth.RuneWidth(r)
 		}
 	}
@@ -504,7 +819,7 @@ func (e *editor) showHelp() {
 	e.scr.Show()
 
 	for {
-		evt := e.scr.PollEvent()
+		evt := e.pollEvent()
 		// wait for next key event, discard it.
 		if _, ok := evt.(*tcell.EventKey); ok {
 			return
@@ -558,28 +873,84 @@ func (e *editor) closeBuffer() {
 
 	log.Printf("closeBuffer: closed buffer at index %d", e.bufIdx)
 
+	e.removeSwapFile(curBuf)
+
+	if curBuf.stopSearch != nil {
+		curBuf.stopSearch()
+		curBuf.stopSearch = nil
+	}
+
 	e.bufs = append(e.bufs[:e.bufIdx], e.bufs[e.bufIdx+1:]...)
 	if e.bufIdx >= len(e.bufs) {
 		e.bufIdx = len(e.bufs) - 1
 	}
 }
 
+// findPrompt returns the prompt of the find commands, which shows the search
+// options and how to change them.
+func findPrompt(prompt string, opts searchOptions) string {
+	return fmt.Sprintf("%s [%s] (Alt-C/W/B: case, word, direction)", prompt, opts.describe())
+}
+
+// readFindPhrase asks for a search phrase, starting with phrase. The search
+// options in opts can be changed while typing.
+func (e *editor) readFindPhrase(prompt string, phrase []rune, opts *searchOptions) (string, bool) {
+	update := func(ev *tcell.EventKey, input []rune) string {
+		if ev != nil && ev.Key() == tcell.KeyRune && ev.Modifiers()&tcell.ModAlt != 0 {
+			opts.toggle(ev.Rune())
+		}
+		return findPrompt(prompt, *opts)
+	}
+
+	return e.readStringFunc(prompt, phrase, update)
+}
+
 func (e *editor) find() {
-	curBuf := e.bufs[e.bufIdx]
+	v := e.curView()
+	curBuf := v.buf
 
-	_, height := e.scr.Size()
+	opts := curBuf.findOptions
 
-	findPhrase, ok := e.readString("Find", curBuf.findPhrase)
+	findPhrase, ok := e.readFindPhrase("Find", curBuf.findPhrase, &opts)
 	if !ok {
 		log.Printf("find: entering search phrase cancelled")
 		return
 	}
 
-	log.Printf("find: searching for phrase %q", findPhrase)
+	curBuf.findPhrase, curBuf.findOptions = []rune(findPhrase), opts
 
-	curBuf.findPhrase = []rune(findPhrase)
+	e.findAgain(v, opts)
+}
+
+// findNext repeats the last search of the current buffer in its direction.
+func (e *editor) findNext() {
+	v := e.curView()
+	curBuf := v.buf
+	e.findAgain(v, curBuf.findOptions)
+}
+
+// findPrevious repeats the last search of the current buffer in the opposite
+// direction.
+func (e *editor) findPrevious() {
+	v := e.curView()
+	curBuf := v.buf
+	opts := curBuf.findOptions
+	opts.backward = !opts.backward
+	e.findAgain(v, opts)
+}
+
+// findAgain moves the cursor of buf to the next occurrence of its find phrase.
+func (e *editor) findAgain(v *view, opts searchOptions) {
+	if len(v.buf.findPhrase) == 0 {
+		e.showError("No previous search")
+		return
+	}
+
+	findPhrase := string(v.buf.findPhrase)
 
-	y, x, found := curBuf.find([]rune(findPhrase))
+	log.Printf("find: searching for phrase %q (%s)", findPhrase, opts.describe())
+
+	y, x, found := v.find(v.buf.findPhrase, opts)
 	if !found {
 		log.Printf("find: phrase %q not found", findPhrase)
 		e.showError("Text not found")
@@ -588,16 +959,229 @@ func (e *editor) find() {
 
 	log.Printf("find: found phrase %q at line %d col %d", findPhrase, y, x)
 
-	curBuf.x = x
-	for y > curBuf.curLineIdx() {
-		curBuf.incrY(height)
+	if opts.backward && (y > v.curLineIdx() || (y == v.curLineIdx() && x >= v.x)) {
+		e.showError("Search wrapped around to the end")
+	} else if !opts.backward && (y < v.curLineIdx() || (y == v.curLineIdx() && x <= v.x)) {
+		e.showError("Search wrapped around to the start")
+	}
+
+	v.x = x
+	v.gotoLine(y)
+}
+
+// incrementalFind searches while the phrase is typed: the cursor moves to the
+// next match at or after it, and all matches are highlighted. Ctrl-S and
+// Ctrl-R go to the next and previous match and set the direction of later
+// searches, Enter keeps the cursor at the current match, and Esc moves it
+// back to where the search started. The search options can be changed like
+// in find.
+func (e *editor) incrementalFind() {
+	v := e.curView()
+	curBuf := v.buf
+
+	startX, startY, startOffset := v.x, v.y, v.offset
+	startOpts := curBuf.findOptions
+
+	defer func() {
+		curBuf.highlightPhrase = nil
+	}()
+
+	update := func(ev *tcell.EventKey, input []rune) string {
+		phrase := append([]rune{}, input...)
+		curBuf.highlightPhrase = phrase
+
+		opts := &curBuf.findOptions
+		y, x, found := v.curLineIdx(), v.x, true
+
+		switch {
+		case ev != nil && ev.Key() == tcell.KeyCtrlS:
+			opts.backward = false
+			y, x, found = curBuf.findForward(phrase, *opts, y, x+1)
+		case ev != nil && ev.Key() == tcell.KeyCtrlR:
+			opts.backward = true
+			y, x, found = curBuf.findBackward(phrase, *opts, y, x)
+		case ev != nil && ev.Key() == tcell.KeyRune && ev.Modifiers()&tcell.ModAlt != 0 && !opts.toggle(ev.Rune()):
+			// not an option, nothing changed.
+		case opts.backward:
+			y, x, found = curBuf.findBackward(phrase, *opts, y, x+1)
+		default:
+			y, x, found = curBuf.findForward(phrase, *opts, y, x)
+		}
+
+		prompt := "Search"
+
+		if found {
+			log.Printf("incrementalFind: found phrase %q at line %d col %d", string(phrase), y, x)
+			v.x = x
+			v.gotoLine(y)
+		} else if len(phrase) > 0 {
+			log.Printf("incrementalFind: phrase %q not found", string(phrase))
+			prompt = "Failing search"
+		}
+
+		e.redrawScreen()
+
+		return findPrompt(prompt+" (C-s/C-r: next/previous)", *opts)
+	}
+
+	findPhrase, ok := e.readStringFunc("Search", curBuf.findPhrase, update)
+	if !ok {
+		log.Printf("incrementalFind: cancelled, returning to line %d col %d", startOffset+startY, startX)
+		v.x, v.y, v.offset = startX, startY, startOffset
+		curBuf.findOptions = startOpts
+		return
+	}
+
+	curBuf.findPhrase = []rune(findPhrase)
+}
+
+func (e *editor) replace() {
+	v := e.curView()
+	curBuf := v.buf
+
+	pattern, ok := e.readString("Replace (regexp)", curBuf.replacePattern)
+	if !ok {
+		log.Printf("replace: entering pattern cancelled")
+		return
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Printf("replace: compiling pattern %q failed: %v", pattern, err)
+		e.showError("Invalid regular expression: %v", err)
+		return
+	}
+
+	curBuf.replacePattern = []rune(pattern)
+
+	replacement, ok := e.readString("Replace with", curBuf.replaceText)
+	if !ok {
+		log.Printf("replace: entering replacement cancelled")
+		return
+	}
+
+	curBuf.replaceText = []rune(replacement)
+
+	// without a selection, everything from the cursor to the end of the buffer is searched.
+	lowerY, lowerX := v.curLineIdx(), v.x
+	higherY, higherX := curBuf.lineCount()-1, curBuf.lineLen(curBuf.lineCount()-1)
+	leftCol, rightCol := 0, 0
+	blockMode := v.selecting && v.blockMode
+	if v.selecting {
+		if blockMode {
+			lowerY, higherY, leftCol, rightCol = v.getBlockSelection()
+		} else {
+			lowerY, lowerX, higherY, higherX = v.getSelection()
+		}
+		v.selecting = false
+	}
+
+	log.Printf("replace: replacing %q with %q from %d/%d to %d/%d", pattern, replacement, lowerY, lowerX, higherY, higherX)
+
+	replaceAll := false
+	count := 0
+
+lineLoop:
+	for y := lowerY; y <= higherY; y++ {
+		line := curBuf.line(y)
+		lineStr := string(line)
+
+		// the part of the line in which matches are replaced.
+		fromX, toX := 0, len(line)
+		switch {
+		case blockMode:
+			fromX, toX = columnIndex(line, leftCol), columnIndex(line, rightCol)
+		case y == lowerY && y == higherY:
+			fromX, toX = lowerX, higherX
+		case y == lowerY:
+			fromX = lowerX
+		case y == higherY:
+			toX = higherX
+		}
+
+		// replacing a match shifts all following matches on the same line by
+		// the difference in length between the matched text and its replacement.
+		delta := 0
+
+		for _, match := range re.FindAllStringSubmatchIndex(lineStr, -1) {
+			start := utf8.RuneCountInString(lineStr[:match[0]])
+			end := start + utf8.RuneCountInString(lineStr[match[0]:match[1]])
+
+			if start < fromX {
+				continue
+			}
+			if end > toX {
+				break
+			}
+
+			if !replaceAll {
+				v.x = start + delta
+				v.gotoLine(y)
+				// highlight the match while asking for confirmation.
+				v.startY, v.startX, v.endY, v.endX = y, start+delta, y, end+delta
+				e.redrawScreen()
+
+				switch e.query("Replace?", "ynaq") {
+				case 'n':
+					continue
+				case 'a':
+					replaceAll = true
+				case 'q':
+					break lineLoop
+				}
+			}
+
+			newText := []rune(string(re.ExpandString(nil, replacement, lineStr, match)))
+
+			v.deleteText(y, start+delta, y, end+delta)
+			v.insertText(y, start+delta, [][]rune{newText})
+
+			delta += len(newText) - (end - start)
+			count++
+
+			v.x = start + delta + (end - start)
+			v.gotoLine(y)
+		}
 	}
-	for y < curBuf.curLineIdx() {
-		curBuf.decrY()
+
+	v.startY, v.startX, v.endY, v.endX = 0, 0, 0, 0
+
+	v.correctX()
+
+	if count == 0 {
+		log.Printf("replace: no occurrences replaced")
+		e.showError("No occurrences replaced")
+		return
 	}
+
+	log.Printf("replace: replaced %d occurrences", count)
+	e.showError("Replaced %d occurrence(s)", count)
 }
 
 func (e *editor) redraw() {
 	log.Printf("redraw: syncing whole screen")
 	e.scr.Sync()
 }
+
+func (e *editor) convertLineEndings() {
+	curBuf := e.curView().buf
+
+	if curBuf.refuseEdit() {
+		return
+	}
+
+	curBuf.crlf = !curBuf.crlf
+	curBuf.modified = true
+	// the line endings aren't part of the undo history, so no state matches
+	// the saved file anymore.
+	curBuf.savedState = nil
+	curBuf.changes++
+
+	lineEnding := "LF"
+	if curBuf.crlf {
+		lineEnding = "CRLF"
+	}
+
+	log.Printf("convertLineEndings: converted buffer %d to %s", e.bufIdx, lineEnding)
+	e.showError("Line endings converted to %s", lineEnding)
+}
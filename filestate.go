@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// fileState describes a file as it was when it was last loaded or saved, so
+// that changes made by other programs can be detected.
+type fileState struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+}
+
+// newFileState returns the state of the file fname with content data.
+func newFileState(fname string, data []byte) fileState {
+	return newFileStateFromHash(fname, sha256.Sum256(data))
+}
+
+func newFileStateFromHash(fname string, hash [sha256.Size]byte) fileState {
+	fi, err := os.Stat(fname)
+	if err != nil {
+		log.Printf("newFileState: stat %s failed: %v", fname, err)
+		return fileState{}
+	}
+	return fileState{exists: true, modTime: fi.ModTime(), size: fi.Size(), hash: hash}
+}
+
+// changedOnDisk checks whether another program changed the file of buf since
+// it was last loaded or saved, and returns the new content if it did. Files
+// that were only touched are not considered changed. Files that were deleted
+// aren't reported either, as saving the buffer simply creates them again.
+func (buf *buffer) changedOnDisk() (data []byte, changed bool, err error) {
+	if buf.fname == "" {
+		return nil, false, nil
+	}
+
+	fi, err := os.Stat(buf.fname)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	if buf.disk.exists && fi.ModTime().Equal(buf.disk.modTime) && fi.Size() == buf.disk.size {
+		return nil, false, nil
+	}
+
+	data, err = os.ReadFile(buf.fname)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if hash := sha256.Sum256(data); buf.disk.exists && hash == buf.disk.hash {
+		log.Printf("changedOnDisk: %s was touched, but its content is unchanged", buf.fname)
+		buf.disk.modTime, buf.disk.size = fi.ModTime(), fi.Size()
+		return nil, false, nil
+	}
+
+	log.Printf("changedOnDisk: %s was changed on disk", buf.fname)
+
+	return data, true, nil
+}
+
+// checkFilesChanged checks whether the files of any of the buffers were
+// changed by other programs. Buffers without unsaved changes are reloaded
+// right away, otherwise the user is asked what to do.
+func (e *editor) checkFilesChanged() {
+	bufIdx := e.bufIdx
+	defer func() {
+		e.bufIdx = bufIdx
+	}()
+
+	for idx, buf := range e.bufs {
+		data, changed, err := buf.changedOnDisk()
+		if err != nil {
+			log.Printf("checkFilesChanged: checking %s failed: %v", buf.fname, err)
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		if !buf.modified {
+			e.reloadBuffer(buf, data)
+			e.showError("Reloaded %s, it was changed on disk", buf.fname)
+			continue
+		}
+
+		e.bufIdx = idx
+		if e.resolveFileChange(buf, data, false) {
+			e.saveFile(buf)
+		}
+	}
+}
+
+// resolveFileChange asks whether to reload the buffer, to overwrite the file
+// that was changed on disk, or to show the differences first. It returns true
+// if the buffer should be saved. When saving, the alternative is cancelling
+// the save, otherwise the user can keep editing and isn't asked again until
+// the file changes again.
+func (e *editor) resolveFileChange(buf *buffer, data []byte, saving bool) bool {
+	bufIdx := e.bufIdx
+	defer func() {
+		e.bufIdx = bufIdx
+	}()
+
+	other, answers := "(k)eep editing", "rodk"
+	if saving {
+		other, answers = "(c)ancel", "rodc"
+	}
+	prompt := fmt.Sprintf("%s was changed on disk: (r)eload, (o)verwrite, show (d)iff or %s?", buf.fname, other)
+
+	for {
+		e.redrawScreen()
+
+		switch e.query(prompt, answers) {
+		case 'r':
+			e.reloadBuffer(buf, data)
+			e.showError("Reloaded %s", buf.fname)
+			return false
+		case 'o':
+			log.Printf("resolveFileChange: overwriting %s", buf.fname)
+			return true
+		case 'd':
+			// data is still needed if the file is reloaded afterwards.
+			onDisk := newBufferFromFileContent(append([]byte(nil), data...))
+			diff := unifiedDiff(buf.fname+" (buffer)", buf.fname+" (disk)", runeLines(buf.lines()), runeLines(onDisk.lines()))
+			if diff == "" {
+				prompt = fmt.Sprintf("%s was changed on disk, but the text is the same: (r)eload, (o)verwrite, show (d)iff or %s?", buf.fname, other)
+				continue
+			}
+			log.Printf("resolveFileChange: showing differences for %s", buf.fname)
+			e.addTextBuffer(diff)
+		case 'k':
+			log.Printf("resolveFileChange: keeping buffer of %s", buf.fname)
+			buf.disk = newFileState(buf.fname, data)
+			return false
+		case 'c':
+			log.Printf("resolveFileChange: cancelled saving %s", buf.fname)
+			e.showError("Cancelled")
+			return false
+		}
+	}
+}
+
+// reloadBuffer replaces the content of buf with data, the new content of its
+// file.
+func (e *editor) reloadBuffer(buf *buffer, data []byte) {
+	log.Printf("reloadBuffer: reloading %s", buf.fname)
+
+	buf.disk = newFileState(buf.fname, data)
+	buf.replaceContent(newBufferFromFileContent(data))
+	buf.modified = false
+
+	e.removeSwapFile(buf)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFileLater writes data to fname and sets a modification time that
+// differs from the previous one, even on file systems with coarse timestamps.
+func writeFileLater(t *testing.T, fname string, data string) {
+	t.Helper()
+
+	fi, err := os.Stat(fname)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(fname, []byte(data), 0644))
+
+	modTime := fi.ModTime().Add(time.Minute)
+	require.NoError(t, os.Chtimes(fname, modTime, modTime))
+}
+
+func TestChangedOnDisk(t *testing.T) {
+	ed := newTestEditor(t)
+
+	fname := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(fname, []byte("hello\n"), 0644))
+
+	require.NoError(t, ed.loadBufferFromFile(fname))
+	buf := ed.bufs[0]
+
+	_, changed, err := buf.changedOnDisk()
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	writeFileLater(t, fname, "hello\n")
+
+	_, changed, err = buf.changedOnDisk()
+	require.NoError(t, err)
+	require.False(t, changed, "touching a file doesn't change it")
+
+	writeFileLater(t, fname, "world\n")
+
+	data, changed, err := buf.changedOnDisk()
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, "world\n", string(data))
+
+	ed.saveFile(buf)
+
+	_, changed, err = buf.changedOnDisk()
+	require.NoError(t, err)
+	require.False(t, changed, "saving records the new state")
+
+	require.NoError(t, os.Remove(fname))
+
+	_, changed, err = buf.changedOnDisk()
+	require.NoError(t, err)
+	require.False(t, changed, "deleted files aren't reported")
+}
+
+func TestCheckFilesChanged(t *testing.T) {
+	ed := newTestEditor(t)
+
+	dir := t.TempDir()
+	unmodified, modified := filepath.Join(dir, "unmodified.txt"), filepath.Join(dir, "modified.txt")
+	require.NoError(t, os.WriteFile(unmodified, []byte("a\n"), 0644))
+	require.NoError(t, os.WriteFile(modified, []byte("b\n"), 0644))
+
+	require.NoError(t, ed.loadBufferFromFile(unmodified))
+	require.NoError(t, ed.loadBufferFromFile(modified))
+
+	ed.bufIdx = 1
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'x', 0))
+
+	writeFileLater(t, unmodified, "a2\n")
+	writeFileLater(t, modified, "b2\n")
+
+	require.NoError(t, ed.scr.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'k', 0)))
+	ed.checkFilesChanged()
+
+	require.Equal(t, 1, ed.bufIdx)
+	require.Equal(t, [][]rune{[]rune("a2")}, ed.bufs[0].lines(), "unmodified buffers are reloaded")
+	require.False(t, ed.bufs[0].modified)
+	require.Equal(t, [][]rune{[]rune("xb")}, ed.bufs[1].lines(), "modified buffers are kept")
+	require.True(t, ed.bufs[1].modified)
+
+	// after keeping the buffer, saving doesn't ask again.
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlS, 0, 0))
+
+	data, err := os.ReadFile(modified)
+	require.NoError(t, err)
+	require.Equal(t, "xb\n", string(data))
+}
+
+func TestSaveChangedFile(t *testing.T) {
+	testData := map[string]struct {
+		answers          []rune
+		expectedFile     string
+		expectedLines    [][]rune
+		expectedModified bool
+		expectedBufs     int
+	}{
+		"overwrite": {
+			answers:       []rune{'o'},
+			expectedFile:  "xold\n",
+			expectedLines: [][]rune{[]rune("xold")},
+			expectedBufs:  1,
+		},
+		"reload": {
+			answers:       []rune{'r'},
+			expectedFile:  "new\n",
+			expectedLines: [][]rune{[]rune("new")},
+			expectedBufs:  1,
+		},
+		"diff then cancel": {
+			answers:          []rune{'d', 'c'},
+			expectedFile:     "new\n",
+			expectedLines:    [][]rune{[]rune("xold")},
+			expectedModified: true,
+			expectedBufs:     2,
+		},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			ed := newTestEditor(t)
+
+			fname := filepath.Join(t.TempDir(), "file.txt")
+			require.NoError(t, os.WriteFile(fname, []byte("old\n"), 0644))
+
+			require.NoError(t, ed.loadBufferFromFile(fname))
+
+			playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'x', 0))
+
+			writeFileLater(t, fname, "new\n")
+
+			require.NoError(t, ed.scr.PostEvent(tcell.NewEventKey(tcell.KeyCtrlS, 0, 0)))
+			for _, r := range tt.answers {
+				require.NoError(t, ed.scr.PostEvent(tcell.NewEventKey(tcell.KeyRune, r, 0)))
+			}
+			ed.handleEvent()
+
+			data, err := os.ReadFile(fname)
+			require.NoError(t, err)
+			require.Equal(t, tt.expectedFile, string(data))
+
+			require.Equal(t, 0, ed.bufIdx)
+			require.Len(t, ed.bufs, tt.expectedBufs)
+			require.Equal(t, tt.expectedLines, ed.bufs[0].lines())
+			require.Equal(t, tt.expectedModified, ed.bufs[0].modified)
+			require.Equal(t, tt.expectedModified, ed.bufs[0].hasMark(0, markModified), "only changed lines are marked as modified")
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"unicode/utf8"
+)
+
+// bufferName returns the file name of buf, or what kind of buffer it is and
+// its number if it has no file.
+func (e *editor) bufferName(buf *buffer) string {
+	if buf.fname != "" {
+		return buf.fname
+	}
+	kind := "<no file>"
+	if buf.results != nil {
+		kind = "<results>"
+	}
+	if idx := e.bufferIndex(buf); idx >= 0 {
+		return fmt.Sprintf("%s (buffer %d)", kind, idx+1)
+	}
+	return kind
+}
+
+// searchableBuffers returns all buffers except for results buffers.
+func (e *editor) searchableBuffers() []*buffer {
+	var bufs []*buffer
+	for _, buf := range e.bufs {
+		if buf.results == nil {
+			bufs = append(bufs, buf)
+		}
+	}
+	return bufs
+}
+
+// findInBuffers lists all occurrences of a phrase in all buffers in a results
+// buffer, grouped by buffer.
+func (e *editor) findInBuffers() {
+	curBuf := e.curView().buf
+
+	opts := curBuf.findOptions
+	opts.backward = false
+
+	findPhrase, ok := e.readFindPhrase("Find in all buffers", curBuf.findPhrase, &opts)
+	if !ok {
+		log.Printf("findInBuffers: entering search phrase cancelled")
+		return
+	}
+
+	phrase := []rune(findPhrase)
+
+	var results *buffer
+	count, bufCount := 0, 0
+
+	for _, buf := range e.searchableBuffers() {
+		found := false
+		for y := 0; y < buf.lineCount(); y++ {
+			line := buf.line(y)
+			for _, x := range opts.indexAll(line, phrase) {
+				if results == nil {
+					results = e.addResultsBuffer(fmt.Sprintf("Occurrences of %q (%s):", findPhrase, opts.describe()))
+				}
+				if !found {
+					results.addResult("", nil)
+					results.addResult(e.bufferName(buf), nil)
+					found = true
+					bufCount++
+				}
+				results.addResult(fmt.Sprintf("  %d:%d: %s", y+1, x+1, string(line)), &resultLoc{buf: buf, fname: buf.fname, y: y, x: x})
+				count++
+			}
+		}
+	}
+
+	if count == 0 {
+		log.Printf("findInBuffers: phrase %q not found", findPhrase)
+		e.showError("Text not found")
+		return
+	}
+
+	curBuf.findPhrase = phrase
+
+	log.Printf("findInBuffers: found %d occurrences of %q in %d buffers", count, findPhrase, bufCount)
+	e.showError("Found %d occurrence(s) in %d buffer(s), Enter goes to an occurrence", count, bufCount)
+}
+
+// regexpMatch is a match of a regular expression in a line, with the rune
+// indexes of its start and end, and the text that replaces it.
+type regexpMatch struct {
+	start, end  int
+	replacement []rune
+}
+
+// findRegexp returns all matches of re in line along with their replacements
+// by replacement, which can refer to submatches like regexp.Expand.
+func findRegexp(re *regexp.Regexp, line []rune, replacement string) []regexpMatch {
+	lineStr := string(line)
+
+	var matches []regexpMatch
+	for _, match := range re.FindAllStringSubmatchIndex(lineStr, -1) {
+		start := utf8.RuneCountInString(lineStr[:match[0]])
+		matches = append(matches, regexpMatch{
+			start:       start,
+			end:         start + utf8.RuneCountInString(lineStr[match[0]:match[1]]),
+			replacement: []rune(string(re.ExpandString(nil, replacement, lineStr, match))),
+		})
+	}
+
+	return matches
+}
+
+// replaceInBuffers replaces all matches of a regular expression in all
+// buffers. The replacements in each buffer are a single change that can be
+// undone in that buffer. The changed lines are listed in a results buffer.
+func (e *editor) replaceInBuffers() {
+	curBuf := e.curView().buf
+
+	pattern, ok := e.readString("Replace in all buffers (regexp)", curBuf.replacePattern)
+	if !ok {
+		log.Printf("replaceInBuffers: entering pattern cancelled")
+		return
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		log.Printf("replaceInBuffers: compiling pattern %q failed: %v", pattern, err)
+		e.showError("Invalid regular expression: %v", err)
+		return
+	}
+
+	curBuf.replacePattern = []rune(pattern)
+
+	replacement, ok := e.readString("Replace with", curBuf.replaceText)
+	if !ok {
+		log.Printf("replaceInBuffers: entering replacement cancelled")
+		return
+	}
+
+	curBuf.replaceText = []rune(replacement)
+
+	bufs := e.searchableBuffers()
+
+	matches := map[*buffer]map[int][]regexpMatch{}
+	count, bufCount := 0, 0
+	for _, buf := range bufs {
+		for y := 0; y < buf.lineCount(); y++ {
+			lineMatches := findRegexp(re, buf.line(y), replacement)
+			if len(lineMatches) == 0 {
+				continue
+			}
+			if matches[buf] == nil {
+				matches[buf] = map[int][]regexpMatch{}
+				bufCount++
+			}
+			matches[buf][y] = lineMatches
+			count += len(lineMatches)
+		}
+	}
+
+	if count == 0 {
+		log.Printf("replaceInBuffers: no occurrences of %q", pattern)
+		e.showError("No occurrences found")
+		return
+	}
+
+	if e.query(fmt.Sprintf("Replace %d occurrence(s) in %d buffer(s)?", count, bufCount), "yn") != 'y' {
+		log.Printf("replaceInBuffers: cancelled")
+		e.showError("Cancelled")
+		return
+	}
+
+	results := e.addResultsBuffer(fmt.Sprintf("Replaced %q with %q:", pattern, replacement))
+
+	for _, buf := range bufs {
+		if matches[buf] == nil {
+			continue
+		}
+
+		log.Printf("replaceInBuffers: replacing in %s", e.bufferName(buf))
+
+		results.addResult("", nil)
+		results.addResult(e.bufferName(buf), nil)
+
+		// the replacements become a change of their own, made in the view
+		// that the buffer was last shown with.
+		v := buf.lastView
+		buf.historyFinishOp()
+		v.beginChange()
+
+		for y := 0; y < buf.lineCount(); y++ {
+			// replacing a match shifts the following matches on the same line.
+			delta := 0
+			for _, match := range matches[buf][y] {
+				v.deleteText(y, match.start+delta, y, match.end+delta)
+				v.insertText(y, match.start+delta, [][]rune{match.replacement})
+				delta += len(match.replacement) - (match.end - match.start)
+			}
+			if first := matches[buf][y]; len(first) > 0 {
+				results.addResult(fmt.Sprintf("  %d: %s", y+1, string(buf.line(y))), &resultLoc{buf: buf, fname: buf.fname, y: y, x: first[0].start})
+			}
+		}
+
+		v.endChange()
+		buf.historyFinishOp()
+		v.correctX()
+	}
+
+	log.Printf("replaceInBuffers: replaced %d occurrences in %d buffers", count, bufCount)
+	e.showError("Replaced %d occurrence(s) in %d buffer(s)", count, bufCount)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func typeString(s string) []*tcell.EventKey {
+	var keys []*tcell.EventKey
+	for _, r := range s {
+		keys = append(keys, tcell.NewEventKey(tcell.KeyRune, r, 0))
+	}
+	return keys
+}
+
+func TestFindInBuffers(t *testing.T) {
+	ed := newTestEditor(t, "foo bar\nbaz")
+	ed.bufs[0].fname = "a.txt"
+	ed.bufs = append(ed.bufs, newBufferFromFileContent([]byte("nothing\nbar bar")))
+
+	keys := []*tcell.EventKey{tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, 's', 0)}
+	keys = append(keys, typeString("bar")...)
+	keys = append(keys, tcell.NewEventKey(tcell.KeyEnter, 0, 0))
+	postKeys(ed, keys...)
+	ed.handleEvent()
+	ed.handleEvent()
+
+	require.Len(t, ed.bufs, 3)
+	require.Equal(t, 2, ed.bufIdx)
+
+	results := ed.bufs[2]
+	require.True(t, results.readOnly)
+	require.Equal(t, []string{
+		`Occurrences of "bar" (match case):`,
+		``,
+		`a.txt`,
+		`  1:5: foo bar`,
+		``,
+		`<no file> (buffer 2)`,
+		`  2:1: bar bar`,
+		`  2:5: bar bar`,
+	}, runeLines(results.lines()))
+	require.Empty(t, results.marks, "the results aren't marked as modified lines")
+
+	// results can't be edited.
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'x', 0), tcell.NewEventKey(tcell.KeyDelete, 0, 0))
+	require.Equal(t, `Occurrences of "bar" (match case):`, string(results.line(0)))
+	require.False(t, results.modified)
+	require.Equal(t, 0, ed.curView().x)
+
+	for i := 0; i < 7; i++ {
+		playKeys(t, ed, tcell.NewEventKey(tcell.KeyDown, 0, 0))
+	}
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyEnter, 0, 0))
+
+	require.Equal(t, 1, ed.bufIdx)
+	require.Equal(t, []int{1, 4}, []int{ed.curView().curLineIdx(), ed.curView().x})
+}
+
+func TestReplaceInBuffers(t *testing.T) {
+	ed := newTestEditor(t, "oldName := 1\nfmt.Println(oldName)")
+	ed.bufs = append(ed.bufs, newBufferFromFileContent([]byte("return oldName + oldName")), newBufferFromFileContent([]byte("unrelated")))
+
+	keys := []*tcell.EventKey{tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyCtrlT, 0, 0)}
+	keys = append(keys, typeString(`old(\w+)`)...)
+	keys = append(keys, tcell.NewEventKey(tcell.KeyEnter, 0, 0))
+	keys = append(keys, typeString("new$1")...)
+	keys = append(keys, tcell.NewEventKey(tcell.KeyEnter, 0, 0), tcell.NewEventKey(tcell.KeyRune, 'y', 0))
+	postKeys(ed, keys...)
+	ed.handleEvent()
+	ed.handleEvent()
+
+	require.Equal(t, []string{"newName := 1", "fmt.Println(newName)"}, runeLines(ed.bufs[0].lines()))
+	require.Equal(t, []string{"return newName + newName"}, runeLines(ed.bufs[1].lines()))
+	require.Equal(t, []string{"unrelated"}, runeLines(ed.bufs[2].lines()))
+	require.True(t, ed.bufs[0].modified)
+	require.True(t, ed.bufs[1].modified)
+	require.False(t, ed.bufs[2].modified)
+
+	require.Equal(t, []string{
+		`Replaced "old(\\w+)" with "new$1":`,
+		``,
+		`<no file> (buffer 1)`,
+		`  1: newName := 1`,
+		`  2: fmt.Println(newName)`,
+		``,
+		`<no file> (buffer 2)`,
+		`  1: return newName + newName`,
+	}, runeLines(ed.bufs[3].lines()))
+
+	// every buffer has its own undo entry for the replacements.
+	ed.bufIdx = 1
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0))
+	require.Equal(t, []string{"return oldName + oldName"}, runeLines(ed.bufs[1].lines()))
+	require.False(t, ed.bufs[1].modified)
+	require.Equal(t, []string{"newName := 1", "fmt.Println(newName)"}, runeLines(ed.bufs[0].lines()))
+
+	ed.bufIdx = 0
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0))
+	require.Equal(t, []string{"oldName := 1", "fmt.Println(oldName)"}, runeLines(ed.bufs[0].lines()))
+	require.False(t, ed.bufs[0].modified)
+}
+
+func TestBufferName(t *testing.T) {
+	ed := newTestEditor(t, "")
+	ed.bufs = append(ed.bufs, newBufferFromFileContent(nil))
+	ed.bufs[1].fname = "dir/a.txt"
+	results := ed.addResultsBuffer("title")
+
+	testData := map[string]struct {
+		buf  *buffer
+		name string
+	}{
+		"no file":   {ed.bufs[0], "<no file> (buffer 1)"},
+		"file":      {ed.bufs[1], "dir/a.txt"},
+		"results":   {results, "<results> (buffer 3)"},
+		"not shown": {newBufferFromFileContent(nil), "<no file>"},
+	}
+
+	for name, tt := range testData {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tt.name, ed.bufferName(tt.buf))
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreRule is a pattern of a .gitignore file.
+type ignoreRule struct {
+	base     string // directory of the .gitignore file, relative to the root.
+	pattern  string
+	negate   bool // the pattern starts with !, matches are no longer ignored.
+	dirOnly  bool // the pattern ends with /, only directories match.
+	anchored bool // the pattern contains a /, it is relative to base.
+}
+
+// ignoreMatcher decides which files are ignored according to the .gitignore
+// files found while walking a directory tree. Paths are relative to the root
+// of the tree and use / as separator.
+type ignoreMatcher struct {
+	rules []ignoreRule
+}
+
+// load adds the rules of the .gitignore file in the directory dir, which is
+// rel relative to the root.
+func (m *ignoreMatcher) load(dir, rel string) error {
+	f, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	if rel == "." {
+		rel = ""
+	}
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if rule, ok := parseIgnoreRule(rel, s.Text()); ok {
+			m.rules = append(m.rules, rule)
+		}
+	}
+
+	return s.Err()
+}
+
+func parseIgnoreRule(base, line string) (rule ignoreRule, ok bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+
+	rule.base = base
+
+	if strings.HasPrefix(line, "!") {
+		rule.negate, line = true, line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly, line = true, strings.TrimRight(line, "/")
+	}
+
+	if strings.Contains(line, "/") {
+		rule.anchored, line = true, strings.TrimPrefix(line, "/")
+	}
+
+	rule.pattern = line
+
+	return rule, line != ""
+}
+
+// ignored returns true if the file or directory rel is ignored. The last
+// matching rule decides, like in git.
+func (m *ignoreMatcher) ignored(rel string, isDir bool) bool {
+	ignored := false
+
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		name := rel
+		if rule.base != "" {
+			if !strings.HasPrefix(rel, rule.base+"/") {
+				continue
+			}
+			name = strings.TrimPrefix(rel, rule.base+"/")
+		}
+
+		matched := false
+		if rule.anchored {
+			matched = matchGlob(rule.pattern, name)
+		} else {
+			matched = matchGlob(rule.pattern, path.Base(name))
+		}
+
+		if matched {
+			ignored = !rule.negate
+		}
+	}
+
+	return ignored
+}
+
+// matchGlob matches name against a glob pattern in which ** matches any
+// number of path elements.
+func matchGlob(pattern, name string) bool {
+	return matchGlobElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobElems(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlobElems(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
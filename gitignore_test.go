@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnoreMatcher(t *testing.T) {
+	var m ignoreMatcher
+	for _, rule := range []struct{ base, line string }{
+		{"", "# comment"},
+		{"", "*.o"},
+		{"", "!keep.o"},
+		{"", "/build"},
+		{"", "tmp/"},
+		{"", "docs/**/*.html"},
+		{"sub", "local.txt"},
+		{"sub", "/only-here"},
+	} {
+		if r, ok := parseIgnoreRule(rule.base, rule.line); ok {
+			m.rules = append(m.rules, r)
+		}
+	}
+	require.Len(t, m.rules, 7)
+
+	testData := map[string]struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		"glob":                      {path: "main.o", ignored: true},
+		"glob in subdirectory":      {path: "a/b/main.o", ignored: true},
+		"negated":                   {path: "a/keep.o", ignored: false},
+		"not matching":              {path: "main.go", ignored: false},
+		"anchored":                  {path: "build", isDir: true, ignored: true},
+		"anchored elsewhere":        {path: "a/build", isDir: true, ignored: false},
+		"directory only":            {path: "a/tmp", isDir: true, ignored: true},
+		"directory only, file":      {path: "a/tmp", ignored: false},
+		"double star":               {path: "docs/a/b/index.html", ignored: true},
+		"double star, no elements":  {path: "docs/index.html", ignored: true},
+		"double star, other dir":    {path: "site/index.html", ignored: false},
+		"nested gitignore":          {path: "sub/x/local.txt", ignored: true},
+		"nested gitignore, outside": {path: "local.txt", ignored: false},
+		"nested anchored":           {path: "sub/only-here", ignored: true},
+		"nested anchored, deeper":   {path: "sub/x/only-here", ignored: false},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			require.Equal(t, tt.ignored, m.ignored(tt.path, tt.isDir))
+		})
+	}
+}
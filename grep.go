@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// grepQuery is what grep searches for.
+type grepQuery struct {
+	pattern string
+	regexp  bool // pattern is a regular expression, otherwise it is literal.
+	opts    searchOptions
+}
+
+func (q grepQuery) describe() string {
+	kind := "literal"
+	if q.regexp {
+		kind = "regexp"
+	}
+	return kind + ", " + q.opts.describe()
+}
+
+// compile returns the regular expression that finds q.pattern with the
+// options of q. The match is its first subexpression, see matchLine.
+func (q grepQuery) compile() (*regexp.Regexp, error) {
+	expr := q.pattern
+	if !q.regexp {
+		expr = regexp.QuoteMeta(expr)
+	}
+	expr = `(` + expr + `)`
+	if q.opts.wholeWord {
+		// \b only knows ASCII letters, so words are delimited by what isn't
+		// a word character like in search.go.
+		expr = `(?:^|[^\p{L}\p{Nd}_])` + expr + `(?:[^\p{L}\p{Nd}_]|$)`
+	}
+	if q.opts.ignoreCase([]rune(q.pattern)) {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// grepMatch is a line of a file that matches.
+type grepMatch struct {
+	y, x int
+	line string
+}
+
+// grepResults is posted to the input loop for every file with matches.
+type grepResults struct {
+	buf     *buffer // the results buffer.
+	fname   string
+	matches []grepMatch
+}
+
+// grepDone is posted to the input loop when a search is finished.
+type grepDone struct {
+	buf   *buffer
+	files int // number of files searched.
+	err   error
+}
+
+// grep searches all files in a directory tree that aren't ignored by
+// .gitignore files. The search runs in the background, matches are added to
+// a results buffer as they are found.
+func (e *editor) grep() {
+	q := e.lastGrep
+
+	update := func(ev *tcell.EventKey, input []rune) string {
+		if ev != nil && ev.Key() == tcell.KeyRune && ev.Modifiers()&tcell.ModAlt != 0 {
+			switch ev.Rune() {
+			case 'r', 'R':
+				q.regexp = !q.regexp
+			case 'b', 'B':
+				// the direction doesn't matter.
+			default:
+				q.opts.toggle(ev.Rune())
+			}
+		}
+		return fmt.Sprintf("Grep [%s] (Alt-R/C/W: regexp, case, word)", q.describe())
+	}
+
+	pattern, ok := e.readStringFunc("Grep", []rune(q.pattern), update)
+	if !ok || pattern == "" {
+		log.Printf("grep: entering pattern cancelled")
+		return
+	}
+	q.pattern = pattern
+
+	re, err := q.compile()
+	if err != nil {
+		log.Printf("grep: compiling pattern %q failed: %v", pattern, err)
+		e.showError("Invalid regular expression: %v", err)
+		return
+	}
+
+	dir, ok := e.readString("In directory", []rune(e.grepDir))
+	if !ok {
+		log.Printf("grep: entering directory cancelled")
+		return
+	}
+	if dir == "" {
+		dir = "."
+	}
+
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		e.showError("%s is not a directory", dir)
+		return
+	}
+
+	e.lastGrep, e.grepDir = q, dir
+
+	log.Printf("grep: searching for %q (%s) in %s", pattern, q.describe(), dir)
+
+	buf := e.addResultsBuffer(fmt.Sprintf("Grep for %q (%s) in %s:", pattern, q.describe(), dir))
+
+	stop := make(chan struct{})
+	buf.stopSearch = func() {
+		close(stop)
+	}
+
+	go e.runGrep(buf, re, dir, stop)
+}
+
+// runGrep walks dir, searches the files with a pool of workers and posts the
+// matches to the input loop until all files are searched or stop is closed.
+func (e *editor) runGrep(buf *buffer, re *regexp.Regexp, dir string, stop <-chan struct{}) {
+	post := func(data interface{}) {
+		select {
+		case <-stop:
+		default:
+			e.scr.PostEventWait(tcell.NewEventInterrupt(data))
+		}
+	}
+
+	files := make(chan string)
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fname := range files {
+				matches, err := grepFile(re, fname)
+				if err != nil {
+					log.Printf("grep: searching %s failed: %v", fname, err)
+					continue
+				}
+				if len(matches) > 0 {
+					post(grepResults{buf: buf, fname: fname, matches: matches})
+				}
+			}
+		}()
+	}
+
+	count := 0
+	err := walkFiles(dir, func(fname string) bool {
+		select {
+		case files <- fname:
+			count++
+			return true
+		case <-stop:
+			return false
+		}
+	})
+
+	close(files)
+	wg.Wait()
+
+	post(grepDone{buf: buf, files: count, err: err})
+}
+
+// walkFiles calls f with the name of every regular file in the tree of dir
+// that isn't ignored by a .gitignore file, until f returns false.
+func walkFiles(dir string, f func(fname string) bool) error {
+	var ignore ignoreMatcher
+
+	errStop := errors.New("stopped")
+
+	err := filepath.WalkDir(dir, func(fname string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("walkFiles: %v", err)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, fname)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel != "." && (d.Name() == ".git" || ignore.ignored(rel, true)) {
+				return filepath.SkipDir
+			}
+			if err := ignore.load(fname, rel); err != nil {
+				log.Printf("walkFiles: reading .gitignore in %s failed: %v", fname, err)
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() || ignore.ignored(rel, false) {
+			return nil
+		}
+
+		if !f(fname) {
+			return errStop
+		}
+
+		return nil
+	})
+
+	if err == errStop {
+		return nil
+	}
+
+	return err
+}
+
+const (
+	grepHeadSize    = 8000     // bytes at the start of a file that are checked for NUL bytes.
+	maxGrepFileSize = 16 << 20 // larger files are skipped.
+)
+
+// grepFile returns the lines of the file fname that match re. Binary files
+// and files larger than maxGrepFileSize are skipped.
+func grepFile(re *regexp.Regexp, fname string) ([]grepMatch, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > maxGrepFileSize {
+		log.Printf("grepFile: skipping %s of %d bytes", fname, fi.Size())
+		return nil, nil
+	}
+
+	// files with a NUL byte near the start are binary files.
+	r := bufio.NewReaderSize(f, grepHeadSize)
+	head, err := r.Peek(grepHeadSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil, nil
+	}
+
+	var matches []grepMatch
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxGrepFileSize)
+	for y := 0; scanner.Scan(); y++ {
+		line := scanner.Text()
+		if x, ok := matchLine(re, line); ok {
+			matches = append(matches, grepMatch{y: y, x: x, line: line})
+		}
+	}
+
+	return matches, scanner.Err()
+}
+
+// matchLine returns the column of the first match of a regular expression
+// returned by grepQuery.compile in line.
+func matchLine(re *regexp.Regexp, line string) (x int, ok bool) {
+	loc := re.FindStringSubmatchIndex(line)
+	if loc == nil {
+		return 0, false
+	}
+	return utf8.RuneCountInString(line[:loc[2]]), true
+}
+
+// addGrepResults adds the matches in a file to the results buffer.
+func (e *editor) addGrepResults(r grepResults) {
+	r.buf.addResult("", nil)
+	r.buf.addResult(r.fname, nil)
+	for _, m := range r.matches {
+		r.buf.addResult(fmt.Sprintf("  %d:%d: %s", m.y+1, m.x+1, m.line), &resultLoc{fname: r.fname, y: m.y, x: m.x})
+		r.buf.resultCount++
+	}
+}
+
+func (e *editor) grepFinished(d grepDone) {
+	d.buf.stopSearch = nil
+
+	if d.err != nil {
+		log.Printf("grepFinished: searching failed: %v", d.err)
+		e.showError("Search failed: %v", d.err)
+		return
+	}
+
+	log.Printf("grepFinished: found %d matches in %d files", d.buf.resultCount, d.files)
+
+	if d.buf.resultCount == 0 {
+		d.buf.addResult("", nil)
+		d.buf.addResult("No matches.", nil)
+	}
+
+	e.showError("Found %d match(es) in %d file(s)", d.buf.resultCount, d.files)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrep(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		".gitignore":          "*.log\nvendor/\n",
+		"main.go":             "package main\n\nfunc main() {\n\tgreet()\n}\n",
+		"greet.go":            "package main\n\nfunc greet() {}\n",
+		"debug.log":           "greet\n",
+		"vendor/lib/lib.go":   "func greet() {}\n",
+		"sub/.gitignore":      "generated.go\n",
+		"sub/generated.go":    "greet()\n",
+		"sub/util.go":         "// Greet is not matched, case matters.\n",
+		"binary.bin":          "greet\x00",
+		".git/COMMIT_EDITMSG": "greet\n",
+	} {
+		fname := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fname), 0755))
+		require.NoError(t, os.WriteFile(fname, []byte(content), 0644))
+	}
+
+	ed := newTestEditor(t, "")
+
+	keys := []*tcell.EventKey{tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, 'g', 0)}
+	keys = append(keys, typeString("greet\\(")...)
+	keys = append(keys, tcell.NewEventKey(tcell.KeyRune, 'r', tcell.ModAlt), tcell.NewEventKey(tcell.KeyEnter, 0, 0))
+	keys = append(keys, typeString(dir)...)
+	keys = append(keys, tcell.NewEventKey(tcell.KeyEnter, 0, 0))
+	postKeys(ed, keys...)
+	ed.handleEvent()
+	ed.handleEvent()
+
+	buf := ed.bufs[ed.bufIdx]
+	require.True(t, buf.readOnly)
+	require.NotNil(t, buf.stopSearch)
+
+	// results arrive as events until the search is finished.
+	for buf.stopSearch != nil {
+		ed.handleEvent()
+	}
+
+	var results []string
+	for _, line := range runeLines(buf.lines()) {
+		if strings.HasPrefix(line, "  ") {
+			results = append(results, line)
+		}
+	}
+	sort.Strings(results)
+	require.Equal(t, []string{"  3:6: func greet() {}", "  4:2: \tgreet()"}, results)
+	require.Equal(t, 2, buf.resultCount)
+
+	// Enter on a result opens its file at the match.
+	for y, line := range runeLines(buf.lines()) {
+		if line == "  4:2: \tgreet()" {
+			ed.curView().gotoLine(y)
+		}
+	}
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyEnter, 0, 0))
+
+	opened := ed.bufs[ed.bufIdx]
+	require.Equal(t, filepath.Join(dir, "main.go"), opened.fname)
+	require.Equal(t, []int{3, 1}, []int{ed.curView().curLineIdx(), ed.curView().x})
+
+	// going to the result again uses the open buffer.
+	ed.bufIdx = 1
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyEnter, 0, 0))
+	require.Len(t, ed.bufs, 3)
+	require.Equal(t, 2, ed.bufIdx)
+}
+
+func TestGrepFile(t *testing.T) {
+	long := strings.Repeat("x", 100000)
+
+	testData := map[string]struct {
+		content  string
+		expected []grepMatch
+	}{
+		"crlf": {
+			content:  "a\r\nfoo\r\n",
+			expected: []grepMatch{{y: 1, x: 0, line: "foo"}},
+		},
+		"long lines": {
+			content:  long + "\n" + long + "foo",
+			expected: []grepMatch{{y: 1, x: len(long), line: long + "foo"}},
+		},
+		"binary": {
+			content: "foo\x00",
+		},
+		"NUL after the head": {
+			content:  long + "\nfoo\x00",
+			expected: []grepMatch{{y: 1, x: 0, line: "foo\x00"}},
+		},
+	}
+
+	re, err := grepQuery{pattern: "foo"}.compile()
+	require.NoError(t, err)
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			fname := filepath.Join(t.TempDir(), "file")
+			require.NoError(t, os.WriteFile(fname, []byte(tt.content), 0644))
+
+			matches, err := grepFile(re, fname)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, matches)
+		})
+	}
+}
+
+func TestMatchLine(t *testing.T) {
+	testData := map[string]struct {
+		q        grepQuery
+		line     string
+		expected int // -1 if the line doesn't match.
+	}{
+		"literal":                 {grepQuery{pattern: "a.b"}, "xaxb a.b", 5},
+		"regexp":                  {grepQuery{pattern: "a.b", regexp: true}, "xaxb a.b", 1},
+		"regexp with groups":      {grepQuery{pattern: "(a)(b)", regexp: true}, "例 ab", 2},
+		"whole word":              {grepQuery{pattern: "foo", opts: searchOptions{wholeWord: true}}, "foobar (foo)", 8},
+		"whole word at start":     {grepQuery{pattern: "foo", opts: searchOptions{wholeWord: true}}, "foo bar", 0},
+		"whole word at end":       {grepQuery{pattern: "foo", opts: searchOptions{wholeWord: true}}, "bar_foo foo", 8},
+		"non-ASCII word":          {grepQuery{pattern: "foo", opts: searchOptions{wholeWord: true}}, "äfoo fooé", -1},
+		"non-ASCII word boundary": {grepQuery{pattern: "über", opts: searchOptions{wholeWord: true}}, "drüber über", 7},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			re, err := tt.q.compile()
+			require.NoError(t, err)
+
+			x, ok := matchLine(re, tt.line)
+			if tt.expected < 0 {
+				require.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			require.Equal(t, tt.expected, x)
+		})
+	}
+}
+
+func TestGrepResultsWhilePrompting(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("foo\nbar foo\n"), 0644))
+
+	re, err := grepQuery{pattern: "foo"}.compile()
+	require.NoError(t, err)
+
+	ed := newTestEditor(t, "")
+	buf := ed.addResultsBuffer("Grep:")
+	buf.stopSearch = func() {}
+
+	// the results and the end of the search are queued while a prompt is
+	// open, and are handled by the prompt.
+	ed.runGrep(buf, re, dir, make(chan struct{}))
+	require.NoError(t, ed.scr.PostEvent(tcell.NewEventKey(tcell.KeyEsc, 0, 0)))
+
+	_, ok := ed.readString("Filename", nil)
+	require.False(t, ok)
+
+	require.Nil(t, buf.stopSearch, "the search is finished")
+	require.Equal(t, 2, buf.resultCount)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// lineNumbers is how the gutter left of the text shows line numbers.
+type lineNumbers int
+
+const (
+	numbersOff      lineNumbers = iota // there is no gutter.
+	numbersAbsolute                    // every line shows its number.
+	numbersRelative                    // the current line shows its number, all other lines their distance from it.
+)
+
+func (n lineNumbers) String() string {
+	switch n {
+	case numbersAbsolute:
+		return "absolute"
+	case numbersRelative:
+		return "relative"
+	default:
+		return "off"
+	}
+}
+
+// gutterWidth returns the number of screen columns left of the text of buf,
+// which show a marker column, the line numbers and a space.
+func (e *editor) gutterWidth(buf *buffer) int {
+	if e.lineNumbers == numbersOff {
+		return 0
+	}
+	return len(strconv.Itoa(buf.lineCount())) + 2
+}
+
+// textWidth returns the number of screen columns of the current split that
+// show the text of buf.
+func (e *editor) textWidth(buf *buffer) int {
+	e.arrange()
+	return e.win.width - e.gutterWidth(buf)
+}
+
+// drawGutter draws the gutter of line lineIdx of the buffer of v in screen row
+// y, starting at screen column left. Only the first row of a wrapped line
+// shows its number and markers.
+func (e *editor) drawGutter(v *view, left int, y int, lineIdx int, firstRow bool) {
+	width := e.gutterWidth(v.buf)
+	if width == 0 {
+		return
+	}
+
+	style := e.theme.style(slotGutter)
+
+	text := ""
+	marker, markerStyle := ' ', style
+	if firstRow && lineIdx < v.buf.lineCount() {
+		curLineIdx := v.curLineIdx()
+		n := lineIdx + 1
+		if e.lineNumbers == numbersRelative && lineIdx != curLineIdx {
+			n = lineIdx - curLineIdx
+			if n < 0 {
+				n = -n
+			}
+		}
+		if lineIdx == curLineIdx {
+			style = e.theme.apply(slotGutterCurrent, style)
+		}
+		text = strconv.Itoa(n)
+
+		switch {
+		case v.buf.hasMark(lineIdx, markDiagnostic):
+			marker, markerStyle = '!', e.theme.apply(slotMarkerDiagnostic, style)
+		case v.buf.hasMark(lineIdx, markBookmark):
+			marker, markerStyle = '>', e.theme.apply(slotMarkerBookmark, style)
+		case v.buf.modified && v.buf.hasMark(lineIdx, markModified):
+			marker, markerStyle = '*', e.theme.apply(slotMarkerModified, style)
+		}
+	}
+
+	e.scr.SetContent(left, y, marker, nil, markerStyle)
+	for x, r := range fmt.Sprintf("%*s ", width-2, text) {
+		e.scr.SetContent(left+x+1, y, r, nil, style)
+	}
+}
+
+// toggleLineNumbers switches the gutter between no line numbers, absolute and
+// relative line numbers.
+func (e *editor) toggleLineNumbers() {
+	e.lineNumbers = (e.lineNumbers + 1) % (numbersRelative + 1)
+
+	log.Printf("toggleLineNumbers: line numbers are now %s", e.lineNumbers)
+
+	e.showError("Line numbers %s", e.lineNumbers)
+}
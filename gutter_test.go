@@ -0,0 +1,147 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// screenRow returns the text in row y of the simulation screen.
+func screenRow(scr tcell.SimulationScreen, y int) string {
+	cells, width, _ := scr.GetContents()
+	var row []rune
+	for _, cell := range cells[y*width : (y+1)*width] {
+		row = append(row, cell.Runes...)
+	}
+	return strings.TrimRight(string(row), " ")
+}
+
+func TestGutter(t *testing.T) {
+	testData := map[string]struct {
+		numbers lineNumbers
+		rows    []string
+	}{
+		"off":      {numbersOff, []string{"one", "two", "three"}},
+		"absolute": {numbersAbsolute, []string{" 1 one", " 2 two", " 3 three"}},
+		"relative": {numbersRelative, []string{" 1 one", " 2 two", " 1 three"}},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			ed := newTestEditor(t, "one\ntwo\nthree")
+			scr := ed.scr.(tcell.SimulationScreen)
+
+			ed.lineNumbers = tt.numbers
+			playKeys(t, ed, tcell.NewEventKey(tcell.KeyDown, 0, 0), tcell.NewEventKey(tcell.KeyRight, 0, 0))
+			ed.redrawScreen()
+
+			for y, row := range tt.rows {
+				require.Equal(t, row, screenRow(scr, y))
+			}
+
+			x, y, _ := scr.GetCursor()
+			require.Equal(t, []int{ed.gutterWidth(ed.bufs[0]) + 1, 1}, []int{x, y})
+		})
+	}
+}
+
+func TestGutterMarkers(t *testing.T) {
+	ed := newTestEditor(t, "one\ntwo\nthree\nfour")
+	buf := ed.bufs[0]
+	scr := ed.scr.(tcell.SimulationScreen)
+	ed.lineNumbers = numbersAbsolute
+
+	playKeys(t, ed,
+		tcell.NewEventKey(tcell.KeyDown, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, 'm', 0),
+		tcell.NewEventKey(tcell.KeyUp, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, 'x', 0),
+		tcell.NewEventKey(tcell.KeyEnter, 0, 0))
+
+	ed.redrawScreen()
+	require.Equal(t, []string{"*1 x", "*2 one", ">3 two", " 4 three"}, []string{screenRow(scr, 0), screenRow(scr, 1), screenRow(scr, 2), screenRow(scr, 3)}, "the bookmark moves with its line")
+
+	ed.curView().gotoLine(0)
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, 'j', 0))
+	require.Equal(t, 2, ed.curView().curLineIdx())
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0))
+	require.False(t, buf.modified)
+
+	ed.redrawScreen()
+	require.Equal(t, []string{" 1 one", ">2 two"}, []string{screenRow(scr, 0), screenRow(scr, 1)}, "no lines are modified after undoing all changes")
+}
+
+func TestDiagnosticMarkers(t *testing.T) {
+	ed := newTestEditor(t, "one\ntwo\nthree")
+	buf := ed.bufs[0]
+	scr := ed.scr.(tcell.SimulationScreen)
+	ed.lineNumbers = numbersAbsolute
+
+	buf.setMark(1, markBookmark)
+	buf.setDiagnostics([]int{1, 2, 5})
+	ed.redrawScreen()
+	require.Equal(t, []string{" 1 one", "!2 two", "!3 three"}, []string{screenRow(scr, 0), screenRow(scr, 1), screenRow(scr, 2)}, "diagnostics are shown before bookmarks")
+
+	cells, width, _ := scr.GetContents()
+	require.Equal(t, ed.theme.apply(slotMarkerDiagnostic, ed.theme.style(slotGutter)), cells[2*width].Style)
+
+	buf.setDiagnostics([]int{0})
+	ed.redrawScreen()
+	require.Equal(t, []string{"!1 one", ">2 two", " 3 three"}, []string{screenRow(scr, 0), screenRow(scr, 1), screenRow(scr, 2)}, "setting diagnostics replaces the previous ones")
+}
+
+func TestLineMarksFollowEdits(t *testing.T) {
+	testData := map[string]struct {
+		edit  func(buf *buffer)
+		marks map[int]lineMark
+	}{
+		"insert line break above": {
+			func(buf *buffer) { buf.insert(0, 1, [][]rune{{}, {}}, nil) },
+			map[int]lineMark{0: markModified, 1: markModified, 3: markBookmark},
+		},
+		"insert line break at start of line": {
+			func(buf *buffer) { buf.insert(2, 0, [][]rune{{'x'}, {}}, nil) },
+			map[int]lineMark{2: markModified, 3: markBookmark | markModified},
+		},
+		"insert in line": {
+			func(buf *buffer) { buf.insert(2, 1, [][]rune{{'x'}}, nil) },
+			map[int]lineMark{2: markBookmark | markModified},
+		},
+		"remove line break above": {
+			func(buf *buffer) { buf.remove(0, 1, 1, 0, nil) },
+			map[int]lineMark{0: markModified, 1: markBookmark},
+		},
+		"remove marked line": {
+			func(buf *buffer) { buf.remove(1, 1, 3, 0, nil) },
+			map[int]lineMark{1: markBookmark | markModified},
+		},
+		"insert line break above adjacent marks": {
+			func(buf *buffer) {
+				buf.setMark(3, markBookmark)
+				buf.insert(1, 0, [][]rune{{}, {}}, nil)
+			},
+			map[int]lineMark{1: markModified, 2: markModified, 3: markBookmark, 4: markBookmark},
+		},
+		"remove line break above adjacent marks": {
+			func(buf *buffer) {
+				buf.setMark(3, markBookmark)
+				buf.remove(0, 1, 1, 0, nil)
+			},
+			map[int]lineMark{0: markModified, 1: markBookmark, 2: markBookmark},
+		},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			buf := newBufferFromFileContent([]byte("one\ntwo\nthree\nfour"))
+			buf.setMark(2, markBookmark)
+
+			tt.edit(buf)
+
+			require.Equal(t, tt.marks, buf.marks)
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// keyStroke is a single key press, including its modifiers. Runes are only
+// set for tcell.KeyRune.
+type keyStroke struct {
+	key tcell.Key
+	r   rune
+	mod tcell.ModMask
+}
+
+// keyNamesLower maps the lowercase names of tcell's special keys to the keys.
+var keyNamesLower = func() map[string]tcell.Key {
+	m := map[string]tcell.Key{}
+	for key, name := range tcell.KeyNames {
+		m[strings.ToLower(name)] = key
+	}
+	return m
+}()
+
+var modifierNames = []struct {
+	prefix string
+	mod    tcell.ModMask
+}{
+	{"ctrl-", tcell.ModCtrl},
+	{"alt-", tcell.ModAlt},
+	{"meta-", tcell.ModAlt}, // tcell reports Meta as Alt.
+	{"shift-", tcell.ModShift},
+}
+
+// parseKeyStroke parses key names like "Ctrl-S", "Alt-x", "Shift-Up" or "F5".
+// Special keys are named as in tcell.KeyNames, ignoring case.
+func parseKeyStroke(s string) (keyStroke, error) {
+	rest := s
+	var mod tcell.ModMask
+
+	for {
+		if key, ok := keyNamesLower[strings.ToLower(rest)]; ok {
+			return normalizeKeyStroke(keyStroke{key: key, mod: mod}), nil
+		}
+
+		if strings.EqualFold(rest, "space") {
+			return normalizeKeyStroke(keyStroke{key: tcell.KeyRune, r: ' ', mod: mod}), nil
+		}
+
+		if runes := []rune(rest); len(runes) == 1 {
+			if mod&tcell.ModCtrl != 0 {
+				// control characters that tcell.KeyNames lists under a different name, e.g. Ctrl-H as Backspace.
+				r := unicode.ToUpper(runes[0])
+				if r < '@' || r > '_' {
+					return keyStroke{}, fmt.Errorf("invalid key %q: Ctrl can't be combined with %q", s, rest)
+				}
+				return normalizeKeyStroke(keyStroke{key: tcell.Key(r - '@'), mod: mod}), nil
+			}
+			return normalizeKeyStroke(keyStroke{key: tcell.KeyRune, r: runes[0], mod: mod}), nil
+		}
+
+		found := false
+		for _, m := range modifierNames {
+			if len(rest) > len(m.prefix) && strings.EqualFold(rest[:len(m.prefix)], m.prefix) {
+				mod |= m.mod
+				rest = rest[len(m.prefix):]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return keyStroke{}, fmt.Errorf("invalid key %q", s)
+		}
+	}
+}
+
+func keyStrokeFromEvent(ev *tcell.EventKey) keyStroke {
+	k := keyStroke{key: ev.Key(), mod: ev.Modifiers()}
+	if k.key == tcell.KeyRune {
+		k.r = ev.Rune()
+	}
+	return normalizeKeyStroke(k)
+}
+
+// normalizeKeyStroke removes modifiers that are implied by the key or can't
+// be reliably reported by terminals, so that parsed key strokes compare equal
+// to the key strokes of the corresponding events.
+func normalizeKeyStroke(k keyStroke) keyStroke {
+	if k.mod&tcell.ModMeta != 0 {
+		k.mod = k.mod&^tcell.ModMeta | tcell.ModAlt
+	}
+	if k.key == tcell.KeyRune {
+		k.mod &^= tcell.ModShift
+	}
+	if k.key <= tcell.KeyCtrlUnderscore {
+		k.mod &^= tcell.ModCtrl
+	}
+	return k
+}
+
+func (k keyStroke) String() string {
+	s := ""
+	if k.mod&tcell.ModCtrl != 0 {
+		s += "Ctrl-"
+	}
+	if k.mod&tcell.ModAlt != 0 {
+		s += "Alt-"
+	}
+	if k.mod&tcell.ModShift != 0 {
+		s += "Shift-"
+	}
+
+	switch {
+	case k.key == tcell.KeyRune && k.r == ' ':
+		s += "Space"
+	case k.key == tcell.KeyRune:
+		s += string(k.r)
+	case tcell.KeyNames[k.key] != "":
+		s += tcell.KeyNames[k.key]
+	default:
+		s += fmt.Sprintf("Key[%d]", k.key)
+	}
+
+	return s
+}
+
+// keySequence is a sequence of key strokes that is bound to a command, e.g.
+// a prefix key followed by another key.
+type keySequence []keyStroke
+
+// parseKeySequence parses whitespace-separated key names like "Alt-X Ctrl-S".
+func parseKeySequence(s string) (keySequence, error) {
+	var seq keySequence
+	for _, name := range strings.Fields(s) {
+		key, err := parseKeyStroke(name)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, key)
+	}
+	if len(seq) == 0 {
+		return nil, fmt.Errorf("invalid key %q", s)
+	}
+	return seq, nil
+}
+
+func (seq keySequence) String() string {
+	var names []string
+	for _, key := range seq {
+		names = append(names, key.String())
+	}
+	return strings.Join(names, " ")
+}
+
+func (seq keySequence) equal(other keySequence) bool {
+	if len(seq) != len(other) {
+		return false
+	}
+	for idx := range seq {
+		if seq[idx] != other[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// keyNode is a node in the trie of key sequences. Inner nodes are prefixes,
+// leaves hold the key mapping that is run when their sequence is complete.
+type keyNode struct {
+	children map[keyStroke]*keyNode
+	op       *keyMapping
+}
+
+func (node *keyNode) lookup(seq keySequence) *keyNode {
+	for _, key := range seq {
+		if node = node.children[key]; node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+func buildKeyTrie(ops []keyMapping) (*keyNode, error) {
+	var errs configErrors
+
+	root := &keyNode{children: map[keyStroke]*keyNode{}}
+
+opLoop:
+	for idx := range ops {
+		op := &ops[idx]
+		node := root
+		for i, key := range op.Keys {
+			if node.op != nil {
+				errs = append(errs, fmt.Errorf("%s: prefix %s is already bound to %s", op.Keys, op.Keys[:i], node.op.Cmd))
+				continue opLoop
+			}
+			child := node.children[key]
+			if child == nil {
+				child = &keyNode{children: map[keyStroke]*keyNode{}}
+				node.children[key] = child
+			}
+			node = child
+		}
+		if len(node.children) > 0 {
+			errs = append(errs, fmt.Errorf("%s: can't be bound to %s because it is a prefix of other key bindings", op.Keys, op.Cmd))
+			continue
+		}
+		node.op = op
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return root, nil
+}
+
+type command struct {
+	Name string
+	Func func()
+	Desc string
+}
+
+type keyBinding struct {
+	Key     string
+	Command string
+}
+
+// configErrors collects all problems found in a configuration file so they
+// can be reported at once.
+type configErrors []error
+
+func (errs configErrors) Error() string {
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e *editor) keyMappings(bindings []keyBinding) ([]keyMapping, *keyNode, error) {
+	var (
+		ops  []keyMapping
+		errs configErrors
+	)
+
+	for _, binding := range bindings {
+		keys, err := parseKeySequence(binding.Key)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		idx := -1
+		for i, op := range ops {
+			if op.Keys.equal(keys) {
+				idx = i
+				break
+			}
+		}
+
+		if binding.Command == "" {
+			if idx >= 0 {
+				ops = append(ops[:idx], ops[idx+1:]...)
+			}
+			continue
+		}
+
+		cmd, ok := e.cmds[binding.Command]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown command %q", binding.Key, binding.Command))
+			continue
+		}
+
+		op := keyMapping{Keys: keys, Cmd: cmd.Name, Func: cmd.Func, Desc: cmd.Desc}
+		if idx >= 0 {
+			ops[idx] = op
+		} else {
+			ops = append(ops, op)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	keys, err := buildKeyTrie(ops)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ops, keys, nil
+}
+
+// loadKeyBindings reads a JSON object that maps key names to command names
+// from fname and applies it on top of the default key bindings. Keys can be
+// sequences of key names separated by spaces. Mapping a key to an empty
+// command name removes its binding. A missing file is not an error.
+func (e *editor) loadKeyBindings(fname string) error {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			log.Printf("loadKeyBindings: %s doesn't exist, using default key bindings", fname)
+			return nil
+		}
+		return err
+	}
+
+	var config map[string]string
+	if err := json.Unmarshal(data, &config); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(config))
+	for name := range config {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bindings := append([]keyBinding{}, defaultKeyBindings...)
+	for _, name := range names {
+		bindings = append(bindings, keyBinding{Key: name, Command: config[name]})
+	}
+
+	ops, keys, err := e.keyMappings(bindings)
+	if err != nil {
+		return err
+	}
+
+	e.ops, e.keys = ops, keys
+
+	log.Printf("loadKeyBindings: loaded %d key bindings from %s", len(config), fname)
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyStroke(t *testing.T) {
+	testData := map[string]struct {
+		Name        string
+		ExpectedKey keyStroke
+		ExpectError bool
+	}{
+		"ctrl":            {"Ctrl-S", keyStroke{key: tcell.KeyCtrlS}, false},
+		"ctrl-lowercase":  {"ctrl-s", keyStroke{key: tcell.KeyCtrlS}, false},
+		"ctrl-backspace":  {"Ctrl-H", keyStroke{key: tcell.KeyCtrlH}, false},
+		"special":         {"PgDn", keyStroke{key: tcell.KeyPgDn}, false},
+		"function-key":    {"F5", keyStroke{key: tcell.KeyF5}, false},
+		"alt-rune":        {"Alt-x", keyStroke{key: tcell.KeyRune, r: 'x', mod: tcell.ModAlt}, false},
+		"meta-rune":       {"Meta-x", keyStroke{key: tcell.KeyRune, r: 'x', mod: tcell.ModAlt}, false},
+		"alt-ctrl":        {"Alt-Ctrl-A", keyStroke{key: tcell.KeyCtrlA, mod: tcell.ModAlt}, false},
+		"ctrl-special":    {"Ctrl-Up", keyStroke{key: tcell.KeyUp, mod: tcell.ModCtrl}, false},
+		"shift-special":   {"Shift-Left", keyStroke{key: tcell.KeyLeft, mod: tcell.ModShift}, false},
+		"alt-space":       {"Alt-Space", keyStroke{key: tcell.KeyRune, r: ' ', mod: tcell.ModAlt}, false},
+		"alt-dash":        {"Alt--", keyStroke{key: tcell.KeyRune, r: '-', mod: tcell.ModAlt}, false},
+		"unknown":         {"Hyper-X", keyStroke{}, true},
+		"ctrl-digit":      {"Ctrl-1", keyStroke{}, true},
+		"empty":           {"", keyStroke{}, true},
+		"unknown-special": {"Foo", keyStroke{}, true},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			key, err := parseKeyStroke(tt.Name)
+			if tt.ExpectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.ExpectedKey, key)
+		})
+	}
+}
+
+func TestKeyStrokeFromEvent(t *testing.T) {
+	testData := map[string]struct {
+		Event    *tcell.EventKey
+		Name     string
+		Expected string
+	}{
+		"ctrl":     {tcell.NewEventKey(tcell.KeyRune, 's'-'a'+1, 0), "Ctrl-S", "Ctrl-S"},
+		"alt-rune": {tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModAlt), "Alt-x", "Alt-x"},
+		"special":  {tcell.NewEventKey(tcell.KeyDelete, 0, 0), "delete", "Delete"},
+		"shift":    {tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModShift), "X", "X"},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			key, err := parseKeyStroke(tt.Name)
+			require.NoError(t, err)
+			require.Equal(t, key, keyStrokeFromEvent(tt.Event))
+			require.Equal(t, tt.Expected, key.String())
+		})
+	}
+}
+
+func TestLoadKeyBindings(t *testing.T) {
+	ed := newTestEditor(t)
+
+	ed.addNewBuffer()
+
+	fname := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(fname, []byte(`{"Alt-a": "gotoBOL", "Ctrl-E": "", "F3": "gotoEOL"}`), 0644))
+
+	require.NoError(t, ed.loadKeyBindings(fname))
+
+	ed.bufs[ed.bufIdx].text = newPieceTable([]byte("hello"))
+	ed.curView().x = 2
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlE, 0, 0))
+	require.Equal(t, 2, ed.curView().x)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyF3, 0, 0))
+	require.Equal(t, 5, ed.curView().x)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModAlt))
+	require.Equal(t, 0, ed.curView().x)
+	require.Equal(t, [][]rune{[]rune("hello")}, ed.bufs[ed.bufIdx].lines())
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyF3, 0, 0), tcell.NewEventKey(tcell.KeyCtrlA, 0, 0))
+	require.Equal(t, 0, ed.curView().x)
+
+	require.NoError(t, os.WriteFile(fname, []byte(`{"Hyper-Q": "quit", "Ctrl-S": "doesNotExist"}`), 0644))
+
+	err := ed.loadKeyBindings(fname)
+	require.Error(t, err)
+	require.Len(t, err.(configErrors), 2)
+
+	require.NoError(t, ed.loadKeyBindings(filepath.Join(t.TempDir(), "does-not-exist.json")))
+}
+
+func TestParseKeySequence(t *testing.T) {
+	seq, err := parseKeySequence("Alt-X  Ctrl-S")
+	require.NoError(t, err)
+	require.Equal(t, keySequence{{key: tcell.KeyRune, r: 'X', mod: tcell.ModAlt}, {key: tcell.KeyCtrlS}}, seq)
+	require.Equal(t, "Alt-X Ctrl-S", seq.String())
+
+	_, err = parseKeySequence("Alt-X Hyper-S")
+	require.Error(t, err)
+
+	_, err = parseKeySequence(" ")
+	require.Error(t, err)
+}
+
+func TestKeyMappingsConflicts(t *testing.T) {
+	ed := newTestEditor(t)
+
+	_, _, err := ed.keyMappings([]keyBinding{{"Ctrl-X", "cutText"}, {"Ctrl-X 2", "save"}})
+	require.Error(t, err)
+
+	_, _, err = ed.keyMappings([]keyBinding{{"Ctrl-X 2", "save"}, {"Ctrl-X", "cutText"}})
+	require.Error(t, err)
+
+	_, _, err = ed.keyMappings([]keyBinding{{"Ctrl-X 2", "save"}, {"Ctrl-X", ""}, {"Ctrl-X 3", "quit"}})
+	require.NoError(t, err)
+
+	ops, keys, err := ed.keyMappings([]keyBinding{{"Ctrl-X", "cutText"}, {"Ctrl-X", ""}, {"Ctrl-X Ctrl-X", "save"}})
+	require.NoError(t, err)
+	require.Len(t, ops, 1)
+	require.Equal(t, "save", keys.lookup(ops[0].Keys).op.Cmd)
+}
+
+func TestKeySequences(t *testing.T) {
+	ed := newTestEditor(t)
+
+	ed.addNewBuffer()
+
+	fname := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(fname, []byte(`{"Alt-X a": "gotoBOL", "Alt-X Ctrl-E": "gotoEOL"}`), 0644))
+
+	require.NoError(t, ed.loadKeyBindings(fname))
+
+	ed.bufs[ed.bufIdx].text = newPieceTable([]byte("hello"))
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt))
+	require.Equal(t, keySequence{{key: tcell.KeyRune, r: 'X', mod: tcell.ModAlt}}, ed.pendingKeys)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlE, 0, 0))
+	require.Empty(t, ed.pendingKeys)
+	require.Equal(t, 5, ed.curView().x)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyCtrlG, 0, 0))
+	require.Empty(t, ed.pendingKeys)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'a', 0))
+	require.Equal(t, [][]rune{[]rune("helloa")}, ed.bufs[ed.bufIdx].lines())
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, 'q', 0))
+	require.Empty(t, ed.pendingKeys)
+	require.Equal(t, [][]rune{[]rune("helloa")}, ed.bufs[ed.bufIdx].lines())
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, 'a', 0))
+	require.Equal(t, 0, ed.curView().x)
+}
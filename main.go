@@ -6,16 +6,49 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/gdamore/tcell/v2"
 )
 
 const tabWidth = 8
 
+// configFile returns the path of the configuration file name in exa's
+// configuration directory, or an empty string if there is none.
+func configFile(name string) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "exa", name)
+}
+
+// stateFile returns the path of name in exa's directory for persistent
+// state, $XDG_STATE_HOME/exa or ~/.local/state/exa, or an empty string if
+// there is none.
+func stateFile(name string) string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "exa", name)
+}
+
 func main() {
 	log.SetOutput(io.Discard)
 
 	logFile := flag.String("log", "", "if not empty, debug log output is written to this file")
+	keysFile := flag.String("keys", configFile("keys.json"), "if not empty, key bindings are loaded from this file")
+	undoDir := flag.String("undodir", stateFile("undo"), "if not empty, undo history is kept across sessions in this directory")
+	mouse := flag.Bool("mouse", true, "if true, clicking with the mouse moves the cursor")
+	tabBar := flag.Bool("tabbar", false, "if true, a tab bar lists the open buffers")
+	themeName := flag.String("theme", "default", "name of the color theme")
+	themesDir := flag.String("themes", configFile("themes"), "if not empty, color themes are loaded from this directory")
+	syntaxDir := flag.String("syntax", configFile("syntax"), "if not empty, language definitions for syntax highlighting are loaded from this directory")
 
 	flag.Parse()
 
@@ -39,6 +72,34 @@ func main() {
 	log.Printf("Created new screen %dx%d charset = %s", width, height, scr.CharacterSet())
 
 	ed := newEditor(scr)
+	ed.undoDir = *undoDir
+	ed.tabBar = *tabBar
+
+	if *keysFile != "" {
+		if err := ed.loadKeyBindings(*keysFile); err != nil {
+			fmt.Printf("Failed to load key bindings from %s:\n%v\n", *keysFile, err)
+			os.Exit(1)
+		}
+	}
+
+	if *syntaxDir != "" {
+		if err := ed.loadLanguages(*syntaxDir); err != nil {
+			fmt.Printf("Failed to load language definitions from %s:\n%v\n", *syntaxDir, err)
+			os.Exit(1)
+		}
+	}
+
+	if *themesDir != "" {
+		if err := ed.loadThemes(*themesDir); err != nil {
+			fmt.Printf("Failed to load themes from %s:\n%v\n", *themesDir, err)
+			os.Exit(1)
+		}
+	}
+
+	if err := ed.setTheme(*themeName); err != nil {
+		fmt.Printf("Failed to set theme: %v\n", err)
+		os.Exit(1)
+	}
 
 	for _, arg := range flag.Args() {
 		if err := ed.loadBufferFromFile(arg); err != nil {
@@ -59,8 +120,21 @@ func main() {
 	}
 	defer scr.Fini()
 
+	// the number of colors is only known once the screen is initialized.
+	ed.applyTheme()
+
+	if *mouse {
+		scr.EnableMouse(tcell.MouseButtonEvents)
+	}
+
+	ed.startSwapWriter()
+	stopTicker := ed.startTicker(tickInterval)
+
 	log.Printf("Starting editor input loop...")
 	ed.inputLoop()
 
+	stopTicker()
+	ed.stopSwapWriter()
+
 	log.Printf("Quitting")
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"log"
+	"sort"
+)
+
+// lineMark is a set of markers of a line that are shown in the gutter, see
+// gutter.go.
+type lineMark uint8
+
+const (
+	markModified   lineMark = 1 << iota // the line was changed since the buffer was last saved.
+	markBookmark                        // the line was bookmarked by the user.
+	markDiagnostic                      // there is a diagnostic like a compiler error for the line.
+)
+
+func (buf *buffer) setMark(y int, m lineMark) {
+	if buf.marks == nil {
+		buf.marks = map[int]lineMark{}
+	}
+	buf.marks[y] |= m
+}
+
+func (buf *buffer) clearMark(y int, m lineMark) {
+	if mark := buf.marks[y] &^ m; mark != 0 {
+		buf.marks[y] = mark
+	} else {
+		delete(buf.marks, y)
+	}
+}
+
+// clearMarks removes the markers m from all lines.
+func (buf *buffer) clearMarks(m lineMark) {
+	for y := range buf.marks {
+		buf.clearMark(y, m)
+	}
+}
+
+func (buf *buffer) hasMark(y int, m lineMark) bool {
+	return buf.marks[y]&m != 0
+}
+
+// marksInserted moves the markers when n line breaks were inserted at position
+// x of line y, and marks the changed lines as modified. Lines of results
+// buffers aren't marked, their lines are added by the search.
+func (buf *buffer) marksInserted(y, x, n int) {
+	if n > 0 {
+		// the markers are moved starting with the last one, so that none is
+		// moved onto one that hasn't been moved yet.
+		moved := buf.marksBelow(y, x == 0)
+		for idx := len(moved) - 1; idx >= 0; idx-- {
+			my := moved[idx]
+			buf.marks[my+n] = buf.marks[my]
+			delete(buf.marks, my)
+		}
+	}
+
+	if buf.results != nil {
+		return
+	}
+	for i := y; i <= y+n; i++ {
+		buf.setMark(i, markModified)
+	}
+}
+
+// marksRemoved moves the markers when the text from line y up to line endY
+// was removed. The markers of removed line breaks are kept by line y.
+func (buf *buffer) marksRemoved(y, endY int) {
+	if endY > y {
+		// the markers are moved starting with the first one, so that none is
+		// moved onto one that hasn't been moved yet.
+		for _, my := range buf.marksBelow(y, false) {
+			m := buf.marks[my]
+			delete(buf.marks, my)
+			if my > endY {
+				buf.marks[my-(endY-y)] = m
+			} else {
+				buf.marks[y] |= m
+			}
+		}
+	}
+
+	if buf.results == nil {
+		buf.setMark(y, markModified)
+	}
+}
+
+// marksBelow returns the lines after line y that have markers, and line y
+// itself if withY is true, in ascending order.
+func (buf *buffer) marksBelow(y int, withY bool) []int {
+	var lines []int
+	for my := range buf.marks {
+		if my > y || (withY && my == y) {
+			lines = append(lines, my)
+		}
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// setDiagnostics marks the lines ys as having diagnostics and removes the
+// marker from all other lines. Like the other markers, it moves with the text
+// until the diagnostics are set again.
+func (buf *buffer) setDiagnostics(ys []int) {
+	buf.clearMarks(markDiagnostic)
+	for _, y := range ys {
+		if y >= 0 && y < buf.lineCount() {
+			buf.setMark(y, markDiagnostic)
+		}
+	}
+}
+
+// toggleBookmark sets or removes a bookmark in the current line.
+func (e *editor) toggleBookmark() {
+	v := e.curView()
+	curBuf := v.buf
+
+	y := v.curLineIdx()
+
+	if curBuf.hasMark(y, markBookmark) {
+		curBuf.clearMark(y, markBookmark)
+		log.Printf("toggleBookmark: removed bookmark in line %d", y)
+		e.showError("Bookmark removed")
+		return
+	}
+
+	curBuf.setMark(y, markBookmark)
+	log.Printf("toggleBookmark: set bookmark in line %d", y)
+	e.showError("Bookmark set")
+}
+
+// nextBookmark goes to the next bookmarked line, wrapping around at the end of
+// the buffer.
+func (e *editor) nextBookmark() {
+	v := e.curView()
+	curBuf := v.buf
+
+	y := v.curLineIdx()
+	for i := 1; i <= curBuf.lineCount(); i++ {
+		next := (y + i) % curBuf.lineCount()
+		if curBuf.hasMark(next, markBookmark) {
+			log.Printf("nextBookmark: going to line %d", next)
+			v.x = 0
+			v.gotoLine(next)
+			e.updateSelectedTextPos(v)
+			curBuf.historyFinishOp()
+			return
+		}
+	}
+
+	log.Printf("nextBookmark: no bookmarks")
+	e.showError("No bookmarks")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// handleMouse handles mouse events: clicking with the left button moves the
+// cursor to the clicked text, in the split that was clicked. Clicking a tab of
+// the tab bar switches to its buffer, dragging it moves the tab.
+func (e *editor) handleMouse(ev *tcell.EventMouse) {
+	x, y := ev.Position()
+
+	if ev.Buttons() == tcell.ButtonNone && e.draggedTab != nil {
+		log.Printf("handleMouse: release at %d/%d", x, y)
+		e.dropTab(x, y)
+		return
+	}
+
+	if ev.Buttons()&tcell.Button1 == 0 {
+		return
+	}
+
+	log.Printf("handleMouse: click at %d/%d", x, y)
+
+	if e.tabBar && y == 0 {
+		e.runChange(func() {
+			e.clickTab(x)
+		})
+		return
+	}
+
+	e.runChange(func() {
+		e.clickAt(x, y)
+	})
+}
+
+// clickAt makes the split at column x of screen row y the current one, and
+// moves the cursor to the text that is displayed there.
+func (e *editor) clickAt(x, y int) {
+	e.arrange()
+
+	var win *window
+	for _, w := range e.layout.windows() {
+		if x >= w.left && x < w.left+w.width && y >= w.top && y < w.top+w.height {
+			win = w
+		}
+	}
+	if win == nil {
+		log.Printf("clickAt: not within a split")
+		return
+	}
+
+	if win != e.win {
+		log.Printf("clickAt: going to split at %d/%d", win.left, win.top)
+		e.activate(win)
+	}
+
+	if y == win.top+win.height-1 {
+		log.Printf("clickAt: clicked the status line")
+		return
+	}
+
+	v := e.curView()
+	curBuf := v.buf
+
+	// clicks in the gutter go to the start of the line.
+	lineIdx, idx := v.textPosition(x-win.left-e.gutterWidth(curBuf), y-win.top)
+
+	// the clicked text is on the screen, so the view doesn't move.
+	v.y = lineIdx - v.offset
+	v.x = idx
+
+	log.Printf("clickAt: y = %d offset = %d x = %d", v.y, v.offset, v.x)
+
+	e.updateSelectedTextPos(v)
+
+	curBuf.historyFinishOp()
+}
+
+// textPosition returns the line and the index of the rune in it that is
+// displayed at column x of row y of the split of v. Positions after
+// the end of a line or of the text are corrected to its end.
+func (v *view) textPosition(x, y int) (lineIdx int, idx int) {
+	if v.wrap == wrapNone {
+		lineIdx = v.offset + y
+		if lineIdx >= v.buf.lineCount() {
+			lineIdx = v.buf.lineCount() - 1
+		}
+		return lineIdx, columnIndex(v.buf.line(lineIdx), x+v.xOffset)
+	}
+
+	lineIdx, row := v.offset, v.rowOffset
+	for ; y > 0; y-- {
+		if row+1 < len(v.wrapRows(lineIdx)) {
+			row++
+		} else if lineIdx+1 < v.buf.lineCount() {
+			lineIdx, row = lineIdx+1, 0
+		} else {
+			break
+		}
+	}
+
+	return lineIdx, rowIndex(v.buf.line(lineIdx), v.wrapRows(lineIdx), row, x)
+}
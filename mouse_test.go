@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClickMovesCursor(t *testing.T) {
+	testData := map[string]struct {
+		wrap    wrapMode
+		xOffset int
+		x, y    int
+		expectY int
+		expectX int
+	}{
+		"first line":           {wrapNone, 0, 3, 0, 0, 3},
+		"second line":          {wrapNone, 0, 2, 1, 1, 2},
+		"after end of line":    {wrapNone, 0, 20, 1, 1, 5},
+		"after end of text":    {wrapNone, 0, 2, 5, 1, 2},
+		"scrolled sideways":    {wrapNone, 50, 3, 0, 0, 53},
+		"wrapped row":          {wrapChars, 0, 3, 1, 0, 43},
+		"line after wrapped":   {wrapChars, 0, 3, 2, 1, 3},
+		"status line":          {wrapNone, 0, 3, 8, 0, 0},
+		"wrapped, end of text": {wrapChars, 0, 30, 7, 1, 5},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			ed := newTestEditor(t, strings.Repeat("a", 60)+"\nshort")
+			v := ed.curView()
+			v.wrap, v.wrapWidth, v.xOffset = tt.wrap, 40, tt.xOffset
+
+			require.NoError(t, ed.scr.PostEvent(tcell.NewEventMouse(tt.x, tt.y, tcell.Button1, 0)))
+			ed.handleEvent()
+
+			require.Equal(t, []int{tt.expectY, tt.expectX}, []int{v.curLineIdx(), v.x})
+		})
+	}
+}
+
+func TestClickExtendsSelection(t *testing.T) {
+	ed := newTestEditor(t, "foo bar\nbaz")
+	v := ed.curView()
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlSpace, 0, 0))
+	require.NoError(t, ed.scr.PostEvent(tcell.NewEventMouse(2, 1, tcell.Button1, 0)))
+	ed.handleEvent()
+
+	require.True(t, v.selecting)
+	require.Equal(t, []int{1, 2}, []int{v.endY, v.endX})
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"unicode/utf8"
+)
+
+// pieceTable stores text as a sequence of pieces that refer either to the
+// original content, which is never modified, or to an append-only buffer that
+// holds all inserted text. All positions are byte offsets into the text.
+type pieceTable struct {
+	original []byte
+	added    []byte
+	pieces   []piece
+	size     int
+
+	// lineStarts caches the byte offsets at which lines start. The offsets of
+	// all lines after shiftLine are too small by shiftDelta: the shift is only
+	// applied to the cache once an edit happens on another line, so that
+	// typing within a line doesn't require updating the offsets of all
+	// following lines on every key stroke.
+	lineStarts []int
+	shiftLine  int
+	shiftDelta int
+
+	// lastIdx is the piece that findPiece found last, which starts at offset
+	// lastPos. Lookups start there, as they tend to be close to each other,
+	// e.g. when the lines on the screen are drawn.
+	lastIdx int
+	lastPos int
+}
+
+type piece struct {
+	added  bool
+	start  int
+	length int
+}
+
+func newPieceTable(data []byte) *pieceTable {
+	pt := &pieceTable{
+		original:   data,
+		size:       len(data),
+		lineStarts: []int{0},
+	}
+
+	if len(data) > 0 {
+		pt.pieces = []piece{{start: 0, length: len(data)}}
+	}
+
+	for idx, b := range data {
+		if b == '\n' {
+			pt.lineStarts = append(pt.lineStarts, idx+1)
+		}
+	}
+
+	return pt
+}
+
+func (pt *pieceTable) source(p piece) []byte {
+	if p.added {
+		return pt.added[p.start : p.start+p.length]
+	}
+	return pt.original[p.start : p.start+p.length]
+}
+
+func (pt *pieceTable) len() int {
+	return pt.size
+}
+
+func (pt *pieceTable) lineCount() int {
+	return len(pt.lineStarts)
+}
+
+func (pt *pieceTable) lineStart(y int) int {
+	if y > pt.shiftLine {
+		return pt.lineStarts[y] + pt.shiftDelta
+	}
+	return pt.lineStarts[y]
+}
+
+// lineEnd returns the offset of the newline that ends line y, or the size of
+// the text for the last line.
+func (pt *pieceTable) lineEnd(y int) int {
+	if y+1 < len(pt.lineStarts) {
+		return pt.lineStart(y+1) - 1
+	}
+	return pt.size
+}
+
+// lineOf returns the line that contains offset off.
+func (pt *pieceTable) lineOf(off int) int {
+	return sort.Search(len(pt.lineStarts), func(y int) bool {
+		return pt.lineStart(y) > off
+	}) - 1
+}
+
+// findPiece returns the index of the piece that contains offset off and the
+// offset at which that piece starts. For the offset at the end of the text, it
+// returns the number of pieces and the size of the text.
+func (pt *pieceTable) findPiece(off int) (idx, pos int) {
+	idx, pos = pt.lastIdx, pt.lastPos
+	for idx > 0 && pos > off {
+		idx--
+		pos -= pt.pieces[idx].length
+	}
+	for idx < len(pt.pieces) && pos+pt.pieces[idx].length <= off {
+		pos += pt.pieces[idx].length
+		idx++
+	}
+
+	pt.lastIdx, pt.lastPos = idx, pos
+	return idx, pos
+}
+
+// slice returns the text between the offsets start and end. The result may
+// refer to the table's internal storage and must not be modified.
+func (pt *pieceTable) slice(start, end int) []byte {
+	var result []byte
+
+	idx, pos := pt.findPiece(start)
+	for ; idx < len(pt.pieces) && pos < end; idx++ {
+		p := pt.pieces[idx]
+		pEnd := pos + p.length
+		from, to := 0, p.length
+		if start > pos {
+			from = start - pos
+		}
+		if end < pEnd {
+			to = end - pos
+		}
+		data := pt.source(p)[from:to]
+		if result == nil && to-from == end-start {
+			return data
+		}
+		result = append(result, data...)
+		pos = pEnd
+	}
+
+	return result
+}
+
+func (pt *pieceTable) lineBytes(y int) []byte {
+	return pt.slice(pt.lineStart(y), pt.lineEnd(y))
+}
+
+// line returns the runes of line y. The returned slice can be modified freely.
+func (pt *pieceTable) line(y int) []rune {
+	b := pt.lineBytes(y)
+
+	runes := make([]rune, 0, utf8.RuneCount(b))
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		runes = append(runes, r)
+		b = b[size:]
+	}
+
+	return runes
+}
+
+// lineLen returns the number of runes in line y.
+func (pt *pieceTable) lineLen(y int) int {
+	return utf8.RuneCount(pt.lineBytes(y))
+}
+
+// offset converts the position of rune x in line y to a byte offset.
+func (pt *pieceTable) offset(y, x int) int {
+	off := pt.lineStart(y)
+
+	b := pt.lineBytes(y)
+	for i := 0; i < x && len(b) > 0; i++ {
+		_, size := utf8.DecodeRune(b)
+		off += size
+		b = b[size:]
+	}
+
+	return off
+}
+
+func (pt *pieceTable) shiftLines(y, delta int) {
+	if pt.shiftDelta != 0 && pt.shiftLine != y {
+		pt.flushShift()
+	}
+	pt.shiftLine = y
+	pt.shiftDelta += delta
+}
+
+func (pt *pieceTable) flushShift() {
+	if pt.shiftDelta == 0 {
+		return
+	}
+	for y := pt.shiftLine + 1; y < len(pt.lineStarts); y++ {
+		pt.lineStarts[y] += pt.shiftDelta
+	}
+	pt.shiftDelta = 0
+}
+
+func (pt *pieceTable) insert(off int, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	y := pt.lineOf(off)
+
+	if n := bytes.Count(data, []byte{'\n'}); n > 0 {
+		pt.flushShift()
+
+		newStarts := make([]int, 0, n)
+		for idx, b := range data {
+			if b == '\n' {
+				newStarts = append(newStarts, off+idx+1)
+			}
+		}
+
+		for i := y + 1; i < len(pt.lineStarts); i++ {
+			pt.lineStarts[i] += len(data)
+		}
+		pt.lineStarts = append(pt.lineStarts[:y+1], append(newStarts, pt.lineStarts[y+1:]...)...)
+	} else {
+		pt.shiftLines(y, len(data))
+	}
+
+	p := piece{added: true, start: len(pt.added), length: len(data)}
+	pt.added = append(pt.added, data...)
+	pt.size += len(data)
+
+	// the pieces before the one that is changed keep their offsets, so the
+	// piece found last stays valid.
+	idx, pos := pt.findPiece(off)
+	if idx > 0 && off == pos {
+		// text that is typed continuously extends the piece it was appended to.
+		if prev := &pt.pieces[idx-1]; prev.added && prev.start+prev.length == p.start {
+			pt.lastIdx, pt.lastPos = idx-1, pos-prev.length
+			prev.length += p.length
+			return
+		}
+	}
+
+	if off == pos {
+		pt.pieces = append(pt.pieces[:idx], append([]piece{p}, pt.pieces[idx:]...)...)
+		return
+	}
+
+	cur := pt.pieces[idx]
+	left := piece{added: cur.added, start: cur.start, length: off - pos}
+	right := piece{added: cur.added, start: cur.start + off - pos, length: pos + cur.length - off}
+	pt.pieces = append(pt.pieces[:idx], append([]piece{left, p, right}, pt.pieces[idx+1:]...)...)
+}
+
+func (pt *pieceTable) delete(off, n int) {
+	if n <= 0 {
+		return
+	}
+
+	end := off + n
+
+	startLine, endLine := pt.lineOf(off), pt.lineOf(end)
+	if endLine > startLine {
+		pt.flushShift()
+		pt.lineStarts = append(pt.lineStarts[:startLine+1], pt.lineStarts[endLine+1:]...)
+		for y := startLine + 1; y < len(pt.lineStarts); y++ {
+			pt.lineStarts[y] -= n
+		}
+	} else {
+		pt.shiftLines(startLine, -n)
+	}
+
+	// only the pieces from the one that contains off up to the one that
+	// contains end are cut, the pieces before them keep their offsets.
+	idx, pos := pt.findPiece(off)
+	last, lastEnd := idx, pos
+	for last < len(pt.pieces) && lastEnd < end {
+		lastEnd += pt.pieces[last].length
+		last++
+	}
+
+	var rest []piece
+	if pos < off {
+		cur := pt.pieces[idx]
+		rest = append(rest, piece{added: cur.added, start: cur.start, length: off - pos})
+	}
+	if lastEnd > end {
+		cur := pt.pieces[last-1]
+		rest = append(rest, piece{added: cur.added, start: cur.start + cur.length - (lastEnd - end), length: lastEnd - end})
+	}
+
+	pt.pieces = append(pt.pieces[:idx], append(rest, pt.pieces[last:]...)...)
+	pt.size -= n
+}
+
+// snapshot returns a copy of the table that can be written while the
+// original is edited. Only the pieces need to be copied because the bytes
+// they refer to are never modified. The copy has no line offsets.
+func (pt *pieceTable) snapshot() *pieceTable {
+	return &pieceTable{
+		original: pt.original,
+		added:    pt.added[:len(pt.added):len(pt.added)],
+		pieces:   append([]piece(nil), pt.pieces...),
+		size:     pt.size,
+	}
+}
+
+func (pt *pieceTable) writeTo(w io.Writer) error {
+	for _, p := range pt.pieces {
+		if _, err := w.Write(pt.source(p)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pt *pieceTable) bytes() []byte {
+	data := make([]byte, 0, pt.size)
+	for _, p := range pt.pieces {
+		data = append(data, pt.source(p)...)
+	}
+	return data
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func checkPieceTable(t *testing.T, pt *pieceTable, expected string) {
+	t.Helper()
+
+	require.Equal(t, expected, string(pt.bytes()))
+	require.Equal(t, len(expected), pt.len())
+
+	lines := strings.Split(expected, "\n")
+	require.Equal(t, len(lines), pt.lineCount())
+	for y, line := range lines {
+		require.Equal(t, []rune(line), pt.line(y), "line %d", y)
+		require.Equal(t, len([]rune(line)), pt.lineLen(y), "line %d", y)
+	}
+}
+
+func TestPieceTable(t *testing.T) {
+	pt := newPieceTable([]byte("hello\nworld"))
+	checkPieceTable(t, pt, "hello\nworld")
+
+	pt.insert(pt.offset(0, 5), []byte(", dear"))
+	checkPieceTable(t, pt, "hello, dear\nworld")
+
+	pt.insert(pt.offset(1, 0), []byte("new\n"))
+	checkPieceTable(t, pt, "hello, dear\nnew\nworld")
+
+	pt.delete(pt.offset(0, 5), pt.offset(1, 0)-pt.offset(0, 5))
+	checkPieceTable(t, pt, "hellonew\nworld")
+
+	pt.insert(pt.len(), []byte("\n例子"))
+	checkPieceTable(t, pt, "hellonew\nworld\n例子")
+
+	require.Equal(t, len("hellonew\nworld\n例"), pt.offset(2, 1))
+
+	pt.delete(pt.offset(2, 0), len("例"))
+	checkPieceTable(t, pt, "hellonew\nworld\n子")
+
+	pt.delete(0, pt.len())
+	checkPieceTable(t, pt, "")
+}
+
+func TestPieceTableEmpty(t *testing.T) {
+	pt := newPieceTable(nil)
+	checkPieceTable(t, pt, "")
+	require.Equal(t, []rune{}, pt.line(0))
+
+	pt.insert(0, []byte("\n"))
+	checkPieceTable(t, pt, "\n")
+}
+
+func TestPieceTableTyping(t *testing.T) {
+	pt := newPieceTable([]byte("ab\ncd\nef"))
+
+	for i, r := range "xyz" {
+		pt.insert(pt.offset(1, 1+i), []byte(string(r)))
+	}
+	checkPieceTable(t, pt, "ab\ncxyzd\nef")
+	require.Len(t, pt.pieces, 3, "continuous typing should extend a single piece")
+
+	pt.insert(pt.offset(0, 0), []byte("0"))
+	checkPieceTable(t, pt, "0ab\ncxyzd\nef")
+
+	pt.delete(pt.offset(2, 0), 1)
+	pt.delete(pt.offset(1, 3), 1)
+	checkPieceTable(t, pt, "0ab\ncxyd\nf")
+}
+
+func TestPieceTableRandomEdits(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+
+	alphabet := []string{"a", "b", "\n", "ä", "例", " "}
+
+	expected := "initial\ntext\n"
+	pt := newPieceTable([]byte(expected))
+
+	for i := 0; i < 2000; i++ {
+		runes := []rune(expected)
+		pos := rnd.Intn(len(runes) + 1)
+		off := len(string(runes[:pos]))
+
+		if rnd.Intn(3) > 0 || len(runes) == 0 {
+			var ins string
+			for n := rnd.Intn(4) + 1; n > 0; n-- {
+				ins += alphabet[rnd.Intn(len(alphabet))]
+			}
+			pt.insert(off, []byte(ins))
+			expected = expected[:off] + ins + expected[off:]
+		} else {
+			end := pos + rnd.Intn(len(runes)-pos+1)
+			n := len(string(runes[pos:end]))
+			pt.delete(off, n)
+			expected = expected[:off] + expected[off+n:]
+		}
+
+		checkPieceTable(t, pt, expected)
+	}
+}
+
+func TestPieceTableWriteTo(t *testing.T) {
+	pt := newPieceTable([]byte("hello\nworld"))
+	pt.insert(5, []byte("!"))
+
+	var buf bytes.Buffer
+	require.NoError(t, pt.writeTo(&buf))
+	require.Equal(t, "hello!\nworld", buf.String())
+}
+
+func largeText(lines int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < lines; i++ {
+		fmt.Fprintf(&buf, "%08d: the quick brown fox jumps over the lazy dog, 例子 %d\n", i, i*i)
+	}
+	return buf.Bytes()
+}
+
+const benchmarkLines = 1000000
+
+func BenchmarkPieceTableLoad(b *testing.B) {
+	data := largeText(benchmarkLines)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		newPieceTable(data)
+	}
+}
+
+func BenchmarkPieceTableInsertRandom(b *testing.B) {
+	pt := newPieceTable(largeText(benchmarkLines))
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		pt.insert(pt.offset(rnd.Intn(pt.lineCount()), 3), []byte("x"))
+	}
+}
+
+func BenchmarkPieceTableInsertTyping(b *testing.B) {
+	pt := newPieceTable(largeText(benchmarkLines))
+	y := pt.lineCount() / 2
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		pt.insert(pt.offset(y, 10+i), []byte("x"))
+	}
+}
+
+func BenchmarkPieceTableInsertLine(b *testing.B) {
+	pt := newPieceTable(largeText(benchmarkLines))
+	y := pt.lineCount() / 2
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		pt.insert(pt.offset(y+i, 0), []byte("\n"))
+	}
+}
+
+func BenchmarkPieceTableDelete(b *testing.B) {
+	pt := newPieceTable(largeText(benchmarkLines))
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		pt.delete(pt.offset(rnd.Intn(pt.lineCount()-1), 3), 1)
+	}
+}
+
+func BenchmarkPieceTableLine(b *testing.B) {
+	pt := newPieceTable(largeText(benchmarkLines))
+	for i := 0; i < 1000; i++ {
+		pt.insert(pt.offset(i*500, 3), []byte("x"))
+	}
+	rnd := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		pt.line(rnd.Intn(pt.lineCount()))
+	}
+}
+
+func BenchmarkPieceTableScreen(b *testing.B) {
+	pt := newPieceTable(largeText(benchmarkLines))
+	for i := 0; i < 1000; i++ {
+		pt.insert(pt.offset(i*500, 3), []byte("x"))
+	}
+	y := pt.lineCount() / 2
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		for row := 0; row < 50; row++ {
+			pt.line(y + row)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+)
+
+// resultLoc is the location that a line of a results buffer refers to.
+type resultLoc struct {
+	buf   *buffer // the buffer of the result, nil if it wasn't open.
+	fname string
+	y, x  int
+}
+
+// addResultsBuffer adds a read-only buffer that lists search results below
+// title, and switches to it. Results are added with addResult.
+func (e *editor) addResultsBuffer(title string) *buffer {
+	buf := newBuffer([]byte(title))
+	buf.readOnly = true
+	buf.results = map[int]resultLoc{}
+
+	e.bufs = append(e.bufs, buf)
+	e.bufIdx = len(e.bufs) - 1
+
+	return buf
+}
+
+// addResult appends line to the results buffer buf. Pressing Enter on the line
+// goes to loc, unless it is nil.
+func (buf *buffer) addResult(line string, loc *resultLoc) {
+	y := buf.lineCount()
+
+	// results aren't edits, so they bypass the edit history.
+	buf.insert(y-1, buf.lineLen(y-1), [][]rune{{}, []rune(line)}, nil)
+
+	if loc != nil {
+		buf.results[y] = *loc
+	}
+}
+
+// gotoResult goes to the location of the result in the current line of the
+// results buffer shown in v. Results in files that aren't open anymore open
+// them again.
+func (e *editor) gotoResult(v *view) {
+	loc, ok := v.buf.results[v.curLineIdx()]
+	if !ok {
+		e.showError("No result in this line")
+		return
+	}
+
+	idx := -1
+	for i, b := range e.bufs {
+		if b == loc.buf {
+			idx = i
+			break
+		}
+		if idx < 0 && loc.fname != "" && b.fname != "" && sameFile(b.fname, loc.fname) {
+			idx = i
+		}
+	}
+
+	if idx < 0 {
+		if loc.fname == "" {
+			e.showError("Buffer was closed")
+			return
+		}
+		if err := e.loadBufferFromFile(loc.fname); err != nil {
+			log.Printf("gotoResult: loading file %q failed: %v", loc.fname, err)
+			e.showError("Couldn't open file: %v", err)
+			return
+		}
+		idx = len(e.bufs) - 1
+	}
+
+	log.Printf("gotoResult: going to buffer %d line %d col %d", idx, loc.y, loc.x)
+
+	e.bufIdx = idx
+	e.arrange()
+
+	e.curView().setCursorState(cursorState{y: loc.y, x: loc.x})
+}
+
+// sameFile returns true if the file names a and b refer to the same file.
+func sameFile(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return absA == absB
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// writeFileAtomically writes a file by writing its content to a temporary
+// file in the same directory, which then replaces the original file. If
+// fname is a symbolic link, the file it points to is replaced instead. The
+// permissions and, where possible, the ownership of an existing file are
+// kept, new files are created with permissions perm. The temporary file is
+// removed if anything fails.
+func writeFileAtomically(fname string, perm os.FileMode, write func(w io.Writer) error) (err error) {
+	fname = resolveSymlinks(fname)
+
+	fi, statErr := os.Stat(fname)
+	if statErr == nil {
+		perm = fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	}
+
+	dir := filepath.Dir(fname)
+	tmpName := filepath.Join(dir, fmt.Sprintf(".tmp%x", time.Now().UnixNano()))
+
+	log.Printf("writeFileAtomically: writing %s (temporary file: %s, mode: %v)", fname, tmpName, perm)
+
+	f, err := os.OpenFile(tmpName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return fmt.Errorf("opening temporary file failed: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			f.Close()
+			if removeErr := os.Remove(tmpName); removeErr != nil {
+				log.Printf("writeFileAtomically: removing temporary file %s failed: %v", tmpName, removeErr)
+			}
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+	if err := write(w); err != nil {
+		return fmt.Errorf("writing to temporary file failed: %w", err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing to temporary file failed: %w", err)
+	}
+
+	if statErr == nil {
+		if err := copyOwner(f, fi); err != nil {
+			log.Printf("writeFileAtomically: keeping ownership of %s failed: %v", fname, err)
+		}
+		// the mode given to OpenFile is subject to the umask, and changing the owner can reset setuid and setgid bits.
+		if err := f.Chmod(perm); err != nil {
+			return fmt.Errorf("setting permissions failed: %w", err)
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("syncing temporary file failed: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing temporary file failed: %w", err)
+	}
+
+	if err := os.Rename(tmpName, fname); err != nil {
+		return fmt.Errorf("replacing %s with temporary file failed: %w", fname, err)
+	}
+
+	// the rename is only durable once the directory has been synced, too.
+	if d, err := os.Open(dir); err == nil {
+		if err := d.Sync(); err != nil {
+			log.Printf("writeFileAtomically: syncing directory %s failed: %v", dir, err)
+		}
+		d.Close()
+	}
+
+	return nil
+}
+
+// writeBytes returns a function for writeFileAtomically that writes data.
+func writeBytes(data []byte) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}
+}
+
+// resolveSymlinks follows symbolic links until it finds a path that isn't
+// one. Unlike filepath.EvalSymlinks, the final target doesn't need to exist.
+func resolveSymlinks(fname string) string {
+	for i := 0; i < 255; i++ {
+		fi, err := os.Lstat(fname)
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			return fname
+		}
+
+		target, err := os.Readlink(fname)
+		if err != nil {
+			return fname
+		}
+
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(fname), target)
+		}
+
+		log.Printf("resolveSymlinks: %s points to %s", fname, target)
+
+		fname = target
+	}
+
+	return fname
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeString(s string) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+}
+
+func requireNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+
+	matches, err := filepath.Glob(filepath.Join(dir, ".tmp*"))
+	require.NoError(t, err)
+	require.Empty(t, matches)
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "new.txt")
+
+	require.NoError(t, writeFileAtomically(fname, 0644, writeString("hello\n")))
+
+	data, err := os.ReadFile(fname)
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", string(data))
+
+	requireNoTempFiles(t, dir)
+}
+
+func TestWriteFileAtomicallyKeepsMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes aren't supported on Windows")
+	}
+
+	testData := map[string]os.FileMode{
+		"executable": 0755,
+		"private":    0600,
+		"read-only":  0444,
+	}
+
+	for testName, mode := range testData {
+		t.Run(testName, func(t *testing.T) {
+			fname := filepath.Join(t.TempDir(), "file.txt")
+			require.NoError(t, os.WriteFile(fname, []byte("old\n"), 0644))
+			require.NoError(t, os.Chmod(fname, mode))
+
+			require.NoError(t, writeFileAtomically(fname, 0644, writeString("new\n")))
+
+			fi, err := os.Stat(fname)
+			require.NoError(t, err)
+			require.Equal(t, mode, fi.Mode().Perm())
+
+			data, err := os.ReadFile(fname)
+			require.NoError(t, err)
+			require.Equal(t, "new\n", string(data))
+		})
+	}
+}
+
+func TestWriteFileAtomicallySymlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target.txt")
+	link := filepath.Join(dir, "link.txt")
+
+	require.NoError(t, os.WriteFile(target, []byte("old\n"), 0644))
+	if err := os.Symlink("target.txt", link); err != nil {
+		t.Skipf("creating symlinks isn't possible: %v", err)
+	}
+
+	require.NoError(t, writeFileAtomically(link, 0644, writeString("new\n")))
+
+	fi, err := os.Lstat(link)
+	require.NoError(t, err)
+	require.True(t, fi.Mode()&os.ModeSymlink != 0, "link was replaced by a regular file")
+
+	data, err := os.ReadFile(target)
+	require.NoError(t, err)
+	require.Equal(t, "new\n", string(data))
+
+	// a dangling symlink creates its target.
+	require.NoError(t, os.Remove(target))
+	require.NoError(t, writeFileAtomically(link, 0644, writeString("created\n")))
+
+	data, err = os.ReadFile(target)
+	require.NoError(t, err)
+	require.Equal(t, "created\n", string(data))
+
+	requireNoTempFiles(t, dir)
+}
+
+func TestWriteFileAtomicallyError(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "file.txt")
+	require.NoError(t, os.WriteFile(fname, []byte("old\n"), 0644))
+
+	errWrite := errors.New("write failed")
+	err := writeFileAtomically(fname, 0644, func(w io.Writer) error {
+		return errWrite
+	})
+	require.True(t, errors.Is(err, errWrite))
+
+	data, err := os.ReadFile(fname)
+	require.NoError(t, err)
+	require.Equal(t, "old\n", string(data))
+
+	requireNoTempFiles(t, dir)
+}
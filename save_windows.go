@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// copyOwner does nothing as file ownership isn't supported on Windows.
+func copyOwner(f *os.File, fi os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// caseMode tells how searches treat upper and lower case.
+type caseMode int
+
+const (
+	caseSensitive caseMode = iota
+	caseInsensitive
+	// smartCase ignores case unless the phrase contains upper case letters.
+	smartCase
+)
+
+func (m caseMode) String() string {
+	switch m {
+	case caseInsensitive:
+		return "ignore case"
+	case smartCase:
+		return "smart case"
+	}
+	return "match case"
+}
+
+// searchOptions control how a phrase is searched for.
+type searchOptions struct {
+	backward  bool
+	caseMode  caseMode
+	wholeWord bool // only match the phrase if it isn't part of a longer word.
+}
+
+// describe lists the options, e.g. for a prompt.
+func (opts searchOptions) describe() string {
+	desc := []string{opts.caseMode.String()}
+	if opts.wholeWord {
+		desc = append(desc, "whole words")
+	}
+	if opts.backward {
+		desc = append(desc, "backward")
+	}
+	return strings.Join(desc, ", ")
+}
+
+// toggle changes the option that belongs to the key r of the find prompts:
+// c cycles through the case modes, w toggles whole-word search and b the
+// direction. It returns false if r doesn't belong to an option.
+func (opts *searchOptions) toggle(r rune) bool {
+	switch unicode.ToLower(r) {
+	case 'c':
+		opts.caseMode = (opts.caseMode + 1) % (smartCase + 1)
+	case 'w':
+		opts.wholeWord = !opts.wholeWord
+	case 'b':
+		opts.backward = !opts.backward
+	default:
+		return false
+	}
+	return true
+}
+
+func (opts searchOptions) ignoreCase(phrase []rune) bool {
+	switch opts.caseMode {
+	case caseInsensitive:
+		return true
+	case smartCase:
+		for _, r := range phrase {
+			if unicode.IsUpper(r) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// indexAll returns the indexes of all occurrences of phrase in line,
+// including overlapping ones.
+func (opts searchOptions) indexAll(line, phrase []rune) []int {
+	if len(phrase) == 0 {
+		return nil
+	}
+
+	ignoreCase := opts.ignoreCase(phrase)
+
+	var indexes []int
+	for i := 0; i <= len(line)-len(phrase); i++ {
+		if !runesMatch(line[i:i+len(phrase)], phrase, ignoreCase) {
+			continue
+		}
+		if opts.wholeWord && ((i > 0 && isWordChar(line[i-1])) || (i+len(phrase) < len(line) && isWordChar(line[i+len(phrase)]))) {
+			continue
+		}
+		indexes = append(indexes, i)
+	}
+
+	return indexes
+}
+
+func runesMatch(a, b []rune, ignoreCase bool) bool {
+	for i := range a {
+		if a[i] == b[i] {
+			continue
+		}
+		if !ignoreCase || (unicode.ToLower(a[i]) != unicode.ToLower(b[i]) && unicode.ToUpper(a[i]) != unicode.ToUpper(b[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// find returns the next occurrence of phrase from the cursor on in the
+// direction given by opts. An occurrence right at the cursor is skipped, so
+// that repeating the search moves on to the following one.
+func (v *view) find(phrase []rune, opts searchOptions) (y, x int, found bool) {
+	if opts.backward {
+		return v.buf.findBackward(phrase, opts, v.curLineIdx(), v.x)
+	}
+	return v.buf.findForward(phrase, opts, v.curLineIdx(), v.x+1)
+}
+
+// findForward returns the first occurrence of phrase that starts at or after
+// position x of line y, wrapping around at the end of the buffer.
+func (buf *buffer) findForward(phrase []rune, opts searchOptions, y, x int) (matchY, matchX int, found bool) {
+	if len(phrase) == 0 {
+		return 0, 0, false
+	}
+
+	for i := 0; i <= buf.lineCount(); i++ {
+		lineIdx := (y + i) % buf.lineCount()
+		for _, idx := range opts.indexAll(buf.line(lineIdx), phrase) {
+			// the part of the starting line before x is searched last.
+			if (i == 0 && idx < x) || (i == buf.lineCount() && idx >= x) {
+				continue
+			}
+			return lineIdx, idx, true
+		}
+	}
+
+	return 0, 0, false
+}
+
+// findBackward returns the last occurrence of phrase that starts before
+// position x of line y, wrapping around at the start of the buffer.
+func (buf *buffer) findBackward(phrase []rune, opts searchOptions, y, x int) (matchY, matchX int, found bool) {
+	if len(phrase) == 0 {
+		return 0, 0, false
+	}
+
+	for i := 0; i <= buf.lineCount(); i++ {
+		lineIdx := (y - i + buf.lineCount()) % buf.lineCount()
+		matches := opts.indexAll(buf.line(lineIdx), phrase)
+		for j := len(matches) - 1; j >= 0; j-- {
+			idx := matches[j]
+			// the part of the starting line after x is searched last.
+			if (i == 0 && idx >= x) || (i == buf.lineCount() && idx < x) {
+				continue
+			}
+			return lineIdx, idx, true
+		}
+	}
+
+	return 0, 0, false
+}
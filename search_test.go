@@ -0,0 +1,201 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindForwardBackward(t *testing.T) {
+	buf := newBufferFromFileContent([]byte("foo bar\nbaz foo\nfoofoo\nqux"))
+
+	testData := map[string]struct {
+		backward bool
+		phrase   string
+		y, x     int
+		found    bool
+		expY     int
+		expX     int
+	}{
+		"forward at position":     {phrase: "foo", y: 0, x: 0, found: true, expY: 0, expX: 0},
+		"forward in line":         {phrase: "foo", y: 1, x: 1, found: true, expY: 1, expX: 4},
+		"forward next line":       {phrase: "foo", y: 0, x: 1, found: true, expY: 1, expX: 4},
+		"forward same line twice": {phrase: "foo", y: 2, x: 1, found: true, expY: 2, expX: 3},
+		"forward wraps":           {phrase: "bar", y: 1, x: 0, found: true, expY: 0, expX: 4},
+		"forward wraps to start":  {phrase: "bar", y: 0, x: 5, found: true, expY: 0, expX: 4},
+		"forward not found":       {phrase: "quux", y: 0, x: 0},
+		"forward empty phrase":    {phrase: "", y: 0, x: 0},
+		"backward in line":        {backward: true, phrase: "foo", y: 2, x: 3, found: true, expY: 2, expX: 0},
+		"backward previous line":  {backward: true, phrase: "foo", y: 2, x: 0, found: true, expY: 1, expX: 4},
+		"backward wraps":          {backward: true, phrase: "qux", y: 0, x: 0, found: true, expY: 3, expX: 0},
+		"backward wraps to end":   {backward: true, phrase: "bar", y: 0, x: 4, found: true, expY: 0, expX: 4},
+		"backward not found":      {backward: true, phrase: "quux", y: 3, x: 0},
+		"backward skips position": {backward: true, phrase: "baz", y: 1, x: 0, found: true, expY: 1, expX: 0},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			find := buf.findForward
+			if tt.backward {
+				find = buf.findBackward
+			}
+			y, x, found := find([]rune(tt.phrase), searchOptions{}, tt.y, tt.x)
+			require.Equal(t, tt.found, found)
+			if tt.found {
+				require.Equal(t, []int{tt.expY, tt.expX}, []int{y, x})
+			}
+		})
+	}
+}
+
+func TestSearchOptions(t *testing.T) {
+	testData := map[string]struct {
+		opts    searchOptions
+		line    string
+		phrase  string
+		indexes []int
+	}{
+		"exact":                   {line: "Foo foo FOO", phrase: "foo", indexes: []int{4}},
+		"overlapping":             {line: "aaa", phrase: "aa", indexes: []int{0, 1}},
+		"ignore case":             {opts: searchOptions{caseMode: caseInsensitive}, line: "Foo foo FOO", phrase: "foo", indexes: []int{0, 4, 8}},
+		"ignore case non-ascii":   {opts: searchOptions{caseMode: caseInsensitive}, line: "Ärger ärger", phrase: "äRGER", indexes: []int{0, 6}},
+		"smart case lower":        {opts: searchOptions{caseMode: smartCase}, line: "Foo foo FOO", phrase: "foo", indexes: []int{0, 4, 8}},
+		"smart case upper":        {opts: searchOptions{caseMode: smartCase}, line: "Foo foo FOO", phrase: "Foo", indexes: []int{0}},
+		"whole word":              {opts: searchOptions{wholeWord: true}, line: "foo foobar barfoo foo_ (foo)", phrase: "foo", indexes: []int{0, 24}},
+		"whole word at line end":  {opts: searchOptions{wholeWord: true}, line: "a foo", phrase: "foo", indexes: []int{2}},
+		"whole word and case":     {opts: searchOptions{wholeWord: true, caseMode: caseInsensitive}, line: "FOO fooBar", phrase: "foo", indexes: []int{0}},
+		"phrase longer than line": {line: "fo", phrase: "foo"},
+		"empty phrase":            {line: "foo", phrase: ""},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			require.Equal(t, tt.indexes, tt.opts.indexAll([]rune(tt.line), []rune(tt.phrase)))
+		})
+	}
+}
+
+func TestFindWrapsAround(t *testing.T) {
+	ed := newTestEditor(t, "foo\nbar\nfoo bar\nbaz")
+	buf := ed.bufs[0]
+	v := buf.lastView
+	buf.findPhrase = []rune("bar")
+
+	var positions [][]int
+	for i := 0; i < 3; i++ {
+		playKeys(t, ed, tcell.NewEventKey(tcell.KeyF3, 0, 0))
+		positions = append(positions, []int{v.curLineIdx(), v.x})
+	}
+	require.Equal(t, [][]int{{1, 0}, {2, 4}, {1, 0}}, positions)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyF3, 0, tcell.ModShift))
+	require.Equal(t, []int{2, 4}, []int{v.curLineIdx(), v.x})
+
+	// options are toggled in the prompt, and kept for find next.
+	keys := []*tcell.EventKey{
+		tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt),
+		tcell.NewEventKey(tcell.KeyRune, 'f', 0),
+		tcell.NewEventKey(tcell.KeyCtrlU, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, 'F', 0),
+		tcell.NewEventKey(tcell.KeyRune, 'c', tcell.ModAlt),
+		tcell.NewEventKey(tcell.KeyRune, 'b', tcell.ModAlt),
+		tcell.NewEventKey(tcell.KeyEnter, 0, 0),
+	}
+	postKeys(ed, keys...)
+	ed.handleEvent()
+	ed.handleEvent()
+	require.Equal(t, "F", string(buf.findPhrase))
+	require.Equal(t, searchOptions{backward: true, caseMode: caseInsensitive}, buf.findOptions)
+	require.Equal(t, []int{2, 0}, []int{v.curLineIdx(), v.x})
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyF3, 0, 0))
+	require.Equal(t, []int{0, 0}, []int{v.curLineIdx(), v.x})
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyF3, 0, 0))
+	require.Equal(t, []int{2, 0}, []int{v.curLineIdx(), v.x}, "backward search wraps around to the end")
+}
+
+func TestIncrementalFind(t *testing.T) {
+	ed := newTestEditor(t, "one two\nthree two\ntwo")
+	buf := ed.bufs[0]
+	v := buf.lastView
+
+	keys := []*tcell.EventKey{tcell.NewEventKey(tcell.KeyCtrlF, 0, 0)}
+	for _, r := range "tw" {
+		keys = append(keys, tcell.NewEventKey(tcell.KeyRune, r, 0))
+	}
+	keys = append(keys,
+		tcell.NewEventKey(tcell.KeyCtrlS, 0, 0),
+		tcell.NewEventKey(tcell.KeyCtrlS, 0, 0),
+		tcell.NewEventKey(tcell.KeyCtrlR, 0, 0),
+		tcell.NewEventKey(tcell.KeyEnter, 0, 0),
+	)
+	postKeys(ed, keys...)
+	ed.handleEvent()
+
+	require.Equal(t, []int{1, 6}, []int{v.curLineIdx(), v.x})
+	require.Equal(t, "tw", string(buf.findPhrase))
+	require.Nil(t, buf.highlightPhrase)
+
+	// Esc returns to where the search started.
+	keys = []*tcell.EventKey{tcell.NewEventKey(tcell.KeyCtrlF, 0, 0), tcell.NewEventKey(tcell.KeyCtrlU, 0, 0)}
+	for _, r := range "three" {
+		keys = append(keys, tcell.NewEventKey(tcell.KeyRune, r, 0))
+	}
+	keys = append(keys, tcell.NewEventKey(tcell.KeyESC, 0, 0))
+	postKeys(ed, keys...)
+	ed.handleEvent()
+
+	require.Equal(t, []int{1, 6}, []int{v.curLineIdx(), v.x})
+	require.Equal(t, "tw", string(buf.findPhrase))
+
+	// editing the previous phrase doesn't change it until the search is done.
+	postKeys(ed,
+		tcell.NewEventKey(tcell.KeyCtrlF, 0, 0),
+		tcell.NewEventKey(tcell.KeyLeft, 0, 0),
+		tcell.NewEventKey(tcell.KeyBackspace2, 0, 0),
+		tcell.NewEventKey(tcell.KeyESC, 0, 0),
+	)
+	ed.handleEvent()
+
+	require.Equal(t, "tw", string(buf.findPhrase))
+
+	postKeys(ed,
+		tcell.NewEventKey(tcell.KeyCtrlF, 0, 0),
+		tcell.NewEventKey(tcell.KeyCtrlA, 0, 0),
+		tcell.NewEventKey(tcell.KeyBackspace2, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, 'o', 0),
+		tcell.NewEventKey(tcell.KeyEnter, 0, 0),
+	)
+	ed.handleEvent()
+
+	require.Equal(t, "otw", string(buf.findPhrase), "backspace at the start of the input does nothing")
+}
+
+func TestHighlightMatches(t *testing.T) {
+	ed := newTestEditor(t, "abab xab")
+	buf := ed.bufs[0]
+	v := buf.lastView
+	scr := ed.scr.(tcell.SimulationScreen)
+
+	buf.highlightPhrase = []rune("ab")
+	v.x = 2
+	ed.redrawScreen()
+
+	cells, width, _ := scr.GetContents()
+	var highlighted string
+	for x := 0; x < 8; x++ {
+		_, bg, _ := cells[x].Style.Decompose()
+		switch bg {
+		case tcell.ColorYellow:
+			highlighted += "c"
+		case tcell.ColorTeal:
+			highlighted += "m"
+		default:
+			highlighted += "."
+		}
+	}
+	require.Equal(t, 40, width)
+	require.Equal(t, "mmcc..mm", highlighted, "the match at the cursor is highlighted like a selection")
+}
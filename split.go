@@ -0,0 +1,355 @@
+package main
+
+import (
+	"log"
+)
+
+// window is a split of the screen that shows a buffer.
+type window struct {
+	buf  *buffer
+	view *view
+
+	// the part of the screen that the split takes, including its status line
+	// in the last row, see arrange.
+	left, top, width, height int
+}
+
+// splitDir is how a layout node divides its part of the screen.
+type splitDir int
+
+const (
+	splitHorizontal splitDir = iota // first above second.
+	splitVertical                   // first left of second, with a column between them.
+)
+
+// minSplitSize is the minimum number of rows or columns of a split. A split
+// needs a row of text and its status line.
+const minSplitSize = 2
+
+// layout is a node of the layout tree of the splits. Leaves show a window, all
+// other nodes divide their part of the screen between first and second.
+type layout struct {
+	win *window // only set for leaves.
+
+	dir           splitDir
+	ratio         float64 // share of first in the rows or columns.
+	first, second *layout
+	parent        *layout
+
+	left, top, width, height int
+}
+
+// windows returns the splits of l from the top left to the bottom right.
+func (l *layout) windows() []*window {
+	if l.win != nil {
+		return []*window{l.win}
+	}
+	return append(l.first.windows(), l.second.windows()...)
+}
+
+// find returns the leaf of l that shows win, or nil.
+func (l *layout) find(win *window) *layout {
+	if l.win != nil {
+		if l.win == win {
+			return l
+		}
+		return nil
+	}
+	if node := l.first.find(win); node != nil {
+		return node
+	}
+	return l.second.find(win)
+}
+
+// total returns the number of rows or columns that l divides.
+func (l *layout) total() int {
+	if l.dir == splitVertical {
+		return l.width - 1
+	}
+	return l.height
+}
+
+// firstSize returns how many of the rows or columns of l go to first.
+func (l *layout) firstSize() int {
+	total := l.total()
+	if total < 2*minSplitSize {
+		return total / 2
+	}
+
+	n := int(float64(total)*l.ratio + 0.5)
+	if n < minSplitSize {
+		n = minSplitSize
+	}
+	if n > total-minSplitSize {
+		n = total - minSplitSize
+	}
+	return n
+}
+
+// arrange sets the parts of the screen that the splits of l take within the
+// given one.
+func (l *layout) arrange(left, top, width, height int) {
+	l.left, l.top, l.width, l.height = left, top, width, height
+
+	if l.win != nil {
+		l.win.left, l.win.top, l.win.width, l.win.height = left, top, width, height
+		l.win.view.height = height - 1
+		return
+	}
+
+	n := l.firstSize()
+	if l.dir == splitHorizontal {
+		l.first.arrange(left, top, width, n)
+		l.second.arrange(left, top+n, width, height-n)
+		return
+	}
+	l.first.arrange(left, top, n, height)
+	l.second.arrange(left+n+1, top, width-n-1, height)
+}
+
+// bufferIndex returns the index of buf in the list of buffers, or -1 if it was
+// closed.
+func (e *editor) bufferIndex(buf *buffer) int {
+	for idx, b := range e.bufs {
+		if b == buf {
+			return idx
+		}
+	}
+	return -1
+}
+
+// syncWindow makes the current split show the current buffer after a command
+// switched to another buffer, and splits that showed a closed buffer show the
+// current buffer instead.
+func (e *editor) syncWindow() {
+	curBuf := e.bufs[e.bufIdx]
+
+	if e.layout == nil {
+		e.win = &window{buf: curBuf, view: curBuf.lastView}
+		e.layout = &layout{win: e.win}
+	}
+
+	if e.win.buf != curBuf {
+		e.showBuffer(e.win, curBuf)
+	}
+
+	for _, win := range e.layout.windows() {
+		if e.bufferIndex(win.buf) < 0 {
+			e.showBuffer(win, curBuf)
+		}
+	}
+
+	curBuf.lastView = e.win.view
+
+	e.updateViews()
+}
+
+// curView returns the view of the current split, which shows the current
+// buffer. Commands move its cursor and edit the buffer through it.
+func (e *editor) curView() *view {
+	e.syncWindow()
+	return e.win.view
+}
+
+// updateViews records in every buffer the views of the splits that show it.
+func (e *editor) updateViews() {
+	for _, buf := range e.bufs {
+		buf.views = nil
+	}
+	for _, win := range e.layout.windows() {
+		win.buf.views = append(win.buf.views, win.view)
+	}
+}
+
+// showBuffer makes win show buf with the view that buf was last shown with. If
+// another split shows buf with that view, win gets a copy of it.
+func (e *editor) showBuffer(win *window, buf *buffer) {
+	v := buf.lastView
+	for _, other := range e.layout.windows() {
+		if other != win && other.view == v {
+			copied := *v
+			v = &copied
+			break
+		}
+	}
+
+	win.buf, win.view = buf, v
+}
+
+// activate makes win the current split, and its buffer the current buffer.
+func (e *editor) activate(win *window) {
+	e.win = win
+	e.bufIdx = e.bufferIndex(win.buf)
+	win.buf.lastView = win.view
+
+	e.updateViews()
+}
+
+// arrange divides the screen between the splits. The last row of the screen
+// is left for messages and prompts, the first row for the tab bar if it is
+// shown.
+func (e *editor) arrange() {
+	e.syncWindow()
+
+	width, height := e.scr.Size()
+	if e.tabBar {
+		e.layout.arrange(0, 1, width, height-2)
+	} else {
+		e.layout.arrange(0, 0, width, height-1)
+	}
+}
+
+// drawLayout draws the splits of l and the columns between splits that are
+// beside each other.
+func (e *editor) drawLayout(l *layout) {
+	if l.win != nil {
+		e.drawWindow(l.win)
+		return
+	}
+
+	e.drawLayout(l.first)
+	e.drawLayout(l.second)
+
+	if l.dir == splitVertical {
+		style := e.theme.apply(slotStatusBarInactive, e.theme.style(slotStatusBar))
+		x := l.left + l.first.width
+		for y := l.top; y < l.top+l.height; y++ {
+			e.scr.SetContent(x, y, '|', nil, style)
+		}
+	}
+}
+
+func (e *editor) splitHorizontal() {
+	e.split(splitHorizontal)
+}
+
+func (e *editor) splitVertical() {
+	e.split(splitVertical)
+}
+
+// split divides the current split into two that both show the current buffer
+// at the same position. The current split stays the top or left one.
+func (e *editor) split(dir splitDir) {
+	e.arrange()
+
+	node := e.layout.find(e.win)
+
+	size := node.height
+	if dir == splitVertical {
+		size = node.width - 1
+	}
+	if size < 2*minSplitSize {
+		log.Printf("split: %dx%d is too small to split", node.width, node.height)
+		e.showError("Split is too small")
+		return
+	}
+
+	v := *e.win.view
+	win := &window{buf: e.win.buf, view: &v}
+
+	node.first = &layout{win: e.win, parent: node}
+	node.second = &layout{win: win, parent: node}
+	node.win, node.dir, node.ratio = nil, dir, 0.5
+
+	e.updateViews()
+
+	log.Printf("split: split %dx%d at %d/%d", node.width, node.height, node.left, node.top)
+}
+
+// closeSplit closes the current split. The split next to it takes its place,
+// and its top left split becomes the current one.
+func (e *editor) closeSplit() {
+	e.arrange()
+
+	node := e.layout.find(e.win)
+	parent := node.parent
+	if parent == nil {
+		log.Printf("closeSplit: can't close the only split")
+		e.showError("Can't close the only split")
+		return
+	}
+
+	sibling := parent.first
+	if sibling == node {
+		sibling = parent.second
+	}
+
+	grandparent := parent.parent
+	*parent = *sibling
+	parent.parent = grandparent
+	if parent.win == nil {
+		parent.first.parent, parent.second.parent = parent, parent
+	}
+
+	e.activate(parent.windows()[0])
+
+	log.Printf("closeSplit: closed split, %d splits left", len(e.layout.windows()))
+}
+
+// closeOtherSplits makes the current split take the whole screen.
+func (e *editor) closeOtherSplits() {
+	e.arrange()
+	e.layout = &layout{win: e.win}
+	e.updateViews()
+}
+
+// nextSplit makes the next split the current one, going from the top left to
+// the bottom right and around.
+func (e *editor) nextSplit() {
+	e.arrange()
+
+	wins := e.layout.windows()
+	for idx, win := range wins {
+		if win == e.win {
+			e.activate(wins[(idx+1)%len(wins)])
+			break
+		}
+	}
+
+	log.Printf("nextSplit: current split is at %d/%d", e.win.left, e.win.top)
+}
+
+func (e *editor) growSplit() {
+	e.resizeSplit(1)
+}
+
+func (e *editor) shrinkSplit() {
+	e.resizeSplit(-1)
+}
+
+// resizeSplit makes the current split n rows or columns larger, or smaller if
+// n is negative, at the expense of the split next to it. Whether rows or
+// columns change depends on how the split was made.
+func (e *editor) resizeSplit(n int) {
+	e.arrange()
+
+	node := e.layout.find(e.win)
+	parent := node.parent
+	if parent == nil {
+		log.Printf("resizeSplit: can't resize the only split")
+		e.showError("Can't resize the only split")
+		return
+	}
+
+	if node == parent.second {
+		n = -n
+	}
+
+	total := parent.total()
+	if total <= 0 {
+		return
+	}
+
+	size := parent.firstSize() + n
+	if size > total-minSplitSize {
+		size = total - minSplitSize
+	}
+	if size < minSplitSize {
+		size = minSplitSize
+	}
+	// the ratio is snapped to the limits, so that resizing back right after
+	// hitting one takes effect immediately.
+	parent.ratio = float64(size) / float64(total)
+
+	log.Printf("resizeSplit: first split gets %d of %d", parent.firstSize(), total)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// altX returns the keys of Alt-X followed by r.
+func altX(r rune) []*tcell.EventKey {
+	return []*tcell.EventKey{tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, r, 0)}
+}
+
+func numberedLines(n int) string {
+	var lines []string
+	for i := 0; i < n; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestSplitWindows(t *testing.T) {
+	ed := newTestEditor(t, numberedLines(20))
+	scr := ed.scr.(tcell.SimulationScreen)
+
+	playKeys(t, ed, altX('2')...)
+	ed.redrawScreen()
+
+	// the 9 rows above the message line are split into 5 and 4 rows.
+	require.Equal(t, "line 0", screenRow(scr, 0))
+	require.Equal(t, "line 0", screenRow(scr, 5))
+	require.True(t, strings.HasPrefix(screenRow(scr, 4), "- <no file> (1 of 1)"), screenRow(scr, 4))
+	require.True(t, strings.HasPrefix(screenRow(scr, 8), "- <no file> (1 of 1)"), screenRow(scr, 8))
+
+	for i := 0; i < 6; i++ {
+		playKeys(t, ed, tcell.NewEventKey(tcell.KeyDown, 0, 0))
+	}
+	ed.redrawScreen()
+
+	require.Equal(t, 6, ed.win.view.curLineIdx())
+	require.Equal(t, "line 3", screenRow(scr, 0), "the current split scrolls within its 4 rows of text")
+	require.Equal(t, "line 0", screenRow(scr, 5), "the other split keeps its position")
+
+	playKeys(t, ed, altX('o')...)
+	ed.redrawScreen()
+
+	require.Equal(t, 0, ed.win.view.curLineIdx(), "the other split has its own cursor")
+	x, y, _ := scr.GetCursor()
+	require.Equal(t, []int{0, 5}, []int{x, y})
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'x', 0))
+	ed.redrawScreen()
+
+	require.Equal(t, "xline 0", screenRow(scr, 5))
+	require.Equal(t, "line 3", screenRow(scr, 0))
+
+	playKeys(t, ed, altX('0')...)
+	ed.redrawScreen()
+
+	require.Equal(t, 6, ed.win.view.curLineIdx(), "the remaining split becomes the current one")
+	require.True(t, strings.HasPrefix(screenRow(scr, 8), "* <no file> (1 of 1)"), screenRow(scr, 8))
+
+	playKeys(t, ed, altX('0')...)
+	require.Len(t, ed.layout.windows(), 1)
+}
+
+func TestSplitVertical(t *testing.T) {
+	ed := newTestEditor(t, numberedLines(3))
+	scr := ed.scr.(tcell.SimulationScreen)
+
+	playKeys(t, ed, altX('3')...)
+	ed.redrawScreen()
+
+	cells, _, _ := scr.GetContents()
+	require.Equal(t, '|', cells[20].Runes[0])
+	require.Equal(t, "line 0              |line 0", screenRow(scr, 0))
+
+	playKeys(t, ed, altX('3')...)
+	ed.redrawScreen()
+	require.Equal(t, "line 0    |line 0   |line 0", screenRow(scr, 0))
+
+	playKeys(t, ed, altX('1')...)
+	ed.redrawScreen()
+	require.Equal(t, "line 0", screenRow(scr, 0))
+	require.Len(t, ed.layout.windows(), 1)
+}
+
+func TestResizeSplit(t *testing.T) {
+	ed := newTestEditor(t, numberedLines(20))
+
+	playKeys(t, ed, altX('>')...)
+	ed.arrange()
+	require.Equal(t, 9, ed.win.height, "the only split can't be resized")
+
+	playKeys(t, ed, altX('2')...)
+	playKeys(t, ed, altX('>')...)
+	ed.arrange()
+	require.Equal(t, 6, ed.win.height)
+
+	for i := 0; i < 3; i++ {
+		playKeys(t, ed, altX('>')...)
+	}
+	ed.arrange()
+	require.Equal(t, 7, ed.win.height, "the other split keeps a row of text")
+
+	playKeys(t, ed, altX('o')...)
+	playKeys(t, ed, altX('>')...)
+	ed.arrange()
+	require.Equal(t, 3, ed.win.height)
+	require.Equal(t, 6, ed.win.top)
+
+	playKeys(t, ed, altX('<')...)
+	ed.arrange()
+	require.Equal(t, 2, ed.win.height)
+}
+
+func TestResizeSplitAtLimits(t *testing.T) {
+	testData := map[string]struct {
+		key            rune
+		back           rune
+		expectedLimit  int
+		expectedBackTo int
+	}{
+		"grow":   {'>', '<', 7, 6},
+		"shrink": {'<', '>', 2, 3},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			ed := newTestEditor(t, numberedLines(20))
+			playKeys(t, ed, altX('2')...)
+
+			for i := 0; i < 10; i++ {
+				playKeys(t, ed, altX(tt.key)...)
+			}
+			ed.arrange()
+			require.Equal(t, tt.expectedLimit, ed.win.height)
+
+			playKeys(t, ed, altX(tt.back)...)
+			ed.arrange()
+			require.Equal(t, tt.expectedBackTo, ed.win.height, "resizing back takes effect right away")
+		})
+	}
+}
+
+func TestSplitsShowDifferentBuffers(t *testing.T) {
+	ed := newTestEditor(t, "first")
+	ed.bufs = append(ed.bufs, newBufferFromFileContent([]byte("second")))
+	scr := ed.scr.(tcell.SimulationScreen)
+
+	playKeys(t, ed, altX('2')...)
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlN, 0, 0))
+	ed.redrawScreen()
+
+	require.Equal(t, "second", screenRow(scr, 0))
+	require.Equal(t, "first", screenRow(scr, 5))
+	require.True(t, strings.HasPrefix(screenRow(scr, 4), "- <no file> (2 of 2)"), screenRow(scr, 4))
+
+	// clicking the other split makes it the current one.
+	require.NoError(t, ed.scr.PostEvent(tcell.NewEventMouse(3, 5, tcell.Button1, 0)))
+	ed.handleEvent()
+	require.Equal(t, 0, ed.bufIdx)
+	require.Equal(t, 3, ed.win.view.x)
+
+	playKeys(t, ed, altX('o')...)
+	require.Equal(t, 1, ed.bufIdx)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlD, 0, 0))
+	ed.redrawScreen()
+
+	require.Equal(t, "first", screenRow(scr, 0), "splits of a closed buffer show another buffer")
+	require.Equal(t, "first", screenRow(scr, 5))
+	require.Equal(t, 3, ed.win.view.x, "the split got a copy of the view of the other split")
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlA, 0, 0))
+	playKeys(t, ed, altX('o')...)
+	require.Equal(t, 3, ed.win.view.x, "the splits have separate views of the buffer")
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// swapFileName returns the name of the swap file for fname, a hidden file in
+// the same directory.
+func swapFileName(fname string) string {
+	return filepath.Join(filepath.Dir(fname), "."+filepath.Base(fname)+".exa-swp")
+}
+
+// swapJob writes buf to the swap file fname, or removes the swap file if buf
+// is nil.
+type swapJob struct {
+	fname string
+	buf   *buffer
+}
+
+func (job swapJob) run() {
+	if job.buf == nil {
+		log.Printf("swapJob: removing swap file %s", job.fname)
+		if err := os.Remove(job.fname); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("swapJob: removing swap file %s failed: %v", job.fname, err)
+		}
+		return
+	}
+
+	log.Printf("swapJob: writing swap file %s", job.fname)
+	if err := writeFileAtomically(job.fname, 0600, job.buf.writeFileContent); err != nil {
+		log.Printf("swapJob: writing swap file %s failed: %v", job.fname, err)
+	}
+}
+
+// startSwapWriter starts the goroutine that writes and removes swap files in
+// the background.
+func (e *editor) startSwapWriter() {
+	jobs := make(chan swapJob, 16)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for job := range jobs {
+			job.run()
+		}
+	}()
+
+	e.swapJobs, e.swapDone = jobs, done
+}
+
+// stopSwapWriter waits until all pending swap files have been written or
+// removed and stops the swap writer.
+func (e *editor) stopSwapWriter() {
+	if e.swapJobs == nil {
+		return
+	}
+
+	close(e.swapJobs)
+	<-e.swapDone
+
+	e.swapJobs, e.swapDone = nil, nil
+}
+
+// queueSwapJob hands job to the swap writer, or runs it right away if the
+// swap writer isn't running.
+func (e *editor) queueSwapJob(job swapJob) {
+	if e.swapJobs == nil {
+		job.run()
+		return
+	}
+	e.swapJobs <- job
+}
+
+// updateSwapFiles writes the swap files of all modified buffers that changed
+// since their swap file was last written, and removes the swap files of
+// buffers that are no longer modified. Buffers without a file name have no
+// swap file.
+func (e *editor) updateSwapFiles() {
+	for _, buf := range e.bufs {
+		if buf.fname == "" || buf.swapFound {
+			continue
+		}
+
+		name := swapFileName(buf.fname)
+
+		if buf.swapName != "" && (buf.swapName != name || !buf.modified) {
+			e.removeSwapFile(buf)
+		}
+
+		if buf.modified && (buf.swapName == "" || buf.changes != buf.swapChanges) {
+			e.queueSwapJob(swapJob{fname: name, buf: buf.snapshot()})
+			buf.swapName, buf.swapChanges = name, buf.changes
+		}
+	}
+}
+
+// removeSwapFile removes the swap file of buf, if it has one.
+func (e *editor) removeSwapFile(buf *buffer) {
+	if buf.swapName == "" {
+		return
+	}
+	e.queueSwapJob(swapJob{fname: buf.swapName})
+	buf.swapName = ""
+}
+
+// checkSwapFiles asks what to do with the swap files that previous sessions
+// left behind for any of the buffers.
+func (e *editor) checkSwapFiles() {
+	bufIdx := e.bufIdx
+	defer func() {
+		e.bufIdx = bufIdx
+	}()
+
+	for idx, buf := range e.bufs {
+		if buf.swapFound {
+			e.bufIdx = idx
+			e.recoverSwapFile(buf)
+		}
+	}
+}
+
+func (e *editor) recoverSwapFile(buf *buffer) {
+	buf.swapFound = false
+
+	name := swapFileName(buf.fname)
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		log.Printf("recoverSwapFile: reading swap file %s failed: %v", name, err)
+		e.showError("Couldn't read swap file %s: %v", name, err)
+		return
+	}
+
+	recovered := newBufferFromFileContent(data)
+
+	prompt := fmt.Sprintf("Found swap file %s: (r)ecover, show (d)iff, (x) delete or (i)gnore?", name)
+
+	for {
+		e.redrawScreen()
+
+		switch e.query(prompt, "rdxi") {
+		case 'r':
+			log.Printf("recoverSwapFile: recovering %s from %s", buf.fname, name)
+			buf.replaceContent(recovered)
+			buf.modified = true
+			buf.savedState = nil
+			// the swap file is kept until the buffer is saved.
+			buf.swapName, buf.swapChanges = name, -1
+			e.showError("Recovered %s from swap file", buf.fname)
+			return
+		case 'd':
+			diff := unifiedDiff(buf.fname, name, runeLines(buf.lines()), runeLines(recovered.lines()))
+			if diff == "" {
+				prompt = fmt.Sprintf("Swap file %s has no changes: (r)ecover, show (d)iff, (x) delete or (i)gnore?", name)
+				continue
+			}
+			log.Printf("recoverSwapFile: showing differences between %s and %s", buf.fname, name)
+			e.addTextBuffer(diff)
+		case 'x':
+			log.Printf("recoverSwapFile: removing %s", name)
+			if err := os.Remove(name); err != nil {
+				e.showError("Couldn't remove swap file %s: %v", name, err)
+				return
+			}
+			e.showError("Removed swap file %s", name)
+			return
+		case 'i':
+			log.Printf("recoverSwapFile: ignoring %s", name)
+			return
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwapFiles(t *testing.T) {
+	ed := newTestEditor(t)
+
+	fname := filepath.Join(t.TempDir(), "file.txt")
+	swapName := swapFileName(fname)
+	require.NoError(t, os.WriteFile(fname, []byte("hello\n"), 0644))
+
+	require.NoError(t, ed.loadBufferFromFile(fname))
+	require.False(t, ed.bufs[ed.bufIdx].swapFound)
+
+	ed.startSwapWriter()
+
+	ed.updateSwapFiles()
+	ed.stopSwapWriter()
+	require.NoFileExists(t, swapName, "unmodified buffers have no swap file")
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'x', 0))
+
+	ed.startSwapWriter()
+	ed.updateSwapFiles()
+	ed.stopSwapWriter()
+
+	data, err := os.ReadFile(swapName)
+	require.NoError(t, err)
+	require.Equal(t, "xhello\n", string(data))
+
+	if runtime.GOOS != "windows" {
+		fi, err := os.Stat(swapName)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlS, 0, 0))
+	require.NoFileExists(t, swapName, "saving removes the swap file")
+}
+
+func TestRecoverSwapFile(t *testing.T) {
+	testData := map[string]struct {
+		answers          []rune
+		expectedLines    [][]rune
+		expectedModified bool
+		expectedBufs     int
+		expectedSwapFile bool
+	}{
+		"recover": {
+			answers:          []rune{'r'},
+			expectedLines:    [][]rune{[]rune("recovered")},
+			expectedModified: true,
+			expectedBufs:     1,
+			expectedSwapFile: true,
+		},
+		"diff then recover": {
+			answers:          []rune{'d', 'r'},
+			expectedLines:    [][]rune{[]rune("recovered")},
+			expectedModified: true,
+			expectedBufs:     2,
+			expectedSwapFile: true,
+		},
+		"delete": {
+			answers:       []rune{'x'},
+			expectedLines: [][]rune{[]rune("original")},
+			expectedBufs:  1,
+		},
+		"ignore": {
+			answers:          []rune{'i'},
+			expectedLines:    [][]rune{[]rune("original")},
+			expectedBufs:     1,
+			expectedSwapFile: true,
+		},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			ed := newTestEditor(t)
+
+			fname := filepath.Join(t.TempDir(), "file.txt")
+			swapName := swapFileName(fname)
+			require.NoError(t, os.WriteFile(fname, []byte("original\n"), 0644))
+			require.NoError(t, os.WriteFile(swapName, []byte("recovered\n"), 0600))
+
+			require.NoError(t, ed.loadBufferFromFile(fname))
+			require.True(t, ed.bufs[0].swapFound)
+
+			for _, r := range tt.answers {
+				require.NoError(t, ed.scr.PostEvent(tcell.NewEventKey(tcell.KeyRune, r, 0)))
+			}
+
+			ed.checkSwapFiles()
+
+			require.Equal(t, 0, ed.bufIdx)
+			require.Len(t, ed.bufs, tt.expectedBufs)
+			require.Equal(t, tt.expectedLines, ed.bufs[0].lines())
+			require.Equal(t, tt.expectedModified, ed.bufs[0].modified)
+			require.False(t, ed.bufs[0].swapFound)
+
+			if tt.expectedBufs > 1 {
+				require.Equal(t, [][]rune{
+					[]rune("--- " + fname),
+					[]rune("+++ " + swapName),
+					[]rune("@@ -1 +1 @@"),
+					[]rune("-original"),
+					[]rune("+recovered"),
+				}, ed.bufs[1].lines())
+			}
+
+			if tt.expectedSwapFile {
+				require.FileExists(t, swapName)
+			} else {
+				require.NoFileExists(t, swapName)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// tokenClass is the kind of a token that syntax highlighting gives a style.
+type tokenClass uint8
+
+const (
+	classNone tokenClass = iota
+	classComment
+	classString
+	classKeyword
+	classType
+	classConstant
+	classNumber
+	classFunction
+	classVariable
+	classKey
+	classHeading
+	classEmphasis
+	classLink
+	classCode
+)
+
+// tokenClasses are the names of the token classes in language definitions.
+var tokenClasses = map[string]tokenClass{
+	"comment":  classComment,
+	"string":   classString,
+	"keyword":  classKeyword,
+	"type":     classType,
+	"constant": classConstant,
+	"number":   classNumber,
+	"function": classFunction,
+	"variable": classVariable,
+	"key":      classKey,
+	"heading":  classHeading,
+	"emphasis": classEmphasis,
+	"link":     classLink,
+	"code":     classCode,
+}
+
+// syntaxRule is a rule of a language definition. A rule either matches a
+// token with a single regular expression, or a region from a match of Start
+// to a match of End, which can span several lines if Multiline is true.
+// Matches of Skip within a region, e.g. escaped quotes, don't end it.
+type syntaxRule struct {
+	Class     string `json:"class"`
+	Match     string `json:"match,omitempty"`
+	Start     string `json:"start,omitempty"`
+	End       string `json:"end,omitempty"`
+	Skip      string `json:"skip,omitempty"`
+	Multiline bool   `json:"multiline,omitempty"`
+
+	class tokenClass
+	match *regexp.Regexp // Match, or Start for regions.
+	end   *regexp.Regexp // Skip and End as alternatives, End is submatch 1.
+}
+
+// language is a language definition, loaded from a JSON file. Files whose
+// base name matches one of Files, or whose first line matches FirstLine, are
+// highlighted with the rules of the language. If several rules match in a
+// line, the leftmost match wins, and of matches at the same position the rule
+// that comes first.
+type language struct {
+	Name      string       `json:"name"`
+	Files     []string     `json:"files"`
+	FirstLine string       `json:"firstLine,omitempty"`
+	Rules     []syntaxRule `json:"rules"`
+
+	firstLine *regexp.Regexp
+}
+
+//go:embed syntax/*.json
+var builtinSyntaxFiles embed.FS
+
+// parseLanguage parses the language definition data of the file fname.
+func parseLanguage(fname string, data []byte) (*language, error) {
+	var lang language
+	if err := json.Unmarshal(data, &lang); err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+
+	if lang.Name == "" {
+		return nil, fmt.Errorf("%s: language has no name", fname)
+	}
+
+	var errs configErrors
+
+	if lang.FirstLine != "" {
+		re, err := regexp.Compile(lang.FirstLine)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: firstLine: %w", fname, err))
+		}
+		lang.firstLine = re
+	}
+
+	for idx := range lang.Rules {
+		if err := lang.Rules[idx].compile(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: rule %d: %w", fname, idx+1, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return &lang, nil
+}
+
+func (r *syntaxRule) compile() (err error) {
+	var ok bool
+	if r.class, ok = tokenClasses[r.Class]; !ok {
+		return fmt.Errorf("unknown class %q", r.Class)
+	}
+
+	switch {
+	case r.Match != "" && r.Start == "" && r.End == "":
+		r.match, err = regexp.Compile(r.Match)
+		return err
+	case r.Match == "" && r.Start != "" && r.End != "":
+		if r.match, err = regexp.Compile(r.Start); err != nil {
+			return err
+		}
+		end := "(" + r.End + ")"
+		if r.Skip != "" {
+			end = "(?:" + r.Skip + ")|" + end
+		}
+		r.end, err = regexp.Compile(end)
+		return err
+	default:
+		return errors.New("a rule needs either match, or start and end")
+	}
+}
+
+// builtinLanguages returns the language definitions that come with exa.
+func builtinLanguages() []*language {
+	var langs []*language
+
+	fnames, _ := fs.Glob(builtinSyntaxFiles, "syntax/*.json")
+	for _, fname := range fnames {
+		data, err := builtinSyntaxFiles.ReadFile(fname)
+		if err != nil {
+			panic(err)
+		}
+		lang, err := parseLanguage(fname, data)
+		if err != nil {
+			panic(err)
+		}
+		langs = append(langs, lang)
+	}
+
+	return langs
+}
+
+// loadLanguages loads the language definitions in the *.json files in dir.
+// They replace built-in languages of the same name, and take precedence over
+// them when detecting the language of a file.
+func (e *editor) loadLanguages(dir string) error {
+	fnames, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(fnames)
+
+	var (
+		langs []*language
+		errs  configErrors
+	)
+
+	for _, fname := range fnames {
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		lang, err := parseLanguage(fname, data)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		langs = append(langs, lang)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	for _, lang := range e.languages {
+		replaced := false
+		for _, l := range langs {
+			if strings.EqualFold(l.Name, lang.Name) {
+				replaced = true
+			}
+		}
+		if !replaced {
+			langs = append(langs, lang)
+		}
+	}
+
+	e.languages = langs
+
+	log.Printf("loadLanguages: loaded %d language definitions from %s", len(fnames), dir)
+
+	return nil
+}
+
+// detectLanguage returns the language of the text of buf, or nil if it is
+// unknown.
+func (e *editor) detectLanguage(buf *buffer) *language {
+	if buf.results != nil {
+		return nil
+	}
+
+	if buf.fname != "" {
+		base := filepath.Base(buf.fname)
+		for _, lang := range e.languages {
+			for _, pattern := range lang.Files {
+				if ok, _ := path.Match(pattern, base); ok {
+					return lang
+				}
+			}
+		}
+	}
+
+	firstLine := string(buf.line(0))
+	for _, lang := range e.languages {
+		if lang.firstLine != nil && lang.firstLine.MatchString(firstLine) {
+			return lang
+		}
+	}
+
+	return nil
+}
+
+// highlight returns the token classes of the runes of line. state is the
+// index of the multi-line region that the line starts in, or -1. The returned
+// state is that of the next line.
+func (lang *language) highlight(line []rune, state int) ([]tokenClass, int) {
+	s := string(line)
+
+	classes := make([]tokenClass, len(line))
+
+	// runeIdx maps the byte offsets of s where runes start, which are the
+	// only ones that matches start or end at, to rune indexes of line.
+	runeIdx := make([]int, len(s)+1)
+	n := 0
+	for offset := range s {
+		runeIdx[offset] = n
+		n++
+	}
+	runeIdx[len(s)] = n
+
+	mark := func(start, end int, class tokenClass) {
+		for i := runeIdx[start]; i < runeIdx[end]; i++ {
+			classes[i] = class
+		}
+	}
+
+	// region marks the region of rule from start on, its body starting at
+	// bodyStart, and returns where it ends and whether it continues in the
+	// next line. The body is matched on its own, so that text before it, like
+	// the start of the region, can't be taken as a skip.
+	region := func(rule *syntaxRule, start, bodyStart int) (int, bool) {
+		for _, m := range rule.end.FindAllStringSubmatchIndex(s[bodyStart:], -1) {
+			if m[2] >= 0 {
+				end := bodyStart + m[1]
+				mark(start, end, rule.class)
+				return end, false
+			}
+		}
+		mark(start, len(s), rule.class)
+		return len(s), rule.Multiline
+	}
+
+	pos := 0
+
+	if state >= 0 && state < len(lang.Rules) {
+		end, continues := region(&lang.Rules[state], 0, 0)
+		if continues {
+			return classes, state
+		}
+		pos = end
+	}
+
+	// matches of every rule in the whole line, so that ^ and \b work as
+	// expected. next is the first match of each rule that may still be used.
+	matches := make([][][]int, len(lang.Rules))
+	next := make([]int, len(lang.Rules))
+	for idx := range lang.Rules {
+		matches[idx] = lang.Rules[idx].match.FindAllStringIndex(s, -1)
+	}
+
+	for pos < len(s) {
+		best := -1
+		for idx := range lang.Rules {
+			for next[idx] < len(matches[idx]) && (matches[idx][next[idx]][0] < pos || matches[idx][next[idx]][0] == matches[idx][next[idx]][1]) {
+				next[idx]++
+			}
+			if next[idx] < len(matches[idx]) && (best < 0 || matches[idx][next[idx]][0] < matches[best][next[best]][0]) {
+				best = idx
+			}
+		}
+
+		if best < 0 {
+			break
+		}
+
+		rule := &lang.Rules[best]
+		m := matches[best][next[best]]
+
+		if rule.end == nil {
+			mark(m[0], m[1], rule.class)
+			pos = m[1]
+			continue
+		}
+
+		end, continues := region(rule, m[0], m[1])
+		if continues {
+			return classes, best
+		}
+		pos = end
+	}
+
+	return classes, -1
+}
+
+// highlighter highlights the lines of a buffer. The state at the start of
+// every line is cached, so that after an edit only the lines from the changed
+// line on are highlighted again.
+type highlighter struct {
+	fname  string    // the file name that the language was detected for.
+	lang   *language // nil if the language is unknown.
+	states []int     // states[y] is the state at the start of line y.
+}
+
+// invalidate discards the states that may be changed by an edit of line y.
+func (h *highlighter) invalidate(y int) {
+	if len(h.states) > y+1 {
+		h.states = h.states[:y+1]
+	}
+}
+
+// line returns the token classes of the runes of line y of buf.
+func (h *highlighter) line(buf *buffer, y int) []tokenClass {
+	if len(h.states) == 0 {
+		h.states = []int{-1}
+	}
+
+	for len(h.states) <= y {
+		i := len(h.states) - 1
+		_, state := h.lang.highlight(buf.line(i), h.states[i])
+		h.states = append(h.states, state)
+	}
+
+	classes, _ := h.lang.highlight(buf.line(y), h.states[y])
+
+	return classes
+}
+
+// highlightLine returns the token classes of the runes of line y of buf, or
+// nil if the language of buf is unknown.
+func (e *editor) highlightLine(buf *buffer, y int) []tokenClass {
+	if buf.syntax == nil || buf.syntax.fname != buf.fname {
+		buf.syntax = &highlighter{fname: buf.fname, lang: e.detectLanguage(buf)}
+		if buf.syntax.lang != nil {
+			log.Printf("highlightLine: language of %q is %s", buf.fname, buf.syntax.lang.Name)
+		}
+	}
+
+	if buf.syntax.lang == nil {
+		return nil
+	}
+
+	return buf.syntax.line(buf, y)
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// classLetters abbreviates token classes in tests.
+var classLetters = map[tokenClass]byte{
+	classNone:     '.',
+	classComment:  'c',
+	classString:   's',
+	classKeyword:  'k',
+	classType:     't',
+	classConstant: 'C',
+	classNumber:   'n',
+	classFunction: 'f',
+	classVariable: 'v',
+	classKey:      'K',
+	classHeading:  'h',
+	classEmphasis: 'e',
+	classLink:     'l',
+	classCode:     'x',
+}
+
+func languageByName(t *testing.T, ed *editor, name string) *language {
+	for _, lang := range ed.languages {
+		if lang.Name == name {
+			return lang
+		}
+	}
+	t.Fatalf("language %s not found", name)
+	return nil
+}
+
+// highlightLines returns the token classes of lines, one letter per rune.
+func highlightLines(lang *language, lines []string) []string {
+	var result []string
+	state := -1
+	for _, line := range lines {
+		var classes []tokenClass
+		classes, state = lang.highlight([]rune(line), state)
+		var b strings.Builder
+		for _, c := range classes {
+			b.WriteByte(classLetters[c])
+		}
+		result = append(result, b.String())
+	}
+	return result
+}
+
+func TestHighlight(t *testing.T) {
+	testData := map[string]struct {
+		lang     string
+		lines    []string
+		expected []string
+	}{
+		"go": {
+			"Go",
+			[]string{`func f(s string) int {`, `	return len("a\"b") + 0x1f // done`},
+			[]string{`kkkk.....tttttt..ttt..`, `.kkkkkk.fff.ssssss....nnnn.ccccccc`},
+		},
+		"go multi-line": {
+			"Go",
+			[]string{"x := `raw", "still raw` /* comment", "still comment */ true"},
+			[]string{".....ssss", "ssssssssss.cccccccccc", "cccccccccccccccc.CCCC"},
+		},
+		"go unterminated string": {
+			"Go",
+			[]string{`s := "abc`, `nil`},
+			[]string{`.....ssss`, `CCC`},
+		},
+		"markdown": {
+			"Markdown",
+			[]string{"# Title", "Some `code` and **bold** [link](url)", "```", "# not a heading", "```", "- item"},
+			[]string{"hhhhhhh", ".....xxxxxx.....eeeeeeee.lllllllllll", "xxx", "xxxxxxxxxxxxxxx", "xxx", "kk...."},
+		},
+		"json": {
+			"JSON",
+			[]string{`{"key": "value", "n": -1.5, "ok": true}`},
+			[]string{`.KKKKKK.sssssss..KKKK.nnnn..KKKKK.CCCC.`},
+		},
+		"yaml": {
+			"YAML",
+			[]string{"---", "name: 'it''s' # comment", "- list: *anchor", "count: 3"},
+			[]string{"kkk", "KKKKKKssssssscccccccccc", "KKKKKKKKvvvvvvv", "KKKKKKKn"},
+		},
+		"yaml empty string": {
+			"YAML",
+			[]string{"a: '' # comment", "b: 'x'"},
+			[]string{"KKKsscccccccccc", "KKKsss"},
+		},
+		"shell": {
+			"Shell",
+			[]string{`if [ -n "$HOME" ]; then`, `  echo 'multi`, `line' # comment`, `fi # comment`},
+			[]string{`kk......sssssss....kkkk`, `.......ssssss`, `ssssscccccccccc`, `kkcccccccccc`},
+		},
+		"makefile": {
+			"Makefile",
+			[]string{"CC := gcc", "all: $(OBJS)", "\t$(CC) -o $@ # comment", "ifeq ($(X),y)"},
+			[]string{"KKKKK....", "ffff.vvvvvvv", ".vvvvv....vv.ccccccccc", "kkkk..vvvv..."},
+		},
+	}
+
+	ed := newTestEditor(t, "")
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			require.Equal(t, tt.expected, highlightLines(languageByName(t, ed, tt.lang), tt.lines))
+		})
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	testData := map[string]struct {
+		fname    string
+		text     string
+		expected string
+	}{
+		"go":                {"main.go", "", "Go"},
+		"path":              {"/tmp/x/README.md", "", "Markdown"},
+		"makefile":          {"src/Makefile", "", "Makefile"},
+		"shebang":           {"configure", "#!/usr/bin/env bash\n", "Shell"},
+		"shebang, no file":  {"", "#!/bin/sh\n", "Shell"},
+		"unknown":           {"notes.txt", "hello", ""},
+		"name before shell": {"script.yml", "#!/bin/sh\n", "YAML"},
+	}
+
+	ed := newTestEditor(t, "")
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			buf := newBufferFromFileContent([]byte(tt.text))
+			buf.fname = tt.fname
+			name := ""
+			if lang := ed.detectLanguage(buf); lang != nil {
+				name = lang.Name
+			}
+			require.Equal(t, tt.expected, name)
+		})
+	}
+}
+
+func TestHighlighterFollowsEdits(t *testing.T) {
+	ed := newTestEditor(t, "a := 1\nb := 2\nc := 3")
+	buf := ed.bufs[0]
+	v := buf.lastView
+	buf.fname = "x.go"
+
+	require.Equal(t, classNumber, ed.highlightLine(buf, 2)[5])
+
+	v.insertText(0, 0, [][]rune{[]rune("/* ")})
+	require.Equal(t, classComment, ed.highlightLine(buf, 2)[5], "the comment continues in the following lines")
+
+	v.insertText(1, 0, [][]rune{[]rune("*/")})
+	require.Equal(t, classNumber, ed.highlightLine(buf, 2)[5], "the lines after the end of the comment are highlighted again")
+	require.Equal(t, classComment, ed.highlightLine(buf, 1)[1])
+
+	ed.redrawScreen()
+	cells, _, _ := ed.scr.(tcell.SimulationScreen).GetContents()
+	fg, _, _ := cells[0].Style.Decompose()
+	require.Equal(t, tcell.ColorTeal, fg, "the text is drawn with the style of its token class")
+
+	buf.fname = "x.txt"
+	require.Nil(t, ed.highlightLine(buf, 2), "the language changes with the file name")
+}
+
+func TestLoadLanguages(t *testing.T) {
+	ed := newTestEditor(t, "")
+	count := len(ed.languages)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.json"), []byte(`{"name": "go", "files": ["*.go"], "rules": [{"class": "keyword", "match": "func"}]}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ini.json"), []byte(`{"name": "INI", "files": ["*.ini"], "rules": [{"class": "comment", "match": ";.*"}]}`), 0644))
+
+	require.NoError(t, ed.loadLanguages(dir))
+	require.Len(t, ed.languages, count+1)
+
+	buf := newBufferFromFileContent(nil)
+	buf.fname = "main.go"
+	require.Len(t, ed.detectLanguage(buf).Rules, 1, "languages are replaced by those of the same name")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.json"), []byte(`{"name": "Bad", "rules": [{"class": "nonsense", "match": "x"}, {"class": "string", "start": "("}]}`), 0644))
+	err := ed.loadLanguages(dir)
+	require.Error(t, err)
+	require.Len(t, err.(configErrors), 1)
+	require.Len(t, err.(configErrors)[0].(configErrors), 2)
+
+	require.NoError(t, ed.loadLanguages(filepath.Join(dir, "does-not-exist")))
+}
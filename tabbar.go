@@ -0,0 +1,201 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// maxTabWidth is the maximum number of screen columns of a tab in the tab bar.
+// Longer file names are cut off at the start, which is shown by <.
+const maxTabWidth = 24
+
+// tab is the label of a buffer in the tab bar.
+type tab struct {
+	buf   *buffer
+	label string
+	left  int // screen column of the start of the tab.
+	width int
+}
+
+// tabLabel returns the label of the tab of buf: the base name of its file, and
+// * if it has unsaved changes.
+func (e *editor) tabLabel(buf *buffer) string {
+	name := filepath.Base(e.bufferName(buf))
+	if buf.modified {
+		name += "*"
+	}
+
+	if runewidth.StringWidth(name) > maxTabWidth-2 {
+		runes := []rune(name)
+		for runeWidth(runes) > maxTabWidth-3 {
+			runes = runes[1:]
+		}
+		name = "<" + string(runes)
+	}
+
+	return " " + name + " "
+}
+
+// tabs returns the tabs that fit into a tab bar of the given width, between the
+// first and the last column, which show < and > if there are more tabs. The
+// tab bar scrolls so that the tab of the current buffer is shown.
+func (e *editor) tabs(width int) []tab {
+	labels := make([]string, len(e.bufs))
+	for idx, buf := range e.bufs {
+		labels[idx] = e.tabLabel(buf)
+	}
+
+	// fits returns true if the tabs from first up to the current one fit.
+	fits := func(first int) bool {
+		x := 1
+		for idx := first; idx <= e.bufIdx; idx++ {
+			x += runewidth.StringWidth(labels[idx])
+		}
+		return x <= width-1
+	}
+
+	if e.tabOffset > e.bufIdx {
+		e.tabOffset = e.bufIdx
+	}
+	for e.tabOffset < e.bufIdx && !fits(e.tabOffset) {
+		e.tabOffset++
+	}
+
+	var tabs []tab
+	x := 1
+	for idx := e.tabOffset; idx < len(e.bufs); idx++ {
+		w := runewidth.StringWidth(labels[idx])
+		if x+w > width-1 && idx > e.tabOffset {
+			break
+		}
+		tabs = append(tabs, tab{buf: e.bufs[idx], label: labels[idx], left: x, width: w})
+		x += w
+	}
+
+	return tabs
+}
+
+// drawTabBar draws the tab bar in the top row of the screen.
+func (e *editor) drawTabBar(width int) {
+	style := e.theme.style(slotTabBar)
+
+	e.clearLine(0, width, style)
+
+	tabs := e.tabs(width)
+
+	for _, t := range tabs {
+		tabStyle := style
+		if t.buf == e.bufs[e.bufIdx] {
+			tabStyle = e.theme.apply(slotTabActive, style)
+		}
+
+		x := t.left
+		for _, r := range t.label {
+			w := runewidth.RuneWidth(r)
+			if x+w > width-1 {
+				break
+			}
+			e.scr.SetContent(x, 0, r, nil, tabStyle)
+			x += w
+		}
+	}
+
+	if e.tabOffset > 0 {
+		e.scr.SetContent(0, 0, '<', nil, style)
+	}
+	if len(tabs) > 0 && tabs[len(tabs)-1].buf != e.bufs[len(e.bufs)-1] {
+		e.scr.SetContent(width-1, 0, '>', nil, style)
+	}
+}
+
+// clickTab switches to the buffer whose tab is at column x of the tab bar, and
+// remembers it so that it can be dragged to another position. Clicking < or >
+// switches to the buffer before or after the tabs that are shown.
+func (e *editor) clickTab(x int) {
+	width, _ := e.scr.Size()
+
+	tabs := e.tabs(width)
+
+	idx := -1
+	switch {
+	case x == 0 && e.tabOffset > 0:
+		idx = e.tabOffset - 1
+	case x == width-1 && len(tabs) > 0:
+		if last := e.bufferIndex(tabs[len(tabs)-1].buf); last+1 < len(e.bufs) {
+			idx = last + 1
+		}
+	default:
+		if t := tabAt(tabs, x); t != nil {
+			idx = e.bufferIndex(t.buf)
+			e.draggedTab = t.buf
+		}
+	}
+
+	if idx < 0 {
+		log.Printf("clickTab: no tab at column %d", x)
+		return
+	}
+
+	log.Printf("clickTab: switching to buffer %d", idx)
+
+	e.bufIdx = idx
+	e.syncWindow()
+}
+
+// dropTab moves the buffer whose tab was dragged to the position of the tab at
+// column x of screen row y, where the mouse button was released.
+func (e *editor) dropTab(x, y int) {
+	buf := e.draggedTab
+	e.draggedTab = nil
+
+	if !e.tabBar || y != 0 {
+		return
+	}
+
+	width, _ := e.scr.Size()
+
+	t := tabAt(e.tabs(width), x)
+	if t == nil || t.buf == buf {
+		return
+	}
+
+	from, to := e.bufferIndex(buf), e.bufferIndex(t.buf)
+	if from < 0 {
+		log.Printf("dropTab: dragged buffer was closed")
+		return
+	}
+
+	log.Printf("dropTab: moving buffer %d to %d", from, to)
+
+	e.moveBuffer(from, to)
+}
+
+func tabAt(tabs []tab, x int) *tab {
+	for idx := range tabs {
+		if x >= tabs[idx].left && x < tabs[idx].left+tabs[idx].width {
+			return &tabs[idx]
+		}
+	}
+	return nil
+}
+
+// moveBuffer moves the buffer at index from to index to, the buffers in
+// between move up or down by one.
+func (e *editor) moveBuffer(from, to int) {
+	curBuf := e.bufs[e.bufIdx]
+	buf := e.bufs[from]
+
+	e.bufs = append(e.bufs[:from], e.bufs[from+1:]...)
+	e.bufs = append(e.bufs[:to], append([]*buffer{buf}, e.bufs[to:]...)...)
+
+	e.bufIdx = e.bufferIndex(curBuf)
+}
+
+// toggleTabBar shows or hides the tab bar.
+func (e *editor) toggleTabBar() {
+	e.tabBar = !e.tabBar
+
+	log.Printf("toggleTabBar: tab bar shown: %t", e.tabBar)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// nameBuffers names the buffers of ed after their text, so that the tests can
+// use file names as the text of buffers.
+func nameBuffers(ed *editor) {
+	for _, buf := range ed.bufs {
+		buf.fname = string(buf.line(0))
+	}
+}
+
+func mouseAt(t *testing.T, ed *editor, x, y int, buttons tcell.ButtonMask) {
+	require.NoError(t, ed.scr.PostEvent(tcell.NewEventMouse(x, y, buttons, 0)))
+	ed.handleEvent()
+}
+
+func TestTabBar(t *testing.T) {
+	ed := newTestEditor(t, "a.txt", "dir/b.txt")
+	nameBuffers(ed)
+	ed.tabBar = true
+	ed.bufs = append(ed.bufs, newBufferFromFileContent(nil))
+	ed.bufs[2].modified = true
+	scr := ed.scr.(tcell.SimulationScreen)
+
+	ed.redrawScreen()
+
+	require.Equal(t, "  a.txt  b.txt  <no file> (buffer 3)*", screenRow(scr, 0))
+	require.Equal(t, "a.txt", screenRow(scr, 1), "the text starts below the tab bar")
+
+	cells, _, _ := scr.GetContents()
+	require.Equal(t, ed.theme.apply(slotTabActive, ed.theme.style(slotTabBar)), cells[2].Style, "the tab of the current buffer is highlighted")
+	require.Equal(t, ed.theme.style(slotTabBar), cells[9].Style)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyCtrlB, 0, 0))
+	ed.redrawScreen()
+
+	require.False(t, ed.tabBar)
+	require.Equal(t, "a.txt", screenRow(scr, 0))
+}
+
+func TestTabBarScrolls(t *testing.T) {
+	var fnames []string
+	for i := 0; i < 10; i++ {
+		fnames = append(fnames, fmt.Sprintf("file%d.txt", i))
+	}
+	ed := newTestEditor(t, fnames...)
+	nameBuffers(ed)
+	ed.tabBar = true
+	scr := ed.scr.(tcell.SimulationScreen)
+
+	ed.redrawScreen()
+	require.Equal(t, "  file0.txt  file1.txt  file2.txt      >", screenRow(scr, 0))
+
+	ed.bufIdx = 4
+	ed.redrawScreen()
+	require.Equal(t, "< file2.txt  file3.txt  file4.txt      >", screenRow(scr, 0), "the tab of the current buffer is shown")
+
+	mouseAt(t, ed, 39, 0, tcell.Button1)
+	require.Equal(t, 5, ed.bufIdx)
+	mouseAt(t, ed, 39, 0, tcell.ButtonNone)
+
+	mouseAt(t, ed, 0, 0, tcell.Button1)
+	require.Equal(t, 2, ed.bufIdx)
+	mouseAt(t, ed, 0, 0, tcell.ButtonNone)
+
+	ed.bufIdx = 9
+	ed.redrawScreen()
+	require.Equal(t, "< file7.txt  file8.txt  file9.txt", screenRow(scr, 0))
+}
+
+func TestTabLabel(t *testing.T) {
+	testData := map[string]struct {
+		fname    string
+		modified bool
+		label    string
+	}{
+		"short":    {"notes.txt", false, " notes.txt "},
+		"modified": {"notes.txt", true, " notes.txt* "},
+		"long":     {"a_rather_long_file_name.txt", false, " <er_long_file_name.txt "},
+		"no file":  {"", false, " <no file> (buffer 1) "},
+	}
+
+	for name, tt := range testData {
+		t.Run(name, func(t *testing.T) {
+			ed := newTestEditor(t, tt.fname)
+			nameBuffers(ed)
+			buf := ed.bufs[0]
+			buf.modified = tt.modified
+			require.Equal(t, tt.label, ed.tabLabel(buf))
+		})
+	}
+}
+
+func TestClickAndDragTabs(t *testing.T) {
+	ed := newTestEditor(t, "a", "b", "c")
+	nameBuffers(ed)
+	ed.tabBar = true
+	scr := ed.scr.(tcell.SimulationScreen)
+
+	mouseAt(t, ed, 5, 0, tcell.Button1)
+	mouseAt(t, ed, 5, 0, tcell.ButtonNone)
+	require.Equal(t, 1, ed.bufIdx)
+
+	ed.redrawScreen()
+	require.Equal(t, "b", screenRow(scr, 1))
+
+	// drag the tab of a onto the tab of c.
+	mouseAt(t, ed, 2, 0, tcell.Button1)
+	require.Equal(t, 0, ed.bufIdx)
+	mouseAt(t, ed, 8, 0, tcell.ButtonNone)
+
+	ed.redrawScreen()
+	require.Equal(t, "  b  c  a", screenRow(scr, 0))
+	require.Equal(t, 2, ed.bufIdx, "the dragged buffer stays the current one")
+	require.Equal(t, "a", screenRow(scr, 1))
+
+	// releasing the button outside the tab bar doesn't move the tab.
+	mouseAt(t, ed, 2, 0, tcell.Button1)
+	mouseAt(t, ed, 2, 3, tcell.ButtonNone)
+	require.Equal(t, []string{"b", "c", "a"}, []string{ed.bufs[0].fname, ed.bufs[1].fname, ed.bufs[2].fname})
+	require.Nil(t, ed.draggedTab)
+}
+
+func TestClickBelowTabBar(t *testing.T) {
+	ed := newTestEditor(t, "hello")
+	ed.tabBar = true
+
+	mouseAt(t, ed, 3, 1, tcell.Button1)
+	require.Equal(t, 3, ed.win.view.x, "clicks into the text take the tab bar into account")
+	require.Equal(t, 0, ed.win.view.curLineIdx())
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// the style slots of a theme. Apart from text, the style of a slot is applied
+// on top of the style of the text that it is drawn over, so e.g. a current
+// line with only a background color keeps the colors of syntax highlighting.
+const (
+	slotText              = "text"
+	slotCurrentLine       = "currentLine"
+	slotSelection         = "selection"
+	slotSearchMatch       = "searchMatch"
+	slotStatusBar         = "statusBar"
+	slotStatusBarInactive = "statusBarInactive" // other splits' status lines and the columns between splits.
+	slotTitle             = "title"             // title bars of the help and the undo tree browser.
+	slotPrompt            = "prompt"
+	slotNonText           = "nonText" // ~ after the end of the text.
+	slotGutter            = "gutter"
+	slotGutterCurrent     = "gutterCurrent"
+	slotMarkerModified    = "markerModified"
+	slotMarkerBookmark    = "markerBookmark"
+	slotMarkerDiagnostic  = "markerDiagnostic"
+	slotTabBar            = "tabBar"
+	slotTabActive         = "tabActive" // tab of the current buffer, applied on tabBar.
+)
+
+// styleSlots returns the names of all style slots, including those of the
+// token classes of syntax highlighting, which are syntax.<class>.
+func styleSlots() map[string]bool {
+	slots := map[string]bool{}
+	for _, slot := range []string{slotText, slotCurrentLine, slotSelection, slotSearchMatch, slotStatusBar, slotStatusBarInactive, slotTitle, slotPrompt, slotNonText, slotGutter, slotGutterCurrent, slotMarkerModified, slotMarkerBookmark, slotMarkerDiagnostic, slotTabBar, slotTabActive} {
+		slots[slot] = true
+	}
+	for name := range tokenClasses {
+		slots["syntax."+name] = true
+	}
+	return slots
+}
+
+// styleSpec is the style of a slot. Colors are names like "yellow", "default"
+// for the terminal's default color, or RGB colors like "#ffd700". Unset
+// colors and attributes are those of the style the slot is applied on.
+type styleSpec struct {
+	Fg        string `json:"fg,omitempty"`
+	Bg        string `json:"bg,omitempty"`
+	Bold      *bool  `json:"bold,omitempty"`
+	Italic    *bool  `json:"italic,omitempty"`
+	Underline *bool  `json:"underline,omitempty"`
+	Reverse   *bool  `json:"reverse,omitempty"`
+
+	fg, bg tcell.Color // Fg and Bg for the colors of the screen.
+}
+
+func parseColor(name string) (tcell.Color, error) {
+	if strings.EqualFold(name, "default") {
+		return tcell.ColorDefault, nil
+	}
+	if c := tcell.GetColor(strings.ToLower(name)); c != tcell.ColorDefault {
+		return c, nil
+	}
+	return tcell.ColorDefault, fmt.Errorf("unknown color %q", name)
+}
+
+// degradeColor returns the color closest to c that a screen with the given
+// number of colors can show. RGB colors get one of the colors 16 to 255 on
+// screens with 256 colors, which are the same everywhere, unlike the first 16
+// colors that terminals let users change. Palette colors beyond the colors of
+// the screen get the closest of those, e.g. gray becomes silver on screens
+// with 8 colors.
+func degradeColor(c tcell.Color, colors int) tcell.Color {
+	if !c.Valid() || colors >= 1<<24 {
+		return c
+	}
+	if !c.IsRGB() && int(c-tcell.ColorValid) < colors {
+		return c
+	}
+	if colors < 8 {
+		return tcell.ColorDefault
+	}
+
+	first, n := 0, colors
+	if colors >= 256 {
+		first, n = 16, 256
+	}
+
+	palette := make([]tcell.Color, 0, n-first)
+	for i := first; i < n; i++ {
+		palette = append(palette, tcell.PaletteColor(i))
+	}
+
+	return tcell.FindColor(c, palette)
+}
+
+// resolve sets the colors of s for a screen with the given number of colors.
+func (s *styleSpec) resolve(colors int) {
+	s.fg, _ = parseColor(s.Fg)
+	s.bg, _ = parseColor(s.Bg)
+	s.fg, s.bg = degradeColor(s.fg, colors), degradeColor(s.bg, colors)
+}
+
+func (s *styleSpec) apply(style tcell.Style) tcell.Style {
+	if s.Fg != "" {
+		style = style.Foreground(s.fg)
+	}
+	if s.Bg != "" {
+		style = style.Background(s.bg)
+	}
+	if s.Bold != nil {
+		style = style.Bold(*s.Bold)
+	}
+	if s.Italic != nil {
+		style = style.Italic(*s.Italic)
+	}
+	if s.Underline != nil {
+		style = style.Underline(*s.Underline)
+	}
+	if s.Reverse != nil {
+		style = style.Reverse(*s.Reverse)
+	}
+	return style
+}
+
+// theme is a set of styles of the style slots, loaded from a JSON file.
+type theme struct {
+	Name   string                `json:"name"`
+	Styles map[string]*styleSpec `json:"styles"`
+
+	syntax map[tokenClass]*styleSpec // the styles of syntax.<class> slots.
+}
+
+//go:embed themes/*.json
+var builtinThemeFiles embed.FS
+
+// parseTheme parses the theme data of the file fname.
+func parseTheme(fname string, data []byte) (*theme, error) {
+	var t theme
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+
+	if t.Name == "" {
+		return nil, fmt.Errorf("%s: theme has no name", fname)
+	}
+
+	var errs configErrors
+
+	slots := styleSlots()
+
+	names := make([]string, 0, len(t.Styles))
+	for name := range t.Styles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !slots[name] {
+			errs = append(errs, fmt.Errorf("%s: unknown style %q", fname, name))
+			continue
+		}
+		for _, color := range []string{t.Styles[name].Fg, t.Styles[name].Bg} {
+			if _, err := parseColor(color); color != "" && err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", fname, name, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	t.syntax = map[tokenClass]*styleSpec{}
+	for name, class := range tokenClasses {
+		if spec, ok := t.Styles["syntax."+name]; ok {
+			t.syntax[class] = spec
+		}
+	}
+
+	return &t, nil
+}
+
+// builtinThemes returns the themes that come with exa.
+func builtinThemes() []*theme {
+	var themes []*theme
+
+	fnames, _ := fs.Glob(builtinThemeFiles, "themes/*.json")
+	for _, fname := range fnames {
+		data, err := builtinThemeFiles.ReadFile(fname)
+		if err != nil {
+			panic(err)
+		}
+		t, err := parseTheme(fname, data)
+		if err != nil {
+			panic(err)
+		}
+		themes = append(themes, t)
+	}
+
+	return themes
+}
+
+// loadThemes loads the themes in the *.json files in dir. They replace
+// built-in themes of the same name.
+func (e *editor) loadThemes(dir string) error {
+	fnames, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(fnames)
+
+	var errs configErrors
+
+	for _, fname := range fnames {
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		t, err := parseTheme(fname, data)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		replaced := false
+		for idx := range e.themes {
+			if strings.EqualFold(e.themes[idx].Name, t.Name) {
+				e.themes[idx], replaced = t, true
+			}
+		}
+		if !replaced {
+			e.themes = append(e.themes, t)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	log.Printf("loadThemes: loaded %d themes from %s", len(fnames), dir)
+
+	return nil
+}
+
+// setTheme switches to the theme called name.
+func (e *editor) setTheme(name string) error {
+	for _, t := range e.themes {
+		if strings.EqualFold(t.Name, name) {
+			e.theme = t
+			e.applyTheme()
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown theme %q", name)
+}
+
+// applyTheme adapts the colors of the current theme to the screen.
+func (e *editor) applyTheme() {
+	colors := e.scr.Colors()
+
+	log.Printf("applyTheme: using theme %s with %d colors", e.theme.Name, colors)
+
+	for _, spec := range e.theme.Styles {
+		spec.resolve(colors)
+	}
+
+	e.scr.SetStyle(e.theme.style(slotText))
+}
+
+// style returns the style of slot applied on the style of the text.
+func (t *theme) style(slot string) tcell.Style {
+	style := tcell.StyleDefault
+	if spec, ok := t.Styles[slotText]; ok {
+		style = spec.apply(style)
+	}
+	return t.apply(slot, style)
+}
+
+// apply returns the style of slot applied on style.
+func (t *theme) apply(slot string, style tcell.Style) tcell.Style {
+	if spec, ok := t.Styles[slot]; ok {
+		return spec.apply(style)
+	}
+	return style
+}
+
+// syntaxStyle returns the style of the token class applied on style.
+func (t *theme) syntaxStyle(class tokenClass, style tcell.Style) tcell.Style {
+	if spec, ok := t.syntax[class]; ok {
+		return spec.apply(style)
+	}
+	return style
+}
+
+// themeNames returns the names of all themes.
+func (e *editor) themeNames() []string {
+	var names []string
+	for _, t := range e.themes {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// selectTheme switches to another theme.
+func (e *editor) selectTheme() {
+	name, ok := e.readString(fmt.Sprintf("Theme (%s)", strings.Join(e.themeNames(), ", ")), []rune(e.theme.Name))
+	if !ok {
+		log.Printf("selectTheme: cancelled")
+		return
+	}
+
+	if err := e.setTheme(name); err != nil {
+		log.Printf("selectTheme: %v", err)
+		e.showError("Unknown theme %s", name)
+		return
+	}
+
+	e.showError("Theme %s", e.theme.Name)
+}
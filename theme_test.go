@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDegradeColor(t *testing.T) {
+	testData := map[string]struct {
+		color    tcell.Color
+		colors   int
+		expected tcell.Color
+	}{
+		"truecolor":            {tcell.NewHexColor(0x1c1c1c), 1 << 24, tcell.NewHexColor(0x1c1c1c)},
+		"256 colors":           {tcell.NewHexColor(0x1c1c1c), 256, tcell.PaletteColor(234)},
+		"256 colors, red":      {tcell.NewHexColor(0xff0000), 256, tcell.PaletteColor(196)},
+		"16 colors":            {tcell.NewHexColor(0xff0000), 16, tcell.ColorRed},
+		"8 colors":             {tcell.NewHexColor(0x00ff00), 8, tcell.ColorGreen},
+		"named color":          {tcell.ColorOlive, 8, tcell.ColorOlive},
+		"yellow, 8 colors":     {tcell.ColorYellow, 8, tcell.ColorOlive},
+		"default color":        {tcell.ColorDefault, 256, tcell.ColorDefault},
+		"monochrome":           {tcell.NewHexColor(0xff0000), 2, tcell.ColorDefault},
+		"256 colors, almost":   {tcell.NewHexColor(0x1d1d1d), 256, tcell.PaletteColor(234)},
+		"gray, 8 colors":       {tcell.ColorGray, 8, tcell.ColorSilver},
+		"bright red, 8 colors": {tcell.ColorRed, 8, tcell.ColorMaroon},
+		"palette, 16 colors":   {tcell.PaletteColor(196), 16, tcell.ColorRed},
+		"palette, 256 colors":  {tcell.PaletteColor(196), 256, tcell.PaletteColor(196)},
+		"palette, monochrome":  {tcell.ColorGray, 2, tcell.ColorDefault},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			require.Equal(t, tt.expected, degradeColor(tt.color, tt.colors))
+		})
+	}
+}
+
+func TestSetTheme(t *testing.T) {
+	ed := newTestEditor(t, "foo\nbar")
+	buf := ed.bufs[0]
+	v := buf.lastView
+	scr := ed.scr.(tcell.SimulationScreen)
+
+	require.ElementsMatch(t, []string{"default", "dark", "light"}, ed.themeNames())
+
+	require.Error(t, ed.setTheme("does-not-exist"))
+	require.Equal(t, "default", ed.theme.Name)
+
+	require.NoError(t, ed.setTheme("dark"))
+
+	v.selecting, v.startY, v.startX, v.endY, v.endX = true, 1, 0, 1, 1
+	ed.redrawScreen()
+
+	cells, width, height := scr.GetContents()
+
+	// the simulation screen has 256 colors.
+	fg, bg, _ := cells[0].Style.Decompose()
+	require.Equal(t, []tcell.Color{tcell.PaletteColor(252), tcell.PaletteColor(235)}, []tcell.Color{fg, bg}, "current line")
+
+	fg, bg, _ = cells[width].Style.Decompose()
+	require.Equal(t, []tcell.Color{tcell.PaletteColor(231), tcell.PaletteColor(60)}, []tcell.Color{fg, bg}, "selection")
+
+	_, bg, _ = cells[width+1].Style.Decompose()
+	require.Equal(t, tcell.PaletteColor(234), bg, "text")
+
+	_, bg, _ = cells[(height-2)*width].Style.Decompose()
+	require.Equal(t, tcell.PaletteColor(237), bg, "status bar")
+}
+
+func TestLoadThemes(t *testing.T) {
+	ed := newTestEditor(t, "")
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dark.json"), []byte(`{"name": "Dark", "styles": {"text": {"fg": "white", "bg": "black"}}}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "mine.json"), []byte(`{"name": "mine", "styles": {"syntax.comment": {"fg": "#808080", "italic": true}}}`), 0644))
+
+	require.NoError(t, ed.loadThemes(dir))
+	require.ElementsMatch(t, []string{"default", "Dark", "light", "mine"}, ed.themeNames())
+
+	require.NoError(t, ed.setTheme("dark"))
+	require.Equal(t, tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorBlack), ed.theme.style(slotCurrentLine), "slots without style look like text")
+
+	require.NoError(t, ed.setTheme("mine"))
+	require.Equal(t, tcell.StyleDefault.Foreground(tcell.PaletteColor(244)).Italic(true), ed.theme.syntaxStyle(classComment, tcell.StyleDefault))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.json"), []byte(`{"name": "bad", "styles": {"nonsense": {}, "text": {"fg": "nocolor"}}}`), 0644))
+	err := ed.loadThemes(dir)
+	require.Error(t, err)
+	require.Len(t, err.(configErrors)[0].(configErrors), 2)
+
+	require.NoError(t, ed.loadThemes(filepath.Join(dir, "does-not-exist")))
+}
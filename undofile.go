@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// undoMaxSize limits the size of the edit operations stored in an undo
+	// file. The oldest states are dropped to stay below it.
+	undoMaxSize = 1 << 20
+
+	// undoFileVersion is the version of the undo file format. Undo files of
+	// other versions are ignored.
+	undoFileVersion = 1
+
+	// undoMaxAge is how long undo files are kept after the file was last
+	// saved.
+	undoMaxAge = 30 * 24 * time.Hour
+)
+
+// undoFile is the persistent undo tree of a file. It only applies to the
+// file if its content still has the hash it had when it was saved.
+type undoFile struct {
+	Version int            `json:"version"`
+	Path    string         `json:"path"`
+	Hash    string         `json:"hash"`
+	Saved   time.Time      `json:"saved"`
+	Current int            `json:"current"` // the state that the file was saved in.
+	Nodes   []undoFileNode `json:"nodes"`   // in order of creation, starting with the root.
+}
+
+type undoFileNode struct {
+	Seq    int        `json:"seq"`
+	Parent int        `json:"parent"` // -1 for the root.
+	Redo   int        `json:"redo"`   // the child that redo goes to.
+	Time   time.Time  `json:"time"`
+	Op     *undoOp    `json:"op,omitempty"`
+	Before undoCursor `json:"before"` // the cursor before and after the change.
+	After  undoCursor `json:"after"`
+}
+
+// undoCursor is the position of the cursor of a state. Selections aren't kept
+// across sessions.
+type undoCursor struct {
+	Y int `json:"y"`
+	X int `json:"x"`
+}
+
+type undoOp struct {
+	Op       opcode   `json:"op"`
+	Text     []string `json:"text,omitempty"`
+	Y        int      `json:"y"`
+	X        int      `json:"x"`
+	Children []undoOp `json:"children,omitempty"`
+}
+
+func newUndoOp(op *editOp) undoOp {
+	u := undoOp{Op: op.op, Y: op.y, X: op.x}
+	for _, line := range op.text {
+		u.Text = append(u.Text, string(line))
+	}
+	for _, child := range op.children {
+		u.Children = append(u.Children, newUndoOp(child))
+	}
+	return u
+}
+
+func (u undoOp) editOp() (*editOp, error) {
+	op := &editOp{op: u.Op, y: u.Y, x: u.X, finished: true}
+
+	switch u.Op {
+	case opInsertText, opRemoveText:
+		if len(u.Text) == 0 {
+			return nil, errors.New("operation without text")
+		}
+		for _, line := range u.Text {
+			op.text = append(op.text, []rune(line))
+		}
+	case opGroup:
+		for _, child := range u.Children {
+			childOp, err := child.editOp()
+			if err != nil {
+				return nil, err
+			}
+			op.children = append(op.children, childOp)
+		}
+	default:
+		return nil, fmt.Errorf("unknown operation %d", u.Op)
+	}
+
+	return op, nil
+}
+
+// undoFileName returns the name of the undo file for fname in the undo
+// directory and the absolute name of fname, or empty strings if undo history
+// isn't kept.
+func (e *editor) undoFileName(fname string) (name string, absName string) {
+	if e.undoDir == "" || fname == "" {
+		return "", ""
+	}
+
+	absName, err := filepath.Abs(fname)
+	if err != nil {
+		log.Printf("undoFileName: Abs %s failed: %v", fname, err)
+		return "", ""
+	}
+
+	hash := sha256.Sum256([]byte(absName))
+
+	return filepath.Join(e.undoDir, hex.EncodeToString(hash[:])+".json"), absName
+}
+
+// saveUndoHistory writes the undo tree of buf to its undo file. It must only
+// be called right after saving buf, so that the recorded hash matches the
+// current state.
+func (e *editor) saveUndoHistory(buf *buffer) error {
+	name, absName := e.undoFileName(buf.fname)
+	if name == "" {
+		return nil
+	}
+
+	tree := buf.history
+	nodes := tree.nodes()
+
+	ops := map[*undoNode]*undoOp{}
+	sizes := map[*undoNode]int{}
+	size := 0
+	for _, node := range nodes[1:] {
+		op := newUndoOp(node.op)
+		data, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		ops[node], sizes[node] = &op, len(data)
+		size += len(data)
+	}
+
+	keep, root := pruneUndoTree(tree, sizes, size)
+
+	uf := undoFile{
+		Version: undoFileVersion,
+		Path:    absName,
+		Hash:    hex.EncodeToString(buf.disk.hash[:]),
+		Saved:   time.Now(),
+		Current: tree.cur.seq,
+	}
+
+	for _, node := range nodes {
+		if !keep[node] {
+			continue
+		}
+		n := undoFileNode{
+			Seq:    node.seq,
+			Parent: -1,
+			Redo:   -1,
+			Time:   node.time,
+			Before: undoCursor{Y: node.before.y, X: node.before.x},
+			After:  undoCursor{Y: node.after.y, X: node.after.x},
+		}
+		if node != root {
+			n.Parent, n.Op = node.parent.seq, ops[node]
+		}
+		if len(node.children) > 0 {
+			n.Redo = node.children[node.redoIdx].seq
+		}
+		uf.Nodes = append(uf.Nodes, n)
+	}
+
+	if err := os.MkdirAll(e.undoDir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(uf)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("saveUndoHistory: writing %d states for %s to %s", len(uf.Nodes), absName, name)
+
+	if err := writeFileAtomically(name, 0600, writeBytes(data)); err != nil {
+		return err
+	}
+
+	e.pruneUndoFiles()
+
+	return nil
+}
+
+// pruneUndoTree decides which states of tree to keep so that the size of
+// their operations stays below undoMaxSize. It drops the oldest of the
+// branches that don't lead to the current state, or the root if it has only
+// one child, until the size fits. That child then becomes the new root.
+func pruneUndoTree(tree *undoTree, sizes map[*undoNode]int, size int) (keep map[*undoNode]bool, root *undoNode) {
+	nodes := tree.nodes()
+
+	keep = map[*undoNode]bool{}
+	children := map[*undoNode]int{}
+	for _, node := range nodes {
+		keep[node] = true
+		children[node] = len(node.children)
+	}
+
+	curPath := map[*undoNode]bool{}
+	for _, node := range tree.cur.path() {
+		curPath[node] = true
+	}
+
+	root = tree.root
+
+	for size > undoMaxSize {
+		var leaf, rootChild *undoNode
+		for _, node := range nodes {
+			if !keep[node] {
+				continue
+			}
+			if leaf == nil && children[node] == 0 && !curPath[node] {
+				leaf = node
+			}
+			if node.parent == root && children[root] == 1 && root != tree.cur {
+				rootChild = node
+			}
+		}
+
+		switch {
+		case rootChild != nil && (leaf == nil || rootChild.seq < leaf.seq):
+			keep[root] = false
+			root = rootChild
+			size -= sizes[rootChild]
+		case leaf != nil:
+			keep[leaf] = false
+			children[leaf.parent]--
+			size -= sizes[leaf]
+		default:
+			return keep, root
+		}
+	}
+
+	return keep, root
+}
+
+// loadUndoHistory restores the undo tree of buf from its undo file if the
+// file wasn't changed since the tree was saved.
+func (e *editor) loadUndoHistory(buf *buffer) error {
+	name, absName := e.undoFileName(buf.fname)
+	if name == "" || !buf.disk.exists {
+		return nil
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	var uf undoFile
+	if err := json.Unmarshal(data, &uf); err != nil {
+		return err
+	}
+
+	if uf.Version != undoFileVersion {
+		log.Printf("loadUndoHistory: ignoring %s with version %d", name, uf.Version)
+		return nil
+	}
+
+	if uf.Path != absName || uf.Hash != hex.EncodeToString(buf.disk.hash[:]) {
+		log.Printf("loadUndoHistory: undo history in %s doesn't match %s", name, absName)
+		return nil
+	}
+
+	if time.Since(uf.Saved) > undoMaxAge {
+		log.Printf("loadUndoHistory: undo history in %s is too old", name)
+		return nil
+	}
+
+	tree := &undoTree{}
+	nodes := map[int]*undoNode{}
+	redo := map[*undoNode]int{}
+
+	for idx, n := range uf.Nodes {
+		node := &undoNode{
+			seq:    n.Seq,
+			time:   n.Time,
+			before: cursorState{y: n.Before.Y, x: n.Before.X},
+			after:  cursorState{y: n.After.Y, x: n.After.X},
+		}
+		if _, ok := nodes[n.Seq]; ok {
+			return fmt.Errorf("duplicate state %d", n.Seq)
+		}
+
+		if idx == 0 {
+			tree.root = node
+		} else {
+			parent := nodes[n.Parent]
+			if parent == nil || n.Op == nil {
+				return fmt.Errorf("invalid state %d", n.Seq)
+			}
+			if node.op, err = n.Op.editOp(); err != nil {
+				return err
+			}
+			node.parent = parent
+			parent.children = append(parent.children, node)
+		}
+
+		nodes[n.Seq], redo[node] = node, n.Redo
+		if n.Seq > tree.seq {
+			tree.seq = n.Seq
+		}
+	}
+
+	if tree.cur = nodes[uf.Current]; tree.cur == nil {
+		return fmt.Errorf("invalid current state %d", uf.Current)
+	}
+
+	for node, seq := range redo {
+		if child := nodes[seq]; child != nil && child.parent == node {
+			node.redoIdx = node.childIndex(child)
+		}
+	}
+
+	log.Printf("loadUndoHistory: loaded %d states for %s from %s", len(nodes), absName, name)
+
+	buf.history = tree
+	buf.savedState = tree.cur
+
+	return nil
+}
+
+// pruneUndoFiles removes undo files of files that weren't saved for longer
+// than undoMaxAge.
+func (e *editor) pruneUndoFiles() {
+	entries, err := os.ReadDir(e.undoDir)
+	if err != nil {
+		log.Printf("pruneUndoFiles: reading %s failed: %v", e.undoDir, err)
+		return
+	}
+
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil || time.Since(fi.ModTime()) <= undoMaxAge {
+			continue
+		}
+		name := filepath.Join(e.undoDir, entry.Name())
+		log.Printf("pruneUndoFiles: removing %s", name)
+		if err := os.Remove(name); err != nil {
+			log.Printf("pruneUndoFiles: removing %s failed: %v", name, err)
+		}
+	}
+}
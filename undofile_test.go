@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUndoHistoryAcrossSessions(t *testing.T) {
+	dir := t.TempDir()
+	undoDir := filepath.Join(dir, "undo")
+	fname := filepath.Join(dir, "file.txt")
+	require.NoError(t, os.WriteFile(fname, []byte("hello\n"), 0644))
+
+	ed := newTestEditor(t)
+	ed.undoDir = undoDir
+	require.NoError(t, ed.loadBufferFromFile(fname))
+
+	playKeys(t, ed,
+		tcell.NewEventKey(tcell.KeyRune, 'a', 0),
+		tcell.NewEventKey(tcell.KeyRune, 'b', 0),
+		tcell.NewEventKey(tcell.KeyEnter, 0, 0),
+		tcell.NewEventKey(tcell.KeyCtrlS, 0, 0),
+		tcell.NewEventKey(tcell.KeyDelete, 0, 0),
+		tcell.NewEventKey(tcell.KeyCtrlS, 0, 0),
+	)
+	require.Equal(t, [][]rune{[]rune("ab"), []rune("ello")}, ed.bufs[0].lines())
+
+	ed = newTestEditor(t)
+	ed.undoDir = undoDir
+	require.NoError(t, ed.loadBufferFromFile(fname))
+	require.Len(t, ed.bufs[0].history.nodes(), 3)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0))
+	require.Equal(t, [][]rune{[]rune("ab"), []rune("hello")}, ed.bufs[0].lines())
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0))
+	require.Equal(t, [][]rune{[]rune("hello")}, ed.bufs[0].lines())
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlR, 0, 0), tcell.NewEventKey(tcell.KeyCtrlR, 0, 0))
+	require.Equal(t, [][]rune{[]rune("ab"), []rune("ello")}, ed.bufs[0].lines())
+
+	// the history doesn't apply once the file was changed by something else.
+	require.NoError(t, os.WriteFile(fname, []byte("changed\n"), 0644))
+
+	ed = newTestEditor(t)
+	ed.undoDir = undoDir
+	require.NoError(t, ed.loadBufferFromFile(fname))
+	require.Len(t, ed.bufs[0].history.nodes(), 1)
+	require.Nil(t, ed.bufs[0].history.cur.op)
+}
+
+func TestUndoHistoryLimits(t *testing.T) {
+	dir := t.TempDir()
+	undoDir := filepath.Join(dir, "undo")
+	fname := filepath.Join(dir, "file.txt")
+	require.NoError(t, os.WriteFile(fname, nil, 0644))
+
+	ed := newTestEditor(t)
+	ed.undoDir = undoDir
+	require.NoError(t, ed.loadBufferFromFile(fname))
+
+	buf := ed.bufs[0]
+	v := buf.lastView
+	large := []rune(strings.Repeat("x", undoMaxSize/2))
+	for i := 0; i < 3; i++ {
+		v.insertText(0, 0, [][]rune{large})
+		buf.historyFinishOp()
+	}
+	ed.saveFile(buf)
+
+	ed = newTestEditor(t)
+	ed.undoDir = undoDir
+	require.NoError(t, ed.loadBufferFromFile(fname))
+	nodes := ed.bufs[0].history.nodes()
+	require.Len(t, nodes, 2, "the oldest states are dropped")
+	require.Equal(t, 2, nodes[0].seq)
+	require.Equal(t, 3, ed.bufs[0].history.cur.seq)
+
+	name, _ := ed.undoFileName(fname)
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+
+	var uf undoFile
+	require.NoError(t, json.Unmarshal(data, &uf))
+	uf.Saved = time.Now().Add(-undoMaxAge - time.Hour)
+	data, err = json.Marshal(uf)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(name, data, 0600))
+
+	ed = newTestEditor(t)
+	ed.undoDir = undoDir
+	require.NoError(t, ed.loadBufferFromFile(fname))
+	require.Len(t, ed.bufs[0].history.nodes(), 1, "old undo history is ignored")
+
+	modTime := time.Now().Add(-undoMaxAge - time.Hour)
+	require.NoError(t, os.Chtimes(name, modTime, modTime))
+	ed.pruneUndoFiles()
+	require.NoFileExists(t, name)
+}
+
+func TestUndoTreeAcrossSessions(t *testing.T) {
+	dir := t.TempDir()
+	undoDir := filepath.Join(dir, "undo")
+	fname := filepath.Join(dir, "file.txt")
+	require.NoError(t, os.WriteFile(fname, nil, 0644))
+
+	ed := newTestEditor(t)
+	ed.undoDir = undoDir
+	require.NoError(t, ed.loadBufferFromFile(fname))
+
+	playKeys(t, ed,
+		tcell.NewEventKey(tcell.KeyRune, 'a', 0),
+		tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, 'b', 0),
+		tcell.NewEventKey(tcell.KeyCtrlS, 0, 0),
+	)
+
+	ed = newTestEditor(t)
+	ed.undoDir = undoDir
+	require.NoError(t, ed.loadBufferFromFile(fname))
+
+	buf := ed.bufs[0]
+	v := buf.lastView
+	require.Len(t, buf.history.nodes(), 3)
+	require.Equal(t, 2, buf.history.cur.seq)
+	require.Len(t, buf.history.root.children, 2)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, 'b', 0))
+	require.Equal(t, [][]rune{[]rune("a")}, buf.lines())
+	require.Equal(t, 1, v.x, "the cursor of the state is restored")
+
+	// new changes continue the numbering of the loaded states.
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'c', 0))
+	require.Equal(t, 3, buf.history.cur.seq)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// undoTree keeps the complete edit history of a buffer. Every node is a
+// state of the text: the root is the text as it was loaded, every other node
+// is reached from its parent by applying the node's edit operation. Undoing
+// changes and then making a new change starts a new branch, so the changes
+// that were undone can still be reached.
+type undoTree struct {
+	root *undoNode
+	cur  *undoNode
+	seq  int // sequence number of the newest node.
+}
+
+type undoNode struct {
+	op       *editOp
+	parent   *undoNode
+	children []*undoNode // branches, oldest first.
+	redoIdx  int         // index of the child that redo goes to, the most recently visited one.
+	seq      int         // number of the node in order of creation, 0 for the root.
+	time     time.Time   // when the change was last extended.
+
+	// the cursor before and after the change.
+	before, after cursorState
+}
+
+// timeNow returns the current time. Tests replace it to control the times of
+// changes.
+var timeNow = time.Now
+
+func newUndoTree() *undoTree {
+	root := &undoNode{time: timeNow()}
+	return &undoTree{root: root, cur: root}
+}
+
+// add adds op as a new change to the current state and makes it current.
+func (t *undoTree) add(op *editOp) {
+	t.seq++
+	node := &undoNode{op: op, parent: t.cur, seq: t.seq, time: timeNow()}
+	t.cur.children = append(t.cur.children, node)
+	t.cur.redoIdx = len(t.cur.children) - 1
+	t.cur = node
+	log.Printf("undoTree.add: added state %d", node.seq)
+}
+
+// undo reverts the change of the current state and makes its parent current.
+// It returns the cursor from before the change, and false if there is
+// nothing to undo. v is the view that the change is undone in.
+func (t *undoTree) undo(v *view) (cursorState, bool) {
+	if t.cur.parent == nil {
+		return cursorState{}, false
+	}
+
+	node := t.cur
+	node.op.finished = true
+	node.op.undo(v)
+
+	t.cur = node.parent
+	t.cur.redoIdx = t.cur.childIndex(node)
+
+	return node.before, true
+}
+
+// redo applies the change of the most recently visited child of the current
+// state. It returns the cursor from after the change, and false if there is
+// nothing to redo.
+func (t *undoTree) redo(v *view) (cursorState, bool) {
+	if len(t.cur.children) == 0 {
+		return cursorState{}, false
+	}
+
+	t.cur = t.cur.children[t.cur.redoIdx]
+	t.cur.op.redo(v)
+
+	return t.cur.after, true
+}
+
+func (node *undoNode) childIndex(child *undoNode) int {
+	for idx, c := range node.children {
+		if c == child {
+			return idx
+		}
+	}
+	return -1
+}
+
+// path returns the nodes from the root to node.
+func (node *undoNode) path() []*undoNode {
+	var nodes []*undoNode
+	for ; node != nil; node = node.parent {
+		nodes = append([]*undoNode{node}, nodes...)
+	}
+	return nodes
+}
+
+// gotoNode changes the text to the state of node by undoing changes up to the
+// common ancestor of the current state and node, and then redoing the changes
+// down to node. It returns the cursor after the last change that was undone
+// or redone.
+func (t *undoTree) gotoNode(v *view, node *undoNode) (cs cursorState) {
+	log.Printf("undoTree.gotoNode: going from state %d to state %d", t.cur.seq, node.seq)
+
+	target := node.path()
+
+	onPath := map[*undoNode]bool{}
+	for _, n := range target {
+		onPath[n] = true
+	}
+
+	for !onPath[t.cur] {
+		cs, _ = t.undo(v)
+	}
+
+	for idx := len(t.cur.path()); idx < len(target); idx++ {
+		t.cur.redoIdx = t.cur.childIndex(target[idx])
+		cs, _ = t.redo(v)
+	}
+
+	return cs
+}
+
+// nodes returns all nodes of the tree in order of their creation.
+func (t *undoTree) nodes() []*undoNode {
+	var nodes []*undoNode
+
+	var walk func(node *undoNode)
+	walk = func(node *undoNode) {
+		nodes = append(nodes, node)
+		for _, child := range node.children {
+			walk(child)
+		}
+	}
+	walk(t.root)
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].seq < nodes[j].seq
+	})
+
+	return nodes
+}
+
+// nodeAt returns the state that the text was in at time tm, i.e. the most
+// recent change made at or before tm, or the root if there is none.
+func (t *undoTree) nodeAt(tm time.Time) *undoNode {
+	result := t.root
+	for _, node := range t.nodes() {
+		if !node.time.After(tm) && node.time.After(result.time) {
+			result = node
+		}
+	}
+	return result
+}
+
+// undoTreeLine is a line of the visual representation of an undo tree.
+type undoTreeLine struct {
+	node *undoNode
+	text string
+}
+
+// lines draws the tree with the newest states first. Each branch gets a
+// column of its own, the branch that a state was first reached through
+// continues in the same column.
+func (t *undoTree) lines() []undoTreeLine {
+	var lines []undoTreeLine
+
+	var walk func(node *undoNode, indent string)
+	walk = func(node *undoNode, indent string) {
+		// newer branches are drawn further right, above the older ones.
+		for i := len(node.children) - 1; i > 0; i-- {
+			walk(node.children[i], indent+"| ")
+		}
+		if len(node.children) > 0 {
+			walk(node.children[0], indent)
+		}
+
+		marker := "o"
+		if node == t.cur {
+			marker = "@"
+		}
+		lines = append(lines, undoTreeLine{node: node, text: fmt.Sprintf("%s%s %d %s %s", indent, marker, node.seq, node.time.Format("2006-01-02 15:04:05"), node.describe())})
+	}
+	walk(t.root, "")
+
+	return lines
+}
+
+func (node *undoNode) describe() string {
+	if node.op == nil {
+		return "original text"
+	}
+	return node.op.describe()
+}
+
+func (op *editOp) describe() string {
+	switch op.op {
+	case opInsertText:
+		return fmt.Sprintf("inserted %s at %d:%d", describeText(op.text), op.y, op.x)
+	case opRemoveText:
+		return fmt.Sprintf("removed %s at %d:%d", describeText(op.text), op.y, op.x)
+	case opGroup:
+		return fmt.Sprintf("%d changes", len(op.children))
+	}
+	return "unknown change"
+}
+
+func describeText(text [][]rune) string {
+	const maxLen = 30
+
+	s := string(joinLines(text))
+	if runes := []rune(s); len(runes) > maxLen {
+		s = string(runes[:maxLen]) + "..."
+	}
+
+	return fmt.Sprintf("%q", s)
+}
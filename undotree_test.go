@@ -0,0 +1,206 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock makes timeNow return t0, advanced by one minute on every call.
+func fakeClock(t *testing.T, t0 time.Time) {
+	now := t0
+	timeNow = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	t.Cleanup(func() {
+		timeNow = time.Now
+	})
+}
+
+func TestUndoTreeKeepsBranches(t *testing.T) {
+	fakeClock(t, time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC))
+
+	ed := newTestEditor(t, "")
+	buf := ed.bufs[0]
+
+	playKeys(t, ed,
+		tcell.NewEventKey(tcell.KeyRune, 'a', 0),
+		tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, 'b', 0),
+	)
+	require.Equal(t, [][]rune{[]rune("b")}, buf.lines())
+
+	var texts []string
+	for _, line := range buf.history.lines() {
+		texts = append(texts, line.text)
+	}
+	require.Equal(t, []string{
+		`| @ 2 2021-04-01 12:03:00 inserted "b" at 0:0`,
+		`o 1 2021-04-01 12:02:00 inserted "a" at 0:0`,
+		`o 0 2021-04-01 12:01:00 original text`,
+	}, texts)
+
+	// undoing the new branch and redoing follows the branch that was visited last.
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0), tcell.NewEventKey(tcell.KeyCtrlR, 0, 0))
+	require.Equal(t, [][]rune{[]rune("b")}, buf.lines())
+
+	// switching the branch goes to the other change of the same state.
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, 'b', 0))
+	require.Equal(t, [][]rune{[]rune("a")}, buf.lines())
+	require.Equal(t, 1, buf.history.cur.seq)
+
+	// at the branching point, switching the branch selects where redo goes.
+	playKeys(t, ed,
+		tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, 'b', 0),
+		tcell.NewEventKey(tcell.KeyCtrlR, 0, 0),
+	)
+	require.Equal(t, [][]rune{[]rune("b")}, buf.lines())
+}
+
+func TestUndoOlderNewer(t *testing.T) {
+	ed := newTestEditor(t, "")
+	buf := ed.bufs[0]
+
+	playKeys(t, ed,
+		tcell.NewEventKey(tcell.KeyRune, 'a', 0),
+		tcell.NewEventKey(tcell.KeyLeft, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, 'b', 0),
+		tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0),
+		tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, 'c', 0),
+	)
+	require.Equal(t, [][]rune{[]rune("c")}, buf.lines())
+
+	expected := [][][]rune{
+		{[]rune("ba")},
+		{[]rune("a")},
+		{[]rune("")},
+	}
+	for _, lines := range expected {
+		playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, '-', 0))
+		require.Equal(t, lines, buf.lines())
+	}
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, '-', 0))
+	require.Equal(t, 0, buf.history.cur.seq)
+
+	for i := 0; i < 3; i++ {
+		playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, '+', 0))
+	}
+	require.Equal(t, [][]rune{[]rune("c")}, buf.lines())
+	require.True(t, buf.modified)
+}
+
+func TestTimeTravel(t *testing.T) {
+	t0 := time.Date(2021, 4, 1, 12, 0, 0, 0, time.UTC)
+	fakeClock(t, t0)
+
+	ed := newTestEditor(t, "")
+	buf := ed.bufs[0]
+
+	// the clock advances by a minute whenever a change is made or extended,
+	// moving the cursor ends a change: "a" at 12:02, "a\nb" at 12:04 and
+	// "a\nb\nc" at 12:06.
+	playKeys(t, ed,
+		tcell.NewEventKey(tcell.KeyRune, 'a', 0),
+		tcell.NewEventKey(tcell.KeyLeft, 0, 0),
+		tcell.NewEventKey(tcell.KeyRight, 0, 0),
+		tcell.NewEventKey(tcell.KeyEnter, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, 'b', 0),
+		tcell.NewEventKey(tcell.KeyLeft, 0, 0),
+		tcell.NewEventKey(tcell.KeyRight, 0, 0),
+		tcell.NewEventKey(tcell.KeyEnter, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, 'c', 0),
+	)
+	require.Equal(t, [][]rune{[]rune("a"), []rune("b"), []rune("c")}, buf.lines())
+
+	require.Equal(t, buf.history.root, buf.history.nodeAt(t0))
+
+	testData := []struct {
+		input    string
+		expected [][]rune
+	}{
+		{"2m", [][]rune{[]rune("a"), []rune("b")}},
+		{"90s", [][]rune{[]rune("a")}},
+		{"10m", [][]rune{[]rune("")}},
+		{"-3m", [][]rune{[]rune("a"), []rune("b")}},
+	}
+
+	for _, tt := range testData {
+		keys := []*tcell.EventKey{tcell.NewEventKey(tcell.KeyRune, 't', 0)}
+		for _, r := range tt.input {
+			keys = append(keys, tcell.NewEventKey(tcell.KeyRune, r, 0))
+		}
+		keys = append(keys, tcell.NewEventKey(tcell.KeyEnter, 0, 0))
+
+		require.NoError(t, ed.scr.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt)))
+		ed.handleEvent()
+		postKeys(ed, keys...)
+		ed.handleEvent()
+
+		require.Equal(t, tt.expected, buf.lines(), "input %s", tt.input)
+	}
+}
+
+func TestBrowseUndoTree(t *testing.T) {
+	ed := newTestEditor(t, "")
+	buf := ed.bufs[0]
+
+	playKeys(t, ed,
+		tcell.NewEventKey(tcell.KeyRune, 'a', 0),
+		tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0),
+		tcell.NewEventKey(tcell.KeyRune, 'b', 0),
+	)
+
+	// the list starts with the newest state: b, then a, then the original text.
+	browse := func(keys ...*tcell.EventKey) {
+		require.NoError(t, ed.scr.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt)))
+		ed.handleEvent()
+		postKeys(ed, append([]*tcell.EventKey{tcell.NewEventKey(tcell.KeyRune, 'u', 0)}, keys...)...)
+		ed.handleEvent()
+	}
+
+	browse(tcell.NewEventKey(tcell.KeyDown, 0, 0), tcell.NewEventKey(tcell.KeyESC, 0, 0))
+	require.Equal(t, [][]rune{[]rune("b")}, buf.lines(), "cancelling restores the state")
+
+	browse(tcell.NewEventKey(tcell.KeyDown, 0, 0), tcell.NewEventKey(tcell.KeyEnter, 0, 0))
+	require.Equal(t, [][]rune{[]rune("a")}, buf.lines())
+
+	browse(tcell.NewEventKey(tcell.KeyDown, 0, 0), tcell.NewEventKey(tcell.KeyEnter, 0, 0))
+	require.Equal(t, [][]rune{[]rune("")}, buf.lines())
+}
+
+func TestPruneUndoTree(t *testing.T) {
+	tree := newUndoTree()
+	buf := newBuffer(nil)
+
+	// 1 -> 2 -> 3, with branch 2 -> 4 and the current state 3.
+	add := func(parent *undoNode) *undoNode {
+		tree.cur = parent
+		tree.add(&editOp{op: opInsertText, text: [][]rune{[]rune("x")}})
+		return tree.cur
+	}
+	n1 := add(tree.root)
+	n2 := add(n1)
+	n3 := add(n2)
+	n4 := add(n2)
+	tree.gotoNode(buf.lastView, n3)
+
+	const size = undoMaxSize/2 + 1
+	sizes := map[*undoNode]int{n1: size, n2: size, n3: size, n4: size}
+
+	keep, root := pruneUndoTree(tree, sizes, 4*size)
+	require.Equal(t, n2, root)
+
+	var kept []int
+	for _, node := range tree.nodes() {
+		if keep[node] {
+			kept = append(kept, node.seq)
+		}
+	}
+	require.Equal(t, []int{2, 3}, kept, "the oldest states and branches are dropped")
+}
@@ -4,15 +4,31 @@ import "github.com/mattn/go-runewidth"
 
 func runeWidth(s []rune) (w int) {
 	for _, r := range s {
-		if r == '\t' {
-			w += tabWidth
-		} else {
-			w += runewidth.RuneWidth(r)
-		}
+		w += runeCellWidth(r)
 	}
 	return w
 }
 
+func runeCellWidth(r rune) int {
+	if r == '\t' {
+		return tabWidth
+	}
+	return runewidth.RuneWidth(r)
+}
+
+// columnIndex returns the index of the first rune in s that is displayed at
+// or after display column col, or len(s) if there is none.
+func columnIndex(s []rune, col int) int {
+	w := 0
+	for idx, r := range s {
+		if w >= col {
+			return idx
+		}
+		w += runeCellWidth(r)
+	}
+	return len(s)
+}
+
 func runeEqual(a, b []rune) bool {
 	if len(a) != len(b) {
 		return false
@@ -42,3 +58,15 @@ func runeIndex(haystack, needle []rune) (idx int) {
 	}
 	return -1
 }
+
+// joinLines encodes lines as UTF-8, separated by line breaks.
+func joinLines(lines [][]rune) []byte {
+	var data []byte
+	for idx, line := range lines {
+		if idx > 0 {
+			data = append(data, '\n')
+		}
+		data = append(data, string(line)...)
+	}
+	return data
+}
@@ -95,3 +95,26 @@ func TestRuneWidth(t *testing.T) {
 		})
 	}
 }
+
+func TestColumnIndex(t *testing.T) {
+	testData := map[string]struct {
+		S             []rune
+		Col           int
+		ExpectedIndex int
+	}{
+		"simple":             {[]rune("abc"), 1, 1},
+		"beginning":          {[]rune("abc"), 0, 0},
+		"past-end":           {[]rune("abc"), 10, 3},
+		"empty":              {[]rune{}, 2, 0},
+		"after-tab":          {[]rune("\tabc"), 8, 1},
+		"within-tab":         {[]rune("\tabc"), 3, 1},
+		"chinese-characters": {[]rune("例子例子"), 4, 2},
+		"within-wide-char":   {[]rune("例子例子"), 3, 2},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			require.Equal(t, tt.ExpectedIndex, columnIndex(tt.S, tt.Col))
+		})
+	}
+}
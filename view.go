@@ -0,0 +1,190 @@
+package main
+
+// view is what a split shows of a buffer: the position of the cursor, how far
+// the text is scrolled and the selection. Every split has a view of its own,
+// so a buffer can be shown at different positions in several splits, see
+// split.go. Commands move the cursor of the view of the current split, and
+// edit its buffer through it, so that the other views of the buffer follow
+// the text.
+type view struct {
+	buf *buffer
+
+	x      int
+	y      int // line of the cursor relative to offset.
+	offset int // first line shown in the split.
+	height int // number of rows of text of the split, see arrange.
+
+	// long lines are wrapped at wrapWidth columns unless wrap is wrapNone,
+	// rowOffset is the first row of line offset that is displayed then.
+	// Otherwise, xOffset is the first display column that is displayed. See
+	// wrap.go.
+	wrap      wrapMode
+	wrapWidth int
+	rowOffset int
+	xOffset   int
+
+	// fields to track selected text:
+	selecting bool
+	blockMode bool // selection is a rectangle of display columns.
+	startX    int
+	startY    int
+	endX      int
+	endY      int
+}
+
+// adjustViews moves the cursors, scroll offsets and selections of the views
+// of buf along with the text around them after op was applied. The view that
+// made the edit, if any, is left to the command that made it.
+func (buf *buffer) adjustViews(op *editOp, from *view) {
+	for _, v := range buf.allViews() {
+		if v != from {
+			v.adjust(op)
+		}
+	}
+}
+
+// allViews returns the views of the splits that show buf, and the view that
+// it was last shown with, which is used again when a split shows it.
+func (buf *buffer) allViews() []*view {
+	if buf.lastView == nil {
+		return buf.views
+	}
+	for _, v := range buf.views {
+		if v == buf.lastView {
+			return buf.views
+		}
+	}
+	return append(buf.views[:len(buf.views):len(buf.views)], buf.lastView)
+}
+
+// adjust moves the cursor, the scroll offset and the selection of v along with
+// the text around them after op was applied.
+func (v *view) adjust(op *editOp) {
+	y, x := op.adjustPos(v.offset+v.y, v.x)
+	v.offset, _ = op.adjustPos(v.offset, 0)
+	v.y, v.x = y-v.offset, x
+	v.adjustSelection(op)
+}
+
+// adjustSelection moves the start and end of the selection along with the
+// text around them after op was applied, so that they stay within the text.
+func (v *view) adjustSelection(op *editOp) {
+	v.startY, v.startX = op.adjustPos(v.startY, v.startX)
+	v.endY, v.endX = op.adjustPos(v.endY, v.endX)
+}
+
+func (v *view) getSelection() (lowerY, lowerX, higherY, higherX int) {
+	lowerY, lowerX, higherY, higherX = v.startY, v.startX, v.endY, v.endX
+
+	if lowerY > higherY {
+		lowerY, higherY = higherY, lowerY
+		lowerX, higherX = higherX, lowerX
+	} else if lowerY == higherY && lowerX > higherX {
+		lowerX, higherX = higherX, lowerX
+	}
+
+	return
+}
+
+func (v *view) isWithinSelectedText(y, x int) bool {
+	lowerY, lowerX, higherY, higherX := v.getSelection()
+
+	if lowerY == higherY && lowerX == higherX {
+		return false
+	}
+
+	if y > lowerY && y < higherY {
+		return true
+	}
+	if y == lowerY && x >= lowerX && (y < higherY || (y == higherY && x < higherX)) {
+		return true
+	}
+	if y == higherY && x < higherX && (y > lowerY || (y == lowerY && x >= lowerX)) {
+		return true
+	}
+	return false
+}
+
+// getBlockSelection returns the lines and the display columns spanned by a
+// rectangular block selection. rightCol is exclusive.
+func (v *view) getBlockSelection() (lowerY, higherY, leftCol, rightCol int) {
+	lowerY, higherY = v.startY, v.endY
+	if lowerY > higherY {
+		lowerY, higherY = higherY, lowerY
+	}
+
+	leftCol, rightCol = v.buf.columnAt(v.startY, v.startX), v.buf.columnAt(v.endY, v.endX)
+	if leftCol > rightCol {
+		leftCol, rightCol = rightCol, leftCol
+	}
+
+	return
+}
+
+func (v *view) isWithinSelectedBlock(y, col int) bool {
+	lowerY, higherY, leftCol, rightCol := v.getBlockSelection()
+
+	return y >= lowerY && y <= higherY && col >= leftCol && col < rightCol
+}
+
+func (v *view) incrY() {
+	if v.wrap != wrapNone {
+		v.y++
+		v.scrollWrapped()
+		return
+	}
+
+	if v.y < v.height-1 {
+		v.y++
+	} else {
+		v.offset++
+	}
+}
+
+func (v *view) decrY() {
+	if v.wrap != wrapNone {
+		// wrapped lines are scrolled by scrollWrapped when needed.
+		if v.y > 0 {
+			v.y--
+		} else {
+			v.offset--
+			v.rowOffset = 0
+		}
+		return
+	}
+
+	if v.offset > 0 {
+		v.offset--
+	} else {
+		v.y--
+	}
+}
+
+func (v *view) correctX() {
+	if l := v.buf.lineLen(v.curLineIdx()); l < v.x {
+		v.x = l
+	}
+}
+
+func (v *view) correctY() {
+	for v.curLineIdx() >= v.buf.lineCount() {
+		v.decrY()
+	}
+}
+
+func (v *view) gotoLine(y int) {
+	for y > v.curLineIdx() {
+		v.incrY()
+	}
+	for y < v.curLineIdx() {
+		v.decrY()
+	}
+}
+
+func (v *view) curLineIdx() int {
+	return v.y + v.offset
+}
+
+func (v *view) curLine() []rune {
+	return v.buf.line(v.curLineIdx())
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEditsMoveOtherViews(t *testing.T) {
+	ed := newTestEditor(t, numberedLines(20))
+	scr := ed.scr.(tcell.SimulationScreen)
+
+	playKeys(t, ed, altX('2')...)
+	for i := 0; i < 6; i++ {
+		playKeys(t, ed, tcell.NewEventKey(tcell.KeyDown, 0, 0))
+	}
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRight, 0, 0), tcell.NewEventKey(tcell.KeyCtrlSpace, 0, 0), tcell.NewEventKey(tcell.KeyRight, 0, 0))
+	playKeys(t, ed, altX('o')...)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyEnter, 0, 0), tcell.NewEventKey(tcell.KeyEnter, 0, 0))
+	ed.redrawScreen()
+
+	require.Equal(t, "line 3", screenRow(scr, 0), "the other split keeps showing the same text")
+	playKeys(t, ed, altX('o')...)
+	require.Equal(t, []int{8, 2}, []int{ed.win.view.curLineIdx(), ed.win.view.x}, "the cursor of the other split stays at its text")
+	require.Equal(t, []int{8, 1, 8, 2}, []int{ed.win.view.startY, ed.win.view.startX, ed.win.view.endY, ed.win.view.endX}, "the selection of the other split stays at its text")
+
+	playKeys(t, ed, altX('o')...)
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlZ, 0, 0))
+	ed.redrawScreen()
+
+	require.Equal(t, "line 3", screenRow(scr, 0))
+	playKeys(t, ed, altX('o')...)
+	require.Equal(t, []int{6, 2}, []int{ed.win.view.curLineIdx(), ed.win.view.x}, "undo in another split moves the cursor back")
+
+	// removing the text at the cursor of the other split moves it to the start
+	// of the removed text.
+	playKeys(t, ed, altX('o')...)
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRight, 0, 0), tcell.NewEventKey(tcell.KeyCtrlSpace, 0, 0))
+	for i := 0; i < 8; i++ {
+		playKeys(t, ed, tcell.NewEventKey(tcell.KeyDown, 0, 0))
+	}
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlX, 0, 0))
+	ed.redrawScreen()
+
+	require.Equal(t, "line 8", screenRow(scr, 0))
+	playKeys(t, ed, altX('o')...)
+	require.Equal(t, []int{0, 1}, []int{ed.win.view.curLineIdx(), ed.win.view.x})
+}
+
+func TestViewHeight(t *testing.T) {
+	buf := newBufferFromFileContent([]byte(numberedLines(10)))
+	v := buf.lastView
+	v.height = 3
+
+	v.gotoLine(5)
+	require.Equal(t, []int{2, 3}, []int{v.y, v.offset})
+
+	v.gotoLine(9)
+	require.Equal(t, []int{2, 7}, []int{v.y, v.offset})
+
+	v.gotoLine(6)
+	require.Equal(t, []int{2, 4}, []int{v.y, v.offset})
+}
+
+func TestReplaceContentCorrectsAllViews(t *testing.T) {
+	ed := newTestEditor(t, numberedLines(20))
+	buf := ed.bufs[0]
+
+	playKeys(t, ed, altX('2')...)
+	for i := 0; i < 15; i++ {
+		playKeys(t, ed, tcell.NewEventKey(tcell.KeyDown, 0, 0))
+	}
+	playKeys(t, ed, altX('o')...)
+	for i := 0; i < 10; i++ {
+		playKeys(t, ed, tcell.NewEventKey(tcell.KeyDown, 0, 0))
+	}
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlSpace, 0, 0), tcell.NewEventKey(tcell.KeyDown, 0, 0))
+
+	buf.replaceContent(newBufferFromFileContent([]byte(numberedLines(5))))
+
+	for _, win := range ed.layout.windows() {
+		require.Equal(t, 4, win.view.curLineIdx(), "the cursor of every split stays within the text")
+		require.False(t, win.view.selecting)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"log"
+	"unicode"
+)
+
+// wrapMode is how lines that are longer than the screen is wide are
+// displayed.
+type wrapMode int
+
+const (
+	wrapNone  wrapMode = iota // long lines are cut off at the edge of the screen.
+	wrapChars                 // long lines continue in the next screen row.
+	wrapWords                 // like wrapChars, but rows end at word boundaries if possible.
+)
+
+func (m wrapMode) String() string {
+	switch m {
+	case wrapChars:
+		return "at characters"
+	case wrapWords:
+		return "at words"
+	default:
+		return "off"
+	}
+}
+
+// wrapLine returns the indexes of the runes of line that start a screen row
+// when line is wrapped at width columns. The first row always starts at 0. If
+// the last row is full, an empty row follows for the cursor at the end of the
+// line.
+func wrapLine(line []rune, width int, words bool) []int {
+	starts := []int{0}
+	if width <= 0 {
+		return starts
+	}
+
+	col := 0
+	for idx, r := range line {
+		w := runeCellWidth(r)
+		start := starts[len(starts)-1]
+		if col+w > width && idx > start {
+			brk := idx
+			if words {
+				for i := idx; i > start; i-- {
+					if unicode.IsSpace(line[i-1]) {
+						brk = i
+						break
+					}
+				}
+			}
+			starts = append(starts, brk)
+			col = runeWidth(line[brk:idx])
+		}
+		col += w
+	}
+
+	if col >= width {
+		starts = append(starts, len(line))
+	}
+
+	return starts
+}
+
+// rowOf returns the screen row in which the rune x is displayed, given the
+// starts of the rows of its line.
+func rowOf(starts []int, x int) int {
+	row := 0
+	for row+1 < len(starts) && starts[row+1] <= x {
+		row++
+	}
+	return row
+}
+
+// rowIndex returns the index of the rune of line that is displayed at or after
+// display column col of the screen row row. The index is always within the
+// row, so that the cursor stays in it.
+func rowIndex(line []rune, starts []int, row int, col int) int {
+	start, end := starts[row], len(line)
+	if row+1 < len(starts) {
+		end = starts[row+1] - 1
+	}
+	return start + columnIndex(line[start:end], col)
+}
+
+// wrapRows returns the starts of the screen rows of line y, see wrapLine.
+func (v *view) wrapRows(y int) []int {
+	if v.wrap == wrapNone {
+		return []int{0}
+	}
+	return wrapLine(v.buf.line(y), v.wrapWidth, v.wrap == wrapWords)
+}
+
+// cursorRow returns the screen row of the cursor within the current line, and
+// the display column of the cursor within that row. Cursor positions past the
+// end of the line, e.g. while moving between lines, count as its end.
+func (v *view) cursorRow() (row int, col int) {
+	line := v.curLine()
+	x := v.x
+	if x > len(line) {
+		x = len(line)
+	}
+	starts := v.wrapRows(v.curLineIdx())
+	row = rowOf(starts, x)
+	return row, runeWidth(line[starts[row]:x])
+}
+
+// scrollWrapped scrolls a view with wrapped lines so that the cursor is
+// within the rows of text of its split. offset is the first line
+// on the screen, rowOffset is the first row of it that is displayed.
+func (v *view) scrollWrapped() {
+	if v.wrap == wrapNone {
+		return
+	}
+
+	if rows := len(v.wrapRows(v.offset)); v.rowOffset >= rows {
+		v.rowOffset = rows - 1
+	}
+
+	curLineIdx := v.curLineIdx()
+	row, _ := v.cursorRow()
+
+	if v.y < 0 || (v.y == 0 && row < v.rowOffset) {
+		v.offset, v.rowOffset, v.y = curLineIdx, row, 0
+		return
+	}
+
+	screenRow := row - v.rowOffset
+	for i := v.offset; i < curLineIdx; i++ {
+		screenRow += len(v.wrapRows(i))
+	}
+
+	for ; screenRow >= v.height; screenRow-- {
+		if v.rowOffset+1 < len(v.wrapRows(v.offset)) {
+			v.rowOffset++
+		} else {
+			v.offset++
+			v.rowOffset = 0
+			v.y--
+		}
+	}
+}
+
+// scrollHorizontally scrolls a view whose long lines are cut off sideways so
+// that the cursor is visible and not at an edge of the screen, where < or $
+// show that a line is cut off. The view scrolls by half a screen at a time.
+func (v *view) scrollHorizontally(width int) {
+	if v.wrap != wrapNone {
+		v.xOffset = 0
+		return
+	}
+
+	col := runeWidth(v.curLine()[:v.x])
+
+	if (v.xOffset > 0 && col <= v.xOffset) || col >= v.xOffset+width-1 {
+		v.xOffset = col - width/2
+		if v.xOffset < 0 {
+			v.xOffset = 0
+		}
+		log.Printf("scrollHorizontally: cursor at column %d, xOffset = %d", col, v.xOffset)
+	}
+}
+
+// rowUp moves the cursor to the previous screen row of a view with wrapped
+// lines, keeping the display column if possible. It returns false if the
+// cursor is in the first row already.
+func (v *view) rowUp() bool {
+	row, col := v.cursorRow()
+
+	if row == 0 {
+		if v.curLineIdx() == 0 {
+			return false
+		}
+		v.decrY()
+		row = len(v.wrapRows(v.curLineIdx()))
+	}
+
+	v.x = rowIndex(v.curLine(), v.wrapRows(v.curLineIdx()), row-1, col)
+
+	return true
+}
+
+// rowDown moves the cursor to the next screen row of a view with wrapped
+// lines, keeping the display column if possible. It returns false if the
+// cursor is in the last row already.
+func (v *view) rowDown() bool {
+	row, col := v.cursorRow()
+
+	if row+1 >= len(v.wrapRows(v.curLineIdx())) {
+		if v.curLineIdx() >= v.buf.lineCount()-1 {
+			return false
+		}
+		// the cursor goes to the first row, scrolling must not consider its
+		// old position.
+		v.x = 0
+		v.incrY()
+		row = -1
+	}
+
+	v.x = rowIndex(v.curLine(), v.wrapRows(v.curLineIdx()), row+1, col)
+
+	v.scrollWrapped()
+
+	return true
+}
+
+// toggleSoftWrap switches the current split between cutting off long lines,
+// wrapping them at any character and wrapping them at word boundaries.
+func (e *editor) toggleSoftWrap() {
+	v := e.curView()
+	curBuf := v.buf
+
+	width := e.textWidth(curBuf)
+
+	v.wrap = (v.wrap + 1) % (wrapWords + 1)
+	v.wrapWidth = width
+	v.rowOffset = 0
+	v.scrollHorizontally(width)
+	v.scrollWrapped()
+
+	log.Printf("toggleSoftWrap: wrap mode is now %s", v.wrap)
+
+	e.showError("Soft wrap %s", v.wrap)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrapLine(t *testing.T) {
+	testData := map[string]struct {
+		line   string
+		width  int
+		words  bool
+		starts []int
+	}{
+		"empty":           {"", 4, false, []int{0}},
+		"short":           {"abc", 4, false, []int{0}},
+		"full":            {"abcd", 4, false, []int{0, 4}},
+		"chars":           {"abcdefghij", 4, false, []int{0, 4, 8}},
+		"words":           {"ab cd efgh", 6, true, []int{0, 6}},
+		"long word":       {"abcdefgh ij", 4, true, []int{0, 4, 8}},
+		"words disabled":  {"ab cd efgh", 6, false, []int{0, 6}},
+		"break in word":   {"ab cdef", 4, false, []int{0, 4}},
+		"word boundary":   {"ab cde", 4, true, []int{0, 3}},
+		"tab":             {"a\tbcd", 10, false, []int{0, 3}},
+		"wide characters": {"日本語", 4, false, []int{0, 2}},
+		"no width":        {"abc", 0, false, []int{0}},
+	}
+
+	for testName, tt := range testData {
+		t.Run(testName, func(t *testing.T) {
+			require.Equal(t, tt.starts, wrapLine([]rune(tt.line), tt.width, tt.words))
+		})
+	}
+}
+
+func TestSoftWrapCursorMovement(t *testing.T) {
+	ed := newTestEditor(t, strings.Repeat("a", 100)+"\nshort")
+	buf := ed.bufs[0]
+	v := buf.lastView
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRune, 'X', tcell.ModAlt), tcell.NewEventKey(tcell.KeyRune, 'w', 0))
+	require.Equal(t, wrapChars, v.wrap)
+
+	ed.redrawScreen()
+
+	scr := ed.scr.(tcell.SimulationScreen)
+	cells, _, _ := scr.GetContents()
+	require.Equal(t, 'a', cells[2*40+19].Runes[0], "the line continues in the third row")
+	require.Equal(t, 's', cells[3*40].Runes[0], "the next line follows the wrapped rows")
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyRight, 0, 0), tcell.NewEventKey(tcell.KeyDown, 0, 0))
+	require.Equal(t, []int{0, 41}, []int{v.curLineIdx(), v.x}, "Down goes to the next row of the line")
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyDown, 0, 0), tcell.NewEventKey(tcell.KeyDown, 0, 0))
+	require.Equal(t, []int{1, 1}, []int{v.curLineIdx(), v.x}, "Down goes from the last row to the next line")
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyUp, 0, 0))
+	require.Equal(t, []int{0, 81}, []int{v.curLineIdx(), v.x}, "Up goes to the last row of the previous line")
+
+	ed.redrawScreen()
+	x, y, visible := scr.GetCursor()
+	require.True(t, visible)
+	require.Equal(t, []int{1, 2}, []int{x, y})
+}
+
+func TestSoftWrapScrolling(t *testing.T) {
+	var lines []string
+	for i := 0; i < 10; i++ {
+		lines = append(lines, strings.Repeat(string(rune('a'+i)), 60))
+	}
+	ed := newTestEditor(t, strings.Join(lines, "\n"))
+	buf := ed.bufs[0]
+	v := buf.lastView
+	scr := ed.scr.(tcell.SimulationScreen)
+
+	ed.toggleSoftWrap()
+
+	// every line takes two of the eight rows of text.
+	for i := 0; i < 9; i++ {
+		playKeys(t, ed, tcell.NewEventKey(tcell.KeyDown, 0, 0))
+	}
+	require.Equal(t, 4, v.curLineIdx())
+	require.Equal(t, 1, v.offset)
+	require.Equal(t, 0, v.rowOffset)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyDown, 0, 0))
+	require.Equal(t, 1, v.offset)
+	require.Equal(t, 1, v.rowOffset, "the view scrolls by rows, not by lines")
+
+	ed.redrawScreen()
+	_, y, _ := scr.GetCursor()
+	require.Equal(t, 7, y)
+
+	for i := 0; i < 10; i++ {
+		playKeys(t, ed, tcell.NewEventKey(tcell.KeyUp, 0, 0))
+	}
+	require.Equal(t, 0, v.curLineIdx())
+	require.Equal(t, 0, v.offset)
+	require.Equal(t, 0, v.rowOffset)
+
+	ed.redrawScreen()
+	_, y, _ = scr.GetCursor()
+	require.Equal(t, 0, y)
+}
+
+func TestHorizontalScrolling(t *testing.T) {
+	ed := newTestEditor(t, strings.Repeat("abcdefghij", 10)+"\nshort")
+	buf := ed.bufs[0]
+	v := buf.lastView
+	scr := ed.scr.(tcell.SimulationScreen)
+
+	ed.redrawScreen()
+	cells, _, _ := scr.GetContents()
+	require.Equal(t, 'a', cells[0].Runes[0])
+	require.Equal(t, '$', cells[39].Runes[0], "the right edge shows that the line is cut off")
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyCtrlE, 0, 0))
+	ed.redrawScreen()
+	require.Equal(t, 80, v.xOffset)
+
+	cells, _, _ = scr.GetContents()
+	require.Equal(t, '<', cells[0].Runes[0], "the left edge shows that the line is cut off")
+	require.Equal(t, 'b', cells[1].Runes[0])
+	require.Equal(t, '<', cells[40].Runes[0], "all lines scroll sideways")
+	require.Equal(t, ' ', cells[41].Runes[0])
+	x, _, _ := scr.GetCursor()
+	require.Equal(t, 20, x)
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyDown, 0, 0))
+	ed.redrawScreen()
+	require.Equal(t, 0, v.xOffset, "the view scrolls back when the cursor is left of it")
+
+	playKeys(t, ed, tcell.NewEventKey(tcell.KeyUp, 0, 0))
+	for i := 0; i < 39; i++ {
+		playKeys(t, ed, tcell.NewEventKey(tcell.KeyRight, 0, 0))
+		ed.redrawScreen()
+	}
+	require.Equal(t, 19, v.xOffset, "the cursor doesn't go to the right edge")
+}